@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"github.com/redefik/notificationmanagement/config"
+	"github.com/redefik/notificationmanagement/coursehandler"
+	"log"
+)
+
+/*Command that copies the courses stored in the DynamoDB table into the BoltDB file used by the "bolt" course store.
+The table and the region are read from the configuration file, the destination file can be overridden by a flag.
+The mails are normalized while they are copied, so the table need not be converted by mailinglistmigration first*/
+
+var configurationFile = flag.String("config", "config/config.json", "Location of the config file.")
+var boltDbPath = flag.String("bolt", "", "BoltDB file to populate. If empty, boltDbPath of the config file is used.")
+
+func main() {
+	flag.Parse()
+	err := config.SetConfiguration(*configurationFile)
+	if err != nil {
+		log.Panicln(err)
+	}
+	if *boltDbPath == "" {
+		*boltDbPath = config.Configuration.BoltDbPath
+	}
+	if *boltDbPath == "" {
+		log.Fatalln("the BoltDB file has not been provided")
+	}
+	coursehandler.InitializeDynamoDbClient()
+	source := coursehandler.NewDynamoDbRepository(coursehandler.Client, config.Configuration.CoursesTableName,
+		config.Configuration.SubscriptionsTableName, config.Configuration.StudentsTableName,
+		config.Configuration.DeliveriesTableName)
+	destination, err := coursehandler.NewBoltRepository(*boltDbPath)
+	if err != nil {
+		log.Fatalln("cannot open the BoltDB file:", err)
+	}
+	copiedCourses, err := coursehandler.MigrateDynamoDbToBolt(source, destination)
+	destination.Close()
+	log.Println("Copied courses:", copiedCourses)
+	if err != nil {
+		log.Fatalln("migration interrupted:", err)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*Command line client of the bulk subscription endpoints of the microservice. It imports into the mailing list of a
+course the mails contained in a CSV or JSON file, printing the outcome of every rejected row, or exports the
+mailing list of a course in the same formats. The validation and the subscription of the mails are performed by the
+microservice, so the command can be used with every course store*/
+
+var serviceUrl = flag.String("url", "http://localhost:80/notification_management/api/v1.0", "Base URL of the microservice.")
+var courseName = flag.String("name", "", "Name of the course.")
+var courseDepartment = flag.String("department", "", "Department of the course.")
+var courseYear = flag.String("year", "", "Academic year of the course, e.g. 2018-2019.")
+var file = flag.String("file", "-", "File to import or to export to, - for the standard input or output.")
+var format = flag.String("format", "", "Format of the file, csv or json. By default it is inferred from the file extension.")
+var export = flag.Bool("export", false, "Export the mailing list of the course instead of importing it.")
+
+// importReport is the body of the response to an import
+type importReport struct {
+	Subscribed int `json:"subscribed"`
+	Rejected   int `json:"rejected"`
+	Rows       []struct {
+		Row    int    `json:"row"`
+		Mail   string `json:"mail"`
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	} `json:"rows"`
+}
+
+// mailingListUrl returns the URL of the mailing list of the course, with the provided additional query parameters
+func mailingListUrl(parameters url.Values) string {
+	parameters.Set("name", *courseName)
+	parameters.Set("department", *courseDepartment)
+	parameters.Set("year", *courseYear)
+	return strings.TrimSuffix(*serviceUrl, "/") + "/course/students?" + parameters.Encode()
+}
+
+// checkResponse terminates the command if the microservice did not return 200 OK
+func checkResponse(response *http.Response) {
+	if response.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(response.Body)
+		log.Fatalln("request failed:", response.Status, strings.TrimSpace(string(body)))
+	}
+}
+
+// exportMailingList writes the mailing list of the course to the output file
+func exportMailingList(fileFormat string) {
+	response, err := http.Get(mailingListUrl(url.Values{"format": {fileFormat}}))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer response.Body.Close()
+	checkResponse(response)
+	output := os.Stdout
+	if *file != "-" {
+		output, err = os.Create(*file)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer output.Close()
+	}
+	_, err = io.Copy(output, response.Body)
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// importMailingList sends the input file to the microservice and prints the rejected rows. The command fails if
+// some rows have been rejected
+func importMailingList(fileFormat string) {
+	input := os.Stdin
+	if *file != "-" {
+		var err error
+		input, err = os.Open(*file)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer input.Close()
+	}
+	contentType := "application/json"
+	if fileFormat == "csv" {
+		contentType = "text/csv"
+	}
+	response, err := http.Post(mailingListUrl(url.Values{}), contentType, input)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer response.Body.Close()
+	checkResponse(response)
+	var report importReport
+	err = json.NewDecoder(response.Body).Decode(&report)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	for _, row := range report.Rows {
+		if row.Status != "subscribed" {
+			fmt.Printf("row %d\t%s\t%s\t%s\n", row.Row, row.Mail, row.Status, row.Error)
+		}
+	}
+	fmt.Println("Subscribed:", report.Subscribed, "- Rejected:", report.Rejected)
+	if report.Rejected > 0 {
+		os.Exit(1)
+	}
+}
+
+func main() {
+	flag.Parse()
+	if *courseName == "" || *courseDepartment == "" || *courseYear == "" {
+		log.Fatalln("the name, the department and the year of the course are required")
+	}
+	fileFormat := *format
+	if fileFormat == "" {
+		fileFormat = strings.TrimPrefix(strings.ToLower(filepath.Ext(*file)), ".")
+	}
+	if fileFormat != "csv" && fileFormat != "json" {
+		log.Fatalln("unknown format, use -format=csv or -format=json")
+	}
+	if *export {
+		exportMailingList(fileFormat)
+	} else {
+		importMailingList(fileFormat)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"github.com/redefik/notificationmanagement/config"
+	"github.com/redefik/notificationmanagement/coursehandler"
+	"log"
+)
+
+/*One-shot command that moves the items of the DynamoDB Courses table from the legacy "Name_Department_Year"
+partition key to the encoded CourseKey. The table and the region are read from the configuration file.
+The courses that cannot be migrated (e.g. because they are modified during the migration) are reported, and the
+command can be run again to complete the migration*/
+
+var configurationFile = flag.String("config", "config/config.json", "Location of the config file.")
+
+func main() {
+	flag.Parse()
+	err := config.SetConfiguration(*configurationFile)
+	if err != nil {
+		log.Panicln(err)
+	}
+	coursehandler.InitializeDynamoDbClient()
+	repository := coursehandler.NewDynamoDbRepository(coursehandler.Client, config.Configuration.CoursesTableName,
+		config.Configuration.SubscriptionsTableName, config.Configuration.StudentsTableName,
+		config.Configuration.DeliveriesTableName)
+	migratedCourses, failedCourses, err := coursehandler.MigrateCourseKeys(repository)
+	log.Println("Migrated courses:", migratedCourses, "- Failed courses:", failedCourses)
+	if err != nil {
+		log.Fatalln("migration interrupted:", err)
+	}
+	if failedCourses > 0 {
+		log.Fatalln("some courses have not been migrated, run the command again")
+	}
+}
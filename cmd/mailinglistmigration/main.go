@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"github.com/redefik/notificationmanagement/config"
+	"github.com/redefik/notificationmanagement/coursehandler"
+	"log"
+)
+
+/*One-shot command that converts the mailing lists of the DynamoDB Courses table to string sets of normalized mails,
+merging the mails that differ only by case. The table and the region are read from the configuration file.
+The command must be run once before deploying the version of the microservice that uses string sets*/
+
+var configurationFile = flag.String("config", "config/config.json", "Location of the config file.")
+
+func main() {
+	flag.Parse()
+	err := config.SetConfiguration(*configurationFile)
+	if err != nil {
+		log.Panicln(err)
+	}
+	coursehandler.InitializeDynamoDbClient()
+	repository := coursehandler.NewDynamoDbRepository(coursehandler.Client, config.Configuration.CoursesTableName,
+		config.Configuration.SubscriptionsTableName, config.Configuration.StudentsTableName,
+		config.Configuration.DeliveriesTableName)
+	migratedCourses, mergedMails, err := coursehandler.MigrateMailingListsToStringSets(repository)
+	log.Println("Migrated courses:", migratedCourses, "- Merged mails:", mergedMails)
+	if err != nil {
+		log.Fatalln("migration interrupted:", err)
+	}
+}
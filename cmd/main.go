@@ -1,15 +1,22 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"github.com/gorilla/mux"
+	_ "github.com/lib/pq" // PostgreSQL driver used by the SQL course store
 	"github.com/redefik/notificationmanagement/config"
 	"github.com/redefik/notificationmanagement/coursehandler"
 	"github.com/redefik/notificationmanagement/notificationhandler"
 	"github.com/redefik/notificationmanagement/notificationthread"
 	"github.com/redefik/notificationmanagement/resthandler"
+	"io"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 // healthCheck exposed the endpoint used to check the state of the microservice
@@ -20,6 +27,12 @@ func healthCheck(w http.ResponseWriter, _ *http.Request) {
 
 var configurationFile = flag.String("config", "config/config.json", "Location of the config file.")
 
+// Time given by default to the requests and to the notifications in progress when the microservice is stopped, and
+// time given to the interrupted notifications to release their messages. Together they are shorter than the 30
+// seconds waited by ECS before killing the container
+const defaultShutdownTimeout = 20 * time.Second
+const releaseTimeout = 5 * time.Second
+
 func main() {
 	flag.Parse()
 	// Load the configuration parameters of the microservice
@@ -27,20 +40,91 @@ func main() {
 	if err != nil {
 		log.Panicln(err)
 	}
-	// The environment needed to make requests to AWS API is setup
-	coursehandler.InitializeDynamoDbClient()
-	err = notificationhandler.InitializeSesClient(config.Configuration.AwsSesRegion)
+	// The course data store and the environment needed to make requests to AWS API are setup
+	err = coursehandler.InitializeRepository()
+	if err != nil {
+		log.Panicln(err)
+	}
+	err = notificationhandler.InitializeSender()
 	if err != nil {
-		log.Println(err)
+		log.Panicln(err)
 	}
 	r := mux.NewRouter()
 	// Register the handlers for the various HTTP requests
 	r.HandleFunc("/", healthCheck).Methods(http.MethodGet)
 	r.HandleFunc("/notification_management/api/v1.0/course", resthandler.NewCourse).Methods(http.MethodPost)
 	r.HandleFunc("/notification_management/api/v1.0/course", resthandler.DeleteCourse).Methods(http.MethodDelete)
+	r.HandleFunc("/notification_management/api/v1.0/course", resthandler.ListCourses).Methods(http.MethodGet)
+	r.HandleFunc("/notification_management/api/v1.0/course/students", resthandler.GetCourseMailingList).Methods(http.MethodGet)
+	r.HandleFunc("/notification_management/api/v1.0/course/students", resthandler.ImportCourseSubscriptions).Methods(http.MethodPost)
+	r.HandleFunc("/notification_management/api/v1.0/course/template", resthandler.GetCourseTemplate).Methods(http.MethodGet)
+	r.HandleFunc("/notification_management/api/v1.0/course/template", resthandler.SetCourseTemplate).Methods(http.MethodPut)
+	r.HandleFunc("/notification_management/api/v1.0/course/language", resthandler.GetCourseLanguage).Methods(http.MethodGet)
+	r.HandleFunc("/notification_management/api/v1.0/course/language", resthandler.SetCourseLanguage).Methods(http.MethodPut)
 	r.HandleFunc("/notification_management/api/v1.0/course/student/{studentMail}", resthandler.AddCourseSubscription).Methods(http.MethodPut)
 	r.HandleFunc("/notification_management/api/v1.0/course/student/{studentMail}", resthandler.RemoveCourseSubscription).Methods(http.MethodDelete)
+	r.HandleFunc("/notification_management/api/v1.0/student/{studentMail}/courses", resthandler.GetStudentCourses).Methods(http.MethodGet)
+	r.HandleFunc("/notification_management/api/v1.0/student/{studentMail}/courses", resthandler.RemoveStudentSubscriptions).Methods(http.MethodDelete)
+	r.HandleFunc("/notification_management/api/v1.0/student/{studentMail}/locale", resthandler.GetStudentLocale).Methods(http.MethodGet)
+	r.HandleFunc("/notification_management/api/v1.0/student/{studentMail}/locale", resthandler.SetStudentLocale).Methods(http.MethodPut)
+	r.HandleFunc("/notification_management/api/v1.0/notification/preview", resthandler.PreviewNotification).Methods(http.MethodPost)
+	r.HandleFunc("/notification_management/api/v1.0/admin/templates", resthandler.ListTemplates).Methods(http.MethodGet)
+	r.HandleFunc("/notification_management/api/v1.0/admin/templates/sync", resthandler.SyncTemplates).Methods(http.MethodPost)
+	r.HandleFunc("/notification_management/api/v1.0/admin/templates/{templateName}", resthandler.UploadTemplate).Methods(http.MethodPut)
+	r.HandleFunc("/notification_management/api/v1.0/admin/templates/{templateName}", resthandler.DeleteTemplate).Methods(http.MethodDelete)
 	// launch a thread that polls a message queue and sends notificationthread to the student subscribed to the courses
-	go notificationthread.Run()
-	log.Fatal(http.ListenAndServe(config.Configuration.ListeningAddress, r))
+	ctx, stopConsumer := context.WithCancel(context.Background())
+	consumerStopped := make(chan struct{})
+	go func() {
+		notificationthread.Run(ctx, shutdownTimeout())
+		close(consumerStopped)
+	}()
+	server := &http.Server{Addr: config.Configuration.ListeningAddress, Handler: r}
+	go func() {
+		err := server.ListenAndServe()
+		if err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// On SIGTERM, sent when the container is stopped, the microservice stops accepting requests and reading the queue,
+	// and waits for the requests and the notifications in progress
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	<-signals
+	log.Println("Shutting down...")
+	stopConsumer()
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout())
+	defer cancelShutdown()
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Println("error in shutting down the HTTP server", err)
+	}
+	// the notifications still in progress after the timeout are interrupted, and their messages are released
+	select {
+	case <-consumerStopped:
+	case <-time.After(shutdownTimeout() + releaseTimeout):
+		log.Println("the notification thread did not stop in time")
+	}
+	closeResource(notificationhandler.MailSender)
+	closeResource(coursehandler.Repository)
+	log.Println("Shutdown completed")
+}
+
+// shutdownTimeout returns the time given to the requests and to the notifications in progress to complete
+func shutdownTimeout() time.Duration {
+	if config.Configuration.ShutdownTimeout > 0 {
+		return time.Duration(config.Configuration.ShutdownTimeout) * time.Second
+	}
+	return defaultShutdownTimeout
+}
+
+// closeResource closes the sender or the course store when they hold a connection or a file
+func closeResource(resource interface{}) {
+	if closer, ok := resource.(io.Closer); ok {
+		err := closer.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/redefik/notificationmanagement/config"
+	"github.com/redefik/notificationmanagement/deadletter"
+	"github.com/redefik/notificationmanagement/sqswrapper"
+	"log"
+	"strconv"
+	"time"
+)
+
+/*Command that sends back to the notification queue the notifications moved to the dead-letter store, configured by
+deadLetterQueueName or deadLetterDirectory. It is meant to be run once the cause of the failures, such as a missing
+course or attachment, has been fixed. With -dry-run the letters are printed and left in the store*/
+
+var configurationFile = flag.String("config", "config/config.json", "Location of the config file.")
+var dryRun = flag.Bool("dry-run", false, "Print the dead letters without sending them back to the queue.")
+var maxLetters = flag.Int("max", 0, "Maximum number of letters to redrive, all of them when 0.")
+
+func main() {
+	flag.Parse()
+	err := config.SetConfiguration(*configurationFile)
+	if err != nil {
+		log.Panicln(err)
+	}
+	sqsClient := sqswrapper.GetSqsClient()
+	err = deadletter.InitializeStore(sqsClient)
+	if err != nil {
+		log.Panicln(err)
+	}
+	if deadletter.Store == nil {
+		log.Fatalln("no dead-letter store is configured")
+	}
+	queueUrl, err := sqswrapper.GetMessageQueueUrl(sqsClient, config.Configuration.MessageQueueName)
+	if err != nil {
+		log.Panicln(err)
+	}
+	// the redrive time is part of the deduplication id, so a letter redriven twice is not discarded by a FIFO queue,
+	// while the id of the original notification is kept, so it is not sent again to the students who received it
+	redriveTime := strconv.FormatInt(time.Now().Unix(), 10)
+	handled, err := deadletter.Store.Process(*maxLetters, func(letter deadletter.Letter) (bool, error) {
+		log.Println(letter.Id, letter.FailedAt.Format(time.RFC3339), letter.Reason, letter.Body)
+		if *dryRun {
+			return false, nil
+		}
+		err := sqswrapper.SendMessageToQueue(context.Background(), sqsClient, queueUrl, letter.Body, letter.GroupId, letter.Id+"-"+redriveTime,
+			map[string]string{sqswrapper.NotificationIdAttribute: letter.NotificationId})
+		return err == nil, err
+	})
+	if err != nil {
+		log.Fatalln("redrive interrupted after", handled, "letters:", err)
+	}
+	if *dryRun {
+		log.Println(handled, "letters in the dead-letter store")
+	} else {
+		log.Println(handled, "letters sent back to the queue")
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"github.com/redefik/notificationmanagement/config"
+	"github.com/redefik/notificationmanagement/coursehandler"
+	"log"
+)
+
+/*One-shot command that rebuilds the DynamoDB Subscriptions table, used to find the courses of a student, from the
+mailing lists of the Courses table. The tables and the region are read from the configuration file.
+The command must be run once when the Subscriptions table is created, and again after coursekeymigration. It can run
+while the students subscribe: a subscription is deleted only if the mail is still missing from the mailing list, and a
+subscription written for a student who unsubscribes meanwhile is deleted by running the command again*/
+
+var configurationFile = flag.String("config", "config/config.json", "Location of the config file.")
+
+func main() {
+	flag.Parse()
+	err := config.SetConfiguration(*configurationFile)
+	if err != nil {
+		log.Panicln(err)
+	}
+	coursehandler.InitializeDynamoDbClient()
+	repository := coursehandler.NewDynamoDbRepository(coursehandler.Client, config.Configuration.CoursesTableName,
+		config.Configuration.SubscriptionsTableName, config.Configuration.StudentsTableName,
+		config.Configuration.DeliveriesTableName)
+	writtenSubscriptions, deletedSubscriptions, err := coursehandler.BackfillSubscriptions(repository)
+	log.Println("Written subscriptions:", writtenSubscriptions, "- Deleted subscriptions:", deletedSubscriptions)
+	if err != nil {
+		log.Fatalln("backfill interrupted:", err)
+	}
+}
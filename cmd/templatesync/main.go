@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"github.com/redefik/notificationmanagement/config"
+	"github.com/redefik/notificationmanagement/notificationhandler"
+	"log"
+)
+
+/*Command that uploads to SES the template files of the directory configured by templateDirectory
+(config/templates by default). It is meant to be run by the deployment pipeline, so that the templates used by the
+microservice are updated together with it. With -dry-run the differences are printed and nothing is changed*/
+
+var configurationFile = flag.String("config", "config/config.json", "Location of the config file.")
+var dryRun = flag.Bool("dry-run", false, "Print the differences without modifying SES.")
+var prune = flag.Bool("prune", false, "Delete the SES templates not defined by any template file.")
+var deleteTemplate = flag.String("delete", "", "Name of a SES template, not defined by any template file, to delete instead of synchronizing.")
+
+func main() {
+	flag.Parse()
+	err := config.SetConfiguration(*configurationFile)
+	if err != nil {
+		log.Panicln(err)
+	}
+	err = notificationhandler.InitializeSesClient(config.Configuration.AwsSesRegion)
+	if err != nil {
+		log.Panicln(err)
+	}
+	if *deleteTemplate != "" {
+		if *dryRun {
+			err = notificationhandler.Templates.CheckDeletion(*deleteTemplate)
+		} else {
+			err = notificationhandler.Templates.Delete(*deleteTemplate)
+		}
+		if err == notificationhandler.TemplateInUseError {
+			log.Fatalln(*deleteTemplate, "not deleted:", err, "- remove its file and run with -prune")
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if *dryRun {
+			log.Println(*deleteTemplate, "would be deleted")
+			return
+		}
+		log.Println(*deleteTemplate, "deleted")
+		return
+	}
+	var diffs []notificationhandler.TemplateDiff
+	if *dryRun {
+		diffs, err = notificationhandler.Templates.Diff()
+	} else {
+		diffs, err = notificationhandler.Templates.Sync(*prune)
+	}
+	for _, diff := range diffs {
+		log.Println(diff.Name, diff.Status, diff.ChangedParts, diff.Action)
+	}
+	if err != nil {
+		log.Fatalln("synchronization interrupted:", err)
+	}
+}
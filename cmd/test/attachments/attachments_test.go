@@ -0,0 +1,137 @@
+package attachments
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"github.com/redefik/notificationmanagement/config"
+	"github.com/redefik/notificationmanagement/entity"
+	"github.com/redefik/notificationmanagement/mailtemplate"
+	"github.com/redefik/notificationmanagement/notificationhandler"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestLoadAttachments tests that the inline attachments are decoded and that the invalid ones are refused
+func TestLoadAttachments(t *testing.T) {
+	config.Configuration.MaxAttachmentSize = 16
+	defer func() { config.Configuration.MaxAttachmentSize = 0 }()
+
+	message := entity.Notification{Attachments: []entity.Attachment{
+		{FileName: "../slides/room.pdf", Content: base64.StdEncoding.EncodeToString([]byte("room C1"))},
+	}}
+	loadedMessage, err := notificationhandler.LoadAttachments(context.Background(), message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attachment := loadedMessage.Attachments[0]
+	if attachment.FileName != "room.pdf" || attachment.ContentType != "application/pdf" || string(attachment.Data) != "room C1" {
+		t.Error("Unexpected attachment", attachment.FileName, attachment.ContentType, string(attachment.Data))
+	}
+
+	tests := []struct {
+		attachments   []entity.Attachment
+		expectedError error
+	}{
+		{[]entity.Attachment{{FileName: "notes.txt", Content: "not base64!"}}, notificationhandler.InvalidAttachmentError},
+		{[]entity.Attachment{{FileName: "notes.txt"}}, notificationhandler.InvalidAttachmentError},
+		{[]entity.Attachment{{Content: "bm90ZXM="}}, notificationhandler.InvalidAttachmentError},
+		{[]entity.Attachment{{FileName: "notes.txt", Key: "notes.txt", Content: "bm90ZXM="}}, notificationhandler.InvalidAttachmentError},
+		// no attachment bucket is configured
+		{[]entity.Attachment{{FileName: "notes.txt", Key: "notes.txt"}}, notificationhandler.InvalidAttachmentError},
+		{[]entity.Attachment{{FileName: "big.txt", Content: base64.StdEncoding.EncodeToString(make([]byte, 17))}}, notificationhandler.AttachmentTooLargeError},
+		{[]entity.Attachment{
+			{FileName: "first.txt", Content: base64.StdEncoding.EncodeToString(make([]byte, 10))},
+			{FileName: "second.txt", Content: base64.StdEncoding.EncodeToString(make([]byte, 10))},
+		}, notificationhandler.AttachmentTooLargeError},
+	}
+	for i, test := range tests {
+		_, err := notificationhandler.LoadAttachments(context.Background(), entity.Notification{Attachments: test.attachments})
+		if err != test.expectedError {
+			t.Error("Test", i, "expected", test.expectedError, "but got", err)
+		}
+	}
+}
+
+// TestFileSenderAttachments tests that the attachments follow the body of the mail in a multipart/mixed message
+func TestFileSenderAttachments(t *testing.T) {
+	directory, err := ioutil.TempDir("", "attachments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	mailTemplate, err := mailtemplate.Load(filepath.Join("..", "..", "..", "config", "templates", "mailtemplate.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender, err := notificationhandler.NewFileSender(directory, mailtemplate.Set{Default: mailTemplate})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := bytes.Repeat([]byte("%PDF-1.4 room change "), 20)
+	message := entity.Notification{Name: "testcourse", Year: "2018-2019", Message: "Room changed", Attachments: []entity.Attachment{
+		{FileName: "room change.pdf", Content: base64.StdEncoding.EncodeToString(content)},
+		{FileName: "lezione è spostata.txt", ContentType: "text/plain", Content: base64.StdEncoding.EncodeToString([]byte("C1"))},
+	}}
+	_, err = sender.SendNotification(context.Background(), message, "", "noreply@test.it", []string{"student@test.it"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob(filepath.Join(directory, "*.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatal("Expected 1 mail but got", files, err)
+	}
+	file, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	parsedMail, err := mail.ReadMessage(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, parameters, err := mime.ParseMediaType(parsedMail.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatal("Unexpected content type", mediaType, err)
+	}
+	var partTypes, fileNames []string
+	var attachedContent [][]byte
+	reader := multipart.NewReader(parsedMail.Body, parameters["boundary"])
+	for part, err := reader.NextPart(); err == nil; part, err = reader.NextPart() {
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		partTypes = append(partTypes, partType)
+		if part.Header.Get("Content-Disposition") == "" {
+			continue
+		}
+		_, dispositionParameters, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fileName, err := new(mime.WordDecoder).DecodeHeader(dispositionParameters["filename"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		fileNames = append(fileNames, fileName)
+		encoded, _ := ioutil.ReadAll(part)
+		decoded, err := base64.StdEncoding.DecodeString(string(encoded))
+		if err != nil {
+			t.Fatal(err)
+		}
+		attachedContent = append(attachedContent, decoded)
+	}
+	if len(partTypes) != 3 || partTypes[0] != "multipart/alternative" || partTypes[1] != "application/pdf" || partTypes[2] != "text/plain" {
+		t.Fatal("Unexpected parts", partTypes)
+	}
+	if fileNames[0] != "room change.pdf" || fileNames[1] != "lezione è spostata.txt" {
+		t.Error("Unexpected file names", fileNames)
+	}
+	if !bytes.Equal(attachedContent[0], content) || string(attachedContent[1]) != "C1" {
+		t.Error("Unexpected attachment content")
+	}
+}
@@ -0,0 +1,254 @@
+package boltstore
+
+import (
+	"github.com/redefik/notificationmanagement/coursehandler"
+	"github.com/redefik/notificationmanagement/entity"
+	"go.etcd.io/bbolt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestBoltStoreErrorContract tests that the BoltDB backend returns the same ad hoc errors of the DynamoDB backend
+func TestBoltStoreErrorContract(t *testing.T) {
+	directory, err := ioutil.TempDir("", "boltstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	repository, err := coursehandler.NewBoltRepository(filepath.Join(directory, "courses.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repository.Close()
+
+	course := entity.Course{Name: "testcourse", Department: "testdepartment", Year: "2018-2019"}
+	if err = repository.AddStudent(course, "student@test.it"); err != coursehandler.NotFoundError {
+		t.Error("Expected NotFoundError but got", err)
+	}
+	if err = repository.CreateCourse(course); err != nil {
+		t.Fatal(err)
+	}
+	if err = repository.CreateCourse(course); err != coursehandler.ConflictError {
+		t.Error("Expected ConflictError but got", err)
+	}
+	if err = repository.AddStudent(course, "student@test.it"); err != nil {
+		t.Fatal(err)
+	}
+	if err = repository.AddStudent(course, "student@test.it"); err != coursehandler.ConflictMailError {
+		t.Error("Expected ConflictMailError but got", err)
+	}
+	if err = repository.DeleteCourse(course); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repository.GetCourseMailingList(course); err != coursehandler.NotFoundError {
+		t.Error("Expected NotFoundError but got", err)
+	}
+}
+
+// TestBoltStoreDurability tests that the mailing lists survive the reopening of the BoltDB file
+func TestBoltStoreDurability(t *testing.T) {
+	directory, err := ioutil.TempDir("", "boltstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "courses.db")
+
+	course := entity.Course{Name: "testcourse", Department: "testdepartment", Year: "2018-2019"}
+	repository, err := coursehandler.NewBoltRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repository.CreateCourse(course)
+	repository.AddStudent(course, "first@test.it")
+	repository.AddStudent(course, "second@test.it")
+	repository.RemoveStudent(course, "first@test.it")
+	repository.Close()
+
+	repository, err = coursehandler.NewBoltRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repository.Close()
+	mailingList, err := repository.GetCourseMailingList(course)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mailingList) != 1 || mailingList[0] != "second@test.it" {
+		t.Error("Expected [second@test.it] but got", mailingList)
+	}
+}
+
+// TestBoltStoreLegacyKeys tests that the entries written with the legacy "Name_Department_Year" key are converted
+// when the BoltDB file is opened
+func TestBoltStoreLegacyKeys(t *testing.T) {
+	directory, err := ioutil.TempDir("", "boltstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "courses.db")
+
+	db, err := bbolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucket([]byte("Courses"))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte("testcourse_testdepartment_2018-2019"), []byte(`["student@test.it"]`))
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repository, err := coursehandler.NewBoltRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repository.Close()
+	course := entity.Course{Name: "testcourse", Department: "testdepartment", Year: "2018-2019"}
+	mailingList, err := repository.GetCourseMailingList(course)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mailingList) != 1 || mailingList[0] != "student@test.it" {
+		t.Error("Expected [student@test.it] but got", mailingList)
+	}
+}
+
+// TestBoltStoreListCourses tests the pagination of the courses
+func TestBoltStoreListCourses(t *testing.T) {
+	directory, err := ioutil.TempDir("", "boltstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	repository, err := coursehandler.NewBoltRepository(filepath.Join(directory, "courses.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repository.Close()
+
+	for _, name := range []string{"c", "a", "b"} {
+		repository.CreateCourse(entity.Course{Name: name, Department: "testdepartment", Year: "2018-2019"})
+	}
+	repository.CreateCourse(entity.Course{Name: "a", Department: "otherdepartment", Year: "2018-2019"})
+	filter := coursehandler.CourseFilter{Department: "testdepartment"}
+	courses, next, err := repository.ListCourses(filter, "", 2)
+	if err != nil || len(courses) != 2 || courses[0].Name != "a" || courses[1].Name != "b" || next == "" {
+		t.Fatal("Unexpected first page", courses, next, err)
+	}
+	courses, next, err = repository.ListCourses(filter, next, 2)
+	if err != nil || len(courses) != 1 || courses[0].Name != "c" || next != "" {
+		t.Error("Unexpected last page", courses, next, err)
+	}
+}
+
+// TestBoltStoreStudentCourses tests that the index of the students is built for the files written before its
+// introduction and kept up to date by the subscriptions
+func TestBoltStoreStudentCourses(t *testing.T) {
+	directory, err := ioutil.TempDir("", "boltstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "courses.db")
+
+	// a file without the index of the students
+	db, err := bbolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucket([]byte("Courses"))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte("a#testdepartment#2018-2019"), []byte(`["student@test.it"]`))
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repository, err := coursehandler.NewBoltRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repository.Close()
+	first := entity.Course{Name: "a", Department: "testdepartment", Year: "2018-2019"}
+	second := entity.Course{Name: "b", Department: "testdepartment", Year: "2018-2019"}
+	repository.CreateCourse(second)
+	repository.AddStudent(second, "student@test.it")
+
+	courses, err := repository.GetStudentCourses("student@test.it")
+	if err != nil || len(courses) != 2 || courses[0] != first || courses[1] != second {
+		t.Fatal("Unexpected courses", courses, err)
+	}
+	repository.DeleteCourse(second)
+	courses, err = repository.RemoveStudentFromAllCourses("student@test.it")
+	if err != nil || len(courses) != 1 || courses[0] != first {
+		t.Fatal("Unexpected removed courses", courses, err)
+	}
+	mailingList, err := repository.GetCourseMailingList(first)
+	if err != nil || len(mailingList) != 0 {
+		t.Error("Expected an empty mailing list but got", mailingList, err)
+	}
+}
+
+// TestBoltStoreImportCourse tests that the mixed-case duplicates of an imported mailing list are merged, so that the
+// students can be removed and subscribed again regardless of the case of their mail
+func TestBoltStoreImportCourse(t *testing.T) {
+	directory, err := ioutil.TempDir("", "boltstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	repository, err := coursehandler.NewBoltRepository(filepath.Join(directory, "courses.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repository.Close()
+
+	course := entity.Course{Name: "testcourse", Department: "testdepartment", Year: "2018-2019"}
+	err = repository.ImportCourse(course, []string{"Student@Test.it", "student@test.it", " other@test.it"}, "", "it")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mailingList, err := repository.GetCourseMailingList(course)
+	if err != nil || !reflect.DeepEqual(mailingList, []string{"student@test.it", "other@test.it"}) {
+		t.Fatal("Unexpected mailing list", mailingList, err)
+	}
+	if err = repository.RemoveStudent(course, "STUDENT@test.it"); err != nil {
+		t.Fatal(err)
+	}
+	mailingList, _ = repository.GetCourseMailingList(course)
+	courses, err := repository.GetStudentCourses("student@test.it")
+	if !reflect.DeepEqual(mailingList, []string{"other@test.it"}) || err != nil || len(courses) != 0 {
+		t.Error("Expected the student to be removed but got", mailingList, courses, err)
+	}
+
+	// importing the course again replaces the subscriptions of the previous mailing list
+	err = repository.ImportCourse(course, []string{"Third@Test.it"}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	courses, err = repository.GetStudentCourses("other@test.it")
+	if err != nil || len(courses) != 0 {
+		t.Error("Expected no courses but got", courses, err)
+	}
+	courses, err = repository.GetStudentCourses("third@test.it")
+	if err != nil || len(courses) != 1 || courses[0] != course {
+		t.Error("Unexpected courses", courses, err)
+	}
+	if err = repository.AddStudent(course, "THIRD@test.it"); err != coursehandler.ConflictMailError {
+		t.Error("Expected ConflictMailError but got", err)
+	}
+}
@@ -0,0 +1,139 @@
+package bulksubscription
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/redefik/notificationmanagement/config"
+	"github.com/redefik/notificationmanagement/coursehandler"
+	"github.com/redefik/notificationmanagement/entity"
+	"github.com/redefik/notificationmanagement/resthandler"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var testCourse = entity.Course{Name: "testcourse", Department: "testdepartment", Year: "2018-2019"}
+
+const mailingListUrl = "/notification_management/api/v1.0/course/students?name=testcourse&department=testdepartment&year=2018-2019"
+
+// importReport is the body of the response to an import
+type importReport struct {
+	Subscribed int `json:"subscribed"`
+	Rejected   int `json:"rejected"`
+	Rows       []struct {
+		Row    int    `json:"row"`
+		Mail   string `json:"mail"`
+		Status string `json:"status"`
+	} `json:"rows"`
+}
+
+// createTestMicroserviceBulkSubscription builds an http handler used to test the import and the export of the
+// mailing lists
+func createTestMicroserviceBulkSubscription() http.Handler {
+	r := mux.NewRouter()
+	r.HandleFunc("/notification_management/api/v1.0/course/students", resthandler.GetCourseMailingList).Methods(http.MethodGet)
+	r.HandleFunc("/notification_management/api/v1.0/course/students", resthandler.ImportCourseSubscriptions).Methods(http.MethodPost)
+	return r
+}
+
+// setup creates the test course, with a student already subscribed, in an in-memory course data store
+func setup(t *testing.T) {
+	config.Configuration.CourseStore = coursehandler.MemoryStore
+	err := coursehandler.InitializeRepository()
+	if err != nil {
+		t.Fatal(err)
+	}
+	coursehandler.Repository.CreateCourse(testCourse)
+	coursehandler.Repository.AddStudent(testCourse, "subscribed@test.it")
+}
+
+// makeRequest simulates a request-response interaction between client and microservice
+func makeRequest(method string, url string, contentType string, body string) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest(method, url, strings.NewReader(body))
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	response := httptest.NewRecorder()
+	createTestMicroserviceBulkSubscription().ServeHTTP(response, request)
+	return response
+}
+
+// importMailingList imports the body and decodes the report, failing the test if the request is not successful
+func importMailingList(t *testing.T, contentType string, body string) importReport {
+	response := makeRequest(http.MethodPost, mailingListUrl, contentType, body)
+	if response.Code != http.StatusOK {
+		t.Fatal("Expected 200 OK but got " + strconv.Itoa(response.Code) + " " + http.StatusText(response.Code))
+	}
+	var report importReport
+	err := json.Unmarshal(response.Body.Bytes(), &report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+// TestImportCsv tests the import of a CSV file with a header: every row must be reported with its own status
+func TestImportCsv(t *testing.T) {
+	setup(t)
+
+	report := importMailingList(t, "text/csv; charset=utf-8",
+		"surname,email\nRossi,first@test.it\nBianchi,notamail\nVerdi,Subscribed@test.it\nNeri,FIRST@test.it\nBruno,second@test.it\n")
+	expectedStatuses := []string{"subscribed", "invalid mail", "already subscribed", "already subscribed", "subscribed"}
+	if report.Subscribed != 2 || report.Rejected != 3 || len(report.Rows) != len(expectedStatuses) {
+		t.Fatal("Unexpected report", report)
+	}
+	for i, row := range report.Rows {
+		if row.Row != i+2 || row.Status != expectedStatuses[i] {
+			t.Error("Unexpected row", row)
+		}
+	}
+	mailingList, _ := coursehandler.Repository.GetCourseMailingList(testCourse)
+	if len(mailingList) != 3 {
+		t.Error("Expected 3 students but got", mailingList)
+	}
+}
+
+// TestExportImportRoundTrip tests that an exported mailing list can be imported again, both as CSV and as JSON
+func TestExportImportRoundTrip(t *testing.T) {
+	for _, format := range []string{"csv", "json"} {
+		setup(t)
+		coursehandler.Repository.AddStudent(testCourse, "other@test.it")
+		response := makeRequest(http.MethodGet, mailingListUrl+"&format="+format, "", "")
+		if response.Code != http.StatusOK {
+			t.Fatal("Expected 200 OK but got " + strconv.Itoa(response.Code) + " " + http.StatusText(response.Code))
+		}
+		exportedList := response.Body.String()
+
+		coursehandler.Repository.RemoveStudent(testCourse, "other@test.it")
+		report := importMailingList(t, response.Header().Get("Content-Type"), exportedList)
+		if report.Subscribed != 1 || report.Rejected != 1 {
+			t.Error(format+": unexpected report", report)
+		}
+	}
+}
+
+// TestImportErrors tests the errors returned for malformed requests and for a not existent course
+func TestImportErrors(t *testing.T) {
+	setup(t)
+
+	steps := []struct {
+		url          string
+		contentType  string
+		body         string
+		expectedCode int
+	}{
+		{mailingListUrl, "application/json", `{"students": "student@test.it"}`, http.StatusBadRequest},
+		{mailingListUrl, "application/xml", `<students/>`, http.StatusUnsupportedMediaType},
+		{"/notification_management/api/v1.0/course/students?name=testcourse", "text/csv", "student@test.it", http.StatusBadRequest},
+		{strings.Replace(mailingListUrl, "testcourse", "othercourse", 1), "text/csv", "student@test.it", http.StatusNotFound},
+	}
+	for _, step := range steps {
+		response := makeRequest(http.MethodPost, step.url, step.contentType, step.body)
+		if response.Code != step.expectedCode {
+			t.Error(step.url + ": expected " + strconv.Itoa(step.expectedCode) + " but got " +
+				strconv.Itoa(response.Code) + " " + http.StatusText(response.Code))
+		}
+	}
+}
@@ -19,6 +19,10 @@ import (
 	"testing"
 )
 
+// Partition keys of the items used for testing purpose
+var testCourseKey = coursehandler.CourseKey{Name: "testcourse", Department: "testdepartment", Year: "2018-2019"}.Encode()
+var testExistentCourseKey = coursehandler.CourseKey{Name: "testexistentcourse", Department: "testdepartment", Year: "2018-2019"}.Encode()
+
 // createTestMicroserviceCourseCreation builds an http handler used to test functionality of course creation
 func createTestMicroserviceCourseCreation() http.Handler {
 	r := mux.NewRouter()
@@ -31,7 +35,7 @@ func setup() {
 	newSession := session.Must(session.NewSessionWithOptions(session.Options{
 		SharedConfigState: session.SharedConfigEnable,
 	}))
-	courseItem := coursehandler.CourseCreationItem{CourseName: "testexistentcourse_testdepartment_2018-2019"}
+	courseItem := coursehandler.CourseItem{CourseName: testExistentCourseKey}
 	marshaledCourse, err := dynamodbattribute.MarshalMap(courseItem)
 	if err != nil {
 		log.Println(err)
@@ -55,7 +59,7 @@ func tearDown() {
 	input := &dynamodb.DeleteItemInput{
 		Key: map[string]*dynamodb.AttributeValue{
 			"CourseName": {
-				S: aws.String("testcourse_testdepartment_2018-2019"),
+				S: aws.String(testCourseKey),
 			},
 		},
 		TableName: aws.String(config.Configuration.CoursesTableName),
@@ -68,7 +72,7 @@ func tearDown() {
 	input = &dynamodb.DeleteItemInput{
 		Key: map[string]*dynamodb.AttributeValue{
 			"CourseName": {
-				S: aws.String("testexistentcourse_testdepartment_2018-2019"),
+				S: aws.String(testExistentCourseKey),
 			},
 		},
 		TableName: aws.String(config.Configuration.CoursesTableName),
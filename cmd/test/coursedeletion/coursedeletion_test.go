@@ -19,6 +19,9 @@ import (
 	"testing"
 )
 
+// Partition keys of the items used for testing purpose
+var testCourseDeletionKey = coursehandler.CourseKey{Name: "testcoursedeletion", Department: "testdepartment", Year: "2018-2019"}.Encode()
+
 // createTestMicroserviceCourseDeletion builds an http handler used to test functionality of course deletion
 func createTestMicroserviceCourseDeletion() http.Handler {
 	r := mux.NewRouter()
@@ -31,7 +34,7 @@ func setup() {
 	newSession := session.Must(session.NewSessionWithOptions(session.Options{
 		SharedConfigState: session.SharedConfigEnable,
 	}))
-	courseItem := coursehandler.CourseCreationItem{CourseName: "testcoursedeletion_testdepartment_2018-2019"}
+	courseItem := coursehandler.CourseItem{CourseName: testCourseDeletionKey}
 	marshaledCourse, err := dynamodbattribute.MarshalMap(courseItem)
 	if err != nil {
 		log.Println(err)
@@ -0,0 +1,47 @@
+package coursekey
+
+import (
+	"github.com/redefik/notificationmanagement/coursehandler"
+	"testing"
+)
+
+// TestCourseKeyRoundTrip tests that decoding an encoded key returns the original key, also when the components
+// contain the characters used by the encoding
+func TestCourseKeyRoundTrip(t *testing.T) {
+	keys := []coursehandler.CourseKey{
+		{Name: "Advanced Calculus", Department: "Science", Year: "2018-2019"},
+		{Name: "Calculus_1", Department: "Science_Dept", Year: "2018-2019"},
+		{Name: "C#", Department: "Computer%Science", Year: "2018-2019"},
+		{Name: "", Department: "", Year: ""},
+	}
+	for _, key := range keys {
+		decodedKey, err := coursehandler.ParseCourseKey(key.Encode())
+		if err != nil {
+			t.Error("Cannot decode", key.Encode(), err)
+			continue
+		}
+		if decodedKey != key {
+			t.Error("Expected", key, "but got", decodedKey)
+		}
+	}
+}
+
+// TestCourseKeyNoCollisions tests that different keys are never encoded in the same way
+func TestCourseKeyNoCollisions(t *testing.T) {
+	first := coursehandler.CourseKey{Name: "a#b", Department: "c", Year: "d"}
+	second := coursehandler.CourseKey{Name: "a", Department: "b#c", Year: "d"}
+	if first.Encode() == second.Encode() {
+		t.Error("Different keys encoded as", first.Encode())
+	}
+}
+
+// TestCourseKeyInvalid tests that strings not produced by Encode are rejected
+func TestCourseKeyInvalid(t *testing.T) {
+	invalidKeys := []string{"Calculus_Science_2018-2019", "a#b", "a#b#c#d", "a%zz#b#c"}
+	for _, invalidKey := range invalidKeys {
+		_, err := coursehandler.ParseCourseKey(invalidKey)
+		if err != coursehandler.InvalidCourseKeyError {
+			t.Error("Expected InvalidCourseKeyError for", invalidKey, "but got", err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package coursereading
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/redefik/notificationmanagement/config"
+	"github.com/redefik/notificationmanagement/coursehandler"
+	"github.com/redefik/notificationmanagement/entity"
+	"github.com/redefik/notificationmanagement/resthandler"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+)
+
+// createTestMicroserviceCourseReading builds an http handler used to test the functionality about reading courses
+// and mailing lists
+func createTestMicroserviceCourseReading() http.Handler {
+	r := mux.NewRouter()
+	r.HandleFunc("/notification_management/api/v1.0/course", resthandler.ListCourses).Methods(http.MethodGet)
+	r.HandleFunc("/notification_management/api/v1.0/course/students", resthandler.GetCourseMailingList).Methods(http.MethodGet)
+	return r
+}
+
+// setup populates an in-memory course data store with the courses needed for testing purpose
+func setup() {
+	config.Configuration.CourseStore = coursehandler.MemoryStore
+	coursehandler.InitializeRepository()
+	courses := []entity.Course{
+		{Name: "testcoursea", Department: "testdepartment", Year: "2018-2019"},
+		{Name: "testcourseb", Department: "testdepartment", Year: "2018-2019"},
+		{Name: "testcoursec", Department: "testdepartment", Year: "2019-2020"},
+		{Name: "testcoursed", Department: "otherdepartment", Year: "2018-2019"},
+	}
+	for _, course := range courses {
+		coursehandler.Repository.CreateCourse(course)
+	}
+	coursehandler.Repository.AddStudent(courses[0], "student@test.it")
+}
+
+// TestMain perform setup needed by the test
+func TestMain(m *testing.M) {
+	setup()
+	os.Exit(m.Run())
+}
+
+// makeRequest simulates a request-response interaction between client and microservice
+func makeRequest(url string) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest(http.MethodGet, url, nil)
+	response := httptest.NewRecorder()
+	handler := createTestMicroserviceCourseReading()
+	handler.ServeHTTP(response, request)
+	return response
+}
+
+// listCourses requests a page of courses, failing the test if the request is not successful
+func listCourses(t *testing.T, url string) ([]entity.Course, string) {
+	response := makeRequest(url)
+	if response.Code != http.StatusOK {
+		t.Fatal("Expected 200 OK but got " + strconv.Itoa(response.Code) + " " + http.StatusText(response.Code))
+	}
+	var page struct {
+		Courses []entity.Course `json:"courses"`
+		Next    string          `json:"next"`
+	}
+	err := json.Unmarshal(response.Body.Bytes(), &page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return page.Courses, page.Next
+}
+
+// TestListCoursesPagination tests that all the courses of a department are returned, page by page
+func TestListCoursesPagination(t *testing.T) {
+	url := "/notification_management/api/v1.0/course?department=testdepartment&limit=2"
+	courses, next := listCourses(t, url)
+	if len(courses) != 2 || next == "" {
+		t.Fatal("Expected a full first page but got", courses, next)
+	}
+	otherCourses, next := listCourses(t, url+"&next="+next)
+	if len(otherCourses) != 1 || next != "" {
+		t.Fatal("Expected a last page with one course but got", otherCourses, next)
+	}
+	courses = append(courses, otherCourses...)
+	for i, name := range []string{"testcoursea", "testcourseb", "testcoursec"} {
+		if courses[i].Name != name {
+			t.Error("Expected", name, "but got", courses[i].Name)
+		}
+	}
+}
+
+// TestListCoursesFilters tests that department and year filters are combined
+func TestListCoursesFilters(t *testing.T) {
+	courses, _ := listCourses(t, "/notification_management/api/v1.0/course?department=testdepartment&year=2019-2020")
+	if len(courses) != 1 || courses[0].Name != "testcoursec" {
+		t.Error("Expected [testcoursec] but got", courses)
+	}
+}
+
+// TestListCoursesInvalidParameters tests the requests with a not valid limit or page token
+func TestListCoursesInvalidParameters(t *testing.T) {
+	for _, url := range []string{
+		"/notification_management/api/v1.0/course?limit=0",
+		"/notification_management/api/v1.0/course?limit=abc",
+		"/notification_management/api/v1.0/course?next=!!!",
+	} {
+		response := makeRequest(url)
+		if response.Code != http.StatusBadRequest {
+			t.Error(url + ": expected 400 Bad Request but got " + strconv.Itoa(response.Code))
+		}
+	}
+}
+
+// TestGetCourseMailingList tests the reading of the mailing list of an existent and of a not existent course
+func TestGetCourseMailingList(t *testing.T) {
+	response := makeRequest("/notification_management/api/v1.0/course/students?name=testcoursea&department=testdepartment&year=2018-2019")
+	if response.Code != http.StatusOK {
+		t.Fatal("Expected 200 OK but got " + strconv.Itoa(response.Code) + " " + http.StatusText(response.Code))
+	}
+	var mailingList struct {
+		Students []string `json:"students"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &mailingList)
+	if len(mailingList.Students) != 1 || mailingList.Students[0] != "student@test.it" {
+		t.Error("Expected [student@test.it] but got", mailingList.Students)
+	}
+
+	response = makeRequest("/notification_management/api/v1.0/course/students?name=missing&department=testdepartment&year=2018-2019")
+	if response.Code != http.StatusNotFound {
+		t.Error("Expected 404 Not Found but got " + strconv.Itoa(response.Code) + " " + http.StatusText(response.Code))
+	}
+}
@@ -0,0 +1,137 @@
+package coursetemplate
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/redefik/notificationmanagement/config"
+	"github.com/redefik/notificationmanagement/coursehandler"
+	"github.com/redefik/notificationmanagement/entity"
+	"github.com/redefik/notificationmanagement/notificationhandler"
+	"github.com/redefik/notificationmanagement/resthandler"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// checkCourseTemplate tests that a repository stores the template of a course until it is cleared or the course is
+// deleted
+func checkCourseTemplate(t *testing.T, repository coursehandler.CourseRepository) {
+	course := entity.Course{Name: "testcourse", Department: "testdepartment", Year: "2018-2019"}
+	if err := repository.SetCourseTemplate(course, "CourseTemplate"); err != coursehandler.NotFoundError {
+		t.Error("Expected NotFoundError but got", err)
+	}
+	if err := repository.CreateCourse(course); err != nil {
+		t.Fatal(err)
+	}
+	if templateName, err := repository.GetCourseTemplate(course); err != nil || templateName != "" {
+		t.Error("Expected no template but got", templateName, err)
+	}
+	if err := repository.SetCourseTemplate(course, "CourseTemplate"); err != nil {
+		t.Fatal(err)
+	}
+	if templateName, err := repository.GetCourseTemplate(course); err != nil || templateName != "CourseTemplate" {
+		t.Error("Expected CourseTemplate but got", templateName, err)
+	}
+	if err := repository.SetCourseTemplate(course, ""); err != nil {
+		t.Fatal(err)
+	}
+	if templateName, err := repository.GetCourseTemplate(course); err != nil || templateName != "" {
+		t.Error("Expected no template but got", templateName, err)
+	}
+	// the template does not survive the deletion of the course
+	repository.SetCourseTemplate(course, "CourseTemplate")
+	if err := repository.DeleteCourse(course); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repository.GetCourseTemplate(course); err != coursehandler.NotFoundError {
+		t.Error("Expected NotFoundError but got", err)
+	}
+	repository.CreateCourse(course)
+	if templateName, err := repository.GetCourseTemplate(course); err != nil || templateName != "" {
+		t.Error("Expected no template but got", templateName, err)
+	}
+}
+
+// TestCourseTemplateStores tests the template of a course in the memory, BoltDB and SQL backends
+func TestCourseTemplateStores(t *testing.T) {
+	directory, err := ioutil.TempDir("", "coursetemplate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	checkCourseTemplate(t, coursehandler.NewMemoryRepository())
+	boltRepository, err := coursehandler.NewBoltRepository(filepath.Join(directory, "courses.bolt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer boltRepository.Close()
+	checkCourseTemplate(t, boltRepository)
+	sqlRepository, err := coursehandler.NewSqlRepository("sqlite3", filepath.Join(directory, "courses.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlRepository.Close()
+	checkCourseTemplate(t, sqlRepository)
+}
+
+// TestResolveTemplateName tests the fallback from the course template to the department and the global ones
+func TestResolveTemplateName(t *testing.T) {
+	config.Configuration.MailTemplate = "MailTemplate"
+	config.Configuration.DepartmentTemplates = map[string]string{"Science": "ScienceTemplate"}
+	tests := []struct {
+		courseTemplate string
+		department     string
+		expected       string
+	}{
+		{"CourseTemplate", "Science", "CourseTemplate"},
+		{"", "Science", "ScienceTemplate"},
+		{"", "Arts", "MailTemplate"},
+	}
+	for _, test := range tests {
+		if templateName := notificationhandler.ResolveTemplateName(test.courseTemplate, test.department); templateName != test.expected {
+			t.Error("Expected " + test.expected + " but got " + templateName)
+		}
+	}
+}
+
+// TestSetCourseTemplate tests that only the templates of the template directory can be assigned to a course
+func TestSetCourseTemplate(t *testing.T) {
+	config.Configuration.TemplateDirectory = filepath.Join("..", "..", "..", "config", "templates")
+	config.Configuration.MailTemplate = "MailTemplate"
+	config.Configuration.DepartmentTemplates = nil
+	coursehandler.Repository = coursehandler.NewMemoryRepository()
+	course := entity.Course{Name: "testcourse", Department: "testdepartment", Year: "2018-2019"}
+	coursehandler.Repository.CreateCourse(course)
+	r := mux.NewRouter()
+	r.HandleFunc("/notification_management/api/v1.0/course/template", resthandler.SetCourseTemplate).Methods(http.MethodPut)
+
+	tests := []struct {
+		name     string
+		template string
+		expected int
+	}{
+		{"testcourse", "MailTemplate", http.StatusOK},
+		{"testcourse", "UnknownTemplate", http.StatusBadRequest},
+		{"missingcourse", "MailTemplate", http.StatusNotFound},
+		{"testcourse", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		requestBody, _ := json.Marshal(map[string]string{
+			"name": test.name, "department": course.Department, "year": course.Year, "template": test.template,
+		})
+		request, _ := http.NewRequest(http.MethodPut, "/notification_management/api/v1.0/course/template", bytes.NewBuffer(requestBody))
+		response := httptest.NewRecorder()
+		r.ServeHTTP(response, request)
+		if response.Code != test.expected {
+			t.Error("Expected " + strconv.Itoa(test.expected) + " but got " + strconv.Itoa(response.Code) + " " +
+				http.StatusText(response.Code))
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package deadletter
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/redefik/notificationmanagement/deadletter"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// TestDirectoryStore tests that the letters are processed in the order in which they have been stored and that only
+// the ones accepted by the handler are removed
+func TestDirectoryStore(t *testing.T) {
+	directory, err := ioutil.TempDir("", "deadletter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	store, err := deadletter.NewDirectoryStore(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodies := []string{`{"name":"first"`, `{"name":"second"}`, `{"name":"third"}`}
+	for _, body := range bodies {
+		message := &sqs.Message{
+			Body:       aws.String(body),
+			Attributes: map[string]*string{sqs.MessageSystemAttributeNameMessageGroupId: aws.String("group")},
+		}
+		err = store.Put(deadletter.NewLetter(message, "invalid notification"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the letters are only read
+	var readBodies []string
+	handled, err := store.Process(0, func(letter deadletter.Letter) (bool, error) {
+		if letter.GroupId != "group" || letter.Reason != "invalid notification" || letter.FailedAt.IsZero() {
+			t.Error("Unexpected letter", letter)
+		}
+		readBodies = append(readBodies, letter.Body)
+		return false, nil
+	})
+	if err != nil || handled != 3 {
+		t.Fatal("Expected 3 letters but got", handled, err)
+	}
+	for i, body := range bodies {
+		if readBodies[i] != body {
+			t.Error("Expected", body, "but got", readBodies[i])
+		}
+	}
+
+	// the first two letters are removed
+	handled, err = store.Process(2, func(letter deadletter.Letter) (bool, error) {
+		return true, nil
+	})
+	if err != nil || handled != 2 {
+		t.Fatal("Expected 2 letters but got", handled, err)
+	}
+	readBodies = nil
+	handled, err = store.Process(0, func(letter deadletter.Letter) (bool, error) {
+		readBodies = append(readBodies, letter.Body)
+		return true, nil
+	})
+	if err != nil || handled != 1 || readBodies[0] != bodies[2] {
+		t.Error("Expected only the third letter but got", readBodies, err)
+	}
+	files, _ := ioutil.ReadDir(directory)
+	if len(files) != 0 {
+		t.Error("Expected an empty store but got", len(files), "files")
+	}
+}
@@ -0,0 +1,257 @@
+package deliveryledger
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/redefik/notificationmanagement/config"
+	"github.com/redefik/notificationmanagement/coursehandler"
+	"github.com/redefik/notificationmanagement/deadletter"
+	"github.com/redefik/notificationmanagement/entity"
+	"github.com/redefik/notificationmanagement/notificationhandler"
+	"github.com/redefik/notificationmanagement/notificationthread"
+	"github.com/redefik/notificationmanagement/sqswrapper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// checkDeliveries tests that a repository records the recipients and the completion of the deliveries
+func checkDeliveries(t *testing.T, repository coursehandler.CourseRepository) {
+	delivery, err := repository.GetDelivery("message-1")
+	if err != nil || delivery.Completed || len(delivery.Recipients) != 0 {
+		t.Error("Expected an empty delivery but got", delivery, err)
+	}
+	if err = repository.AddDeliveredRecipients("message-1", []string{"First@Test.it", "second@test.it"}); err != nil {
+		t.Fatal(err)
+	}
+	repository.AddDeliveredRecipients("message-1", []string{"second@test.it", "third@test.it"})
+	repository.AddDeliveredRecipients("message-2", []string{"other@test.it"})
+	delivery, err = repository.GetDelivery("message-1")
+	sort.Strings(delivery.Recipients)
+	expected := []string{"first@test.it", "second@test.it", "third@test.it"}
+	if err != nil || delivery.Completed || !reflect.DeepEqual(delivery.Recipients, expected) {
+		t.Error("Unexpected delivery", delivery, err)
+	}
+
+	if err = repository.CompleteDelivery("message-1"); err != nil {
+		t.Fatal(err)
+	}
+	delivery, err = repository.GetDelivery("message-1")
+	if err != nil || !delivery.Completed || len(delivery.Recipients) != 3 {
+		t.Error("Expected a completed delivery but got", delivery, err)
+	}
+	delivery, err = repository.GetDelivery("message-2")
+	if err != nil || delivery.Completed || !reflect.DeepEqual(delivery.Recipients, []string{"other@test.it"}) {
+		t.Error("Unexpected delivery", delivery, err)
+	}
+	// a notification sent to an empty mailing list is completed without recipients
+	repository.CompleteDelivery("message-3")
+	delivery, err = repository.GetDelivery("message-3")
+	if err != nil || !delivery.Completed || len(delivery.Recipients) != 0 {
+		t.Error("Expected a completed delivery but got", delivery, err)
+	}
+}
+
+// TestDeliveryStores tests the deliveries in the memory, BoltDB and SQL backends
+func TestDeliveryStores(t *testing.T) {
+	directory, err := ioutil.TempDir("", "deliveryledger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	checkDeliveries(t, coursehandler.NewMemoryRepository())
+	boltRepository, err := coursehandler.NewBoltRepository(filepath.Join(directory, "courses.bolt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer boltRepository.Close()
+	checkDeliveries(t, boltRepository)
+	sqlRepository, err := coursehandler.NewSqlRepository("sqlite3", filepath.Join(directory, "courses.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlRepository.Close()
+	checkDeliveries(t, sqlRepository)
+}
+
+// TestBoltDeliveriesPersist tests that the deliveries are kept when the BoltDB file is opened again
+func TestBoltDeliveriesPersist(t *testing.T) {
+	directory, err := ioutil.TempDir("", "deliveryledger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "courses.bolt")
+
+	boltRepository, err := coursehandler.NewBoltRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	boltRepository.AddDeliveredRecipients("message-1", []string{"first@test.it"})
+	boltRepository.Close()
+	boltRepository, err = coursehandler.NewBoltRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer boltRepository.Close()
+	delivery, err := boltRepository.GetDelivery("message-1")
+	if err != nil || !reflect.DeepEqual(delivery.Recipients, []string{"first@test.it"}) {
+		t.Error("Unexpected delivery", delivery, err)
+	}
+}
+
+// TestPendingRecipients tests that the mailing list entries are compared with the delivered recipients regardless of
+// their case
+func TestPendingRecipients(t *testing.T) {
+	delivery := coursehandler.Delivery{Recipients: []string{"first@test.it", "second@test.it"}}
+	pending := delivery.PendingRecipients([]string{"First@Test.it", "third@test.it", "SECOND@test.it", "Fourth@Test.it"})
+	if !reflect.DeepEqual(pending, []string{"third@test.it", "Fourth@Test.it"}) {
+		t.Error("Unexpected pending recipients", pending)
+	}
+}
+
+// recordingSender records the recipients of each notification and delivers it to all of them. cancel, when set, is
+// called after the first notification has been sent
+type recordingSender struct {
+	requests [][]string
+	cancel   func()
+}
+
+func (sender *recordingSender) SendNotification(ctx context.Context, message entity.Notification, templateName string,
+	from string, to []string) (notificationhandler.DeliveryResult, error) {
+	sender.requests = append(sender.requests, to)
+	result := notificationhandler.DeliveryResult{Delivered: make(map[string]string), Failed: make(map[string]string)}
+	for _, recipient := range to {
+		result.Delivered[recipient] = "id-" + recipient
+	}
+	if sender.cancel != nil {
+		sender.cancel()
+	}
+	return result, nil
+}
+
+// recipients returns every recipient the sender sent a notification to, sorted
+func (sender *recordingSender) recipients() []string {
+	var recipients []string
+	for _, request := range sender.requests {
+		recipients = append(recipients, request...)
+	}
+	sort.Strings(recipients)
+	return recipients
+}
+
+// setUpCourse sets up a memory repository containing a course with the given students and returns a message carrying
+// a notification for it
+func setUpCourse(t *testing.T, studentMails []string) *sqs.Message {
+	config.Configuration.MailAddress = "noreply@test.it"
+	coursehandler.Repository = coursehandler.NewMemoryRepository()
+	course := entity.Course{Name: "testcourse", Department: "testdepartment", Year: "2018-2019"}
+	if err := coursehandler.Repository.CreateCourse(course); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := coursehandler.Repository.AddStudents(course, studentMails); err != nil {
+		t.Fatal(err)
+	}
+	return &sqs.Message{
+		MessageId: aws.String("message-1"),
+		Body: aws.String(`{"name":"testcourse","department":"testdepartment","year":"2018-2019",` +
+			`"message":"Lesson cancelled"}`),
+	}
+}
+
+// TestResumedDelivery tests that a notification read again is sent only to the students that have not received it
+func TestResumedDelivery(t *testing.T) {
+	message := setUpCourse(t, []string{"First@Test.it", "second@test.it", "third@test.it"})
+	coursehandler.Repository.AddDeliveredRecipients("message-1", []string{"first@test.it"})
+	sender := &recordingSender{}
+	notificationhandler.MailSender = sender
+
+	if err := notificationthread.ProcessMessage(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sender.recipients(), []string{"second@test.it", "third@test.it"}) {
+		t.Error("Unexpected recipients", sender.recipients())
+	}
+	delivery, err := coursehandler.Repository.GetDelivery("message-1")
+	if err != nil || !delivery.Completed || len(delivery.Recipients) != 3 {
+		t.Error("Expected a completed delivery but got", delivery, err)
+	}
+
+	// a completed notification is not sent again
+	sender.requests = nil
+	if err = notificationthread.ProcessMessage(context.Background(), message); err != nil || len(sender.requests) != 0 {
+		t.Error("Expected no recipients but got", sender.requests, err)
+	}
+}
+
+// TestInterruptedDelivery tests that the recipients of the chunks sent before an interruption are recorded, and that
+// only the others are sent the notification when the message is read again
+func TestInterruptedDelivery(t *testing.T) {
+	var studentMails []string
+	for i := 0; i < 600; i++ {
+		studentMails = append(studentMails, "student"+strconv.Itoa(i)+"@test.it")
+	}
+	message := setUpCourse(t, studentMails)
+	ctx, cancel := context.WithCancel(context.Background())
+	sender := &recordingSender{cancel: cancel}
+	notificationhandler.MailSender = sender
+
+	if err := notificationthread.ProcessMessage(ctx, message); err != context.Canceled {
+		t.Error("Expected the interruption but got", err)
+	}
+	delivery, err := coursehandler.Repository.GetDelivery("message-1")
+	if err != nil || delivery.Completed || len(delivery.Recipients) != 500 {
+		t.Fatal("Unexpected delivery", delivery.Completed, len(delivery.Recipients), err)
+	}
+
+	sender = &recordingSender{}
+	notificationhandler.MailSender = sender
+	if err = notificationthread.ProcessMessage(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+	if len(sender.recipients()) != 100 {
+		t.Error("Expected 100 recipients but got", len(sender.recipients()))
+	}
+	delivery, err = coursehandler.Repository.GetDelivery("message-1")
+	if err != nil || !delivery.Completed || len(delivery.Recipients) != 600 {
+		t.Error("Expected a completed delivery but got", delivery.Completed, len(delivery.Recipients), err)
+	}
+}
+
+// TestRedrivenNotificationId tests that a redriven notification keeps the id of the original message, so it is not
+// sent again to the students that received the original one
+func TestRedrivenNotificationId(t *testing.T) {
+	message := setUpCourse(t, []string{"first@test.it", "second@test.it"})
+	letter := deadletter.NewLetter(message, "rejected")
+	if letter.NotificationId != "message-1" {
+		t.Fatal("Unexpected notification id", letter.NotificationId)
+	}
+	coursehandler.Repository.AddDeliveredRecipients("message-1", []string{"first@test.it"})
+	sender := &recordingSender{}
+	notificationhandler.MailSender = sender
+
+	redriven := &sqs.Message{
+		MessageId: aws.String("message-2"),
+		Body:      message.Body,
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			sqswrapper.NotificationIdAttribute: {DataType: aws.String("String"), StringValue: aws.String(letter.NotificationId)},
+		},
+	}
+	if err := notificationthread.ProcessMessage(context.Background(), redriven); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sender.recipients(), []string{"second@test.it"}) {
+		t.Error("Unexpected recipients", sender.recipients())
+	}
+	// the letter of a redriven message keeps the original id as well
+	if letter = deadletter.NewLetter(redriven, "rejected"); letter.NotificationId != "message-1" {
+		t.Error("Unexpected notification id", letter.NotificationId)
+	}
+}
@@ -0,0 +1,115 @@
+package filesender
+
+import (
+	"context"
+	"github.com/redefik/notificationmanagement/entity"
+	"github.com/redefik/notificationmanagement/mailtemplate"
+	"github.com/redefik/notificationmanagement/notificationhandler"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestFileSender tests that a .eml file is written for each recipient and that it can be parsed as a mail with a
+// text and an HTML part
+func TestFileSender(t *testing.T) {
+	directory, err := ioutil.TempDir("", "filesender")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	mailTemplate, err := mailtemplate.Load(filepath.Join("..", "..", "..", "config", "templates", "mailtemplate.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender, err := notificationhandler.NewFileSender(filepath.Join(directory, "mails"), mailtemplate.Set{Default: mailTemplate})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := entity.Notification{Name: "testcourse", Year: "2018-2019", Message: "Lesson cancelled"}
+	recipients := []string{"first@test.it", "second@test.it"}
+	result, err := sender.SendNotification(context.Background(), message, "", "noreply@test.it", recipients)
+	if err != nil || len(result.Delivered) != 2 {
+		t.Fatal("Unexpected result", result, err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(directory, "mails", "*.eml"))
+	if err != nil || len(files) != 2 {
+		t.Fatal("Expected 2 mails but got", files, err)
+	}
+	receivedBy := make(map[string]bool)
+	for _, file := range files {
+		content, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsedMail, err := mail.ReadMessage(content)
+		if err != nil {
+			content.Close()
+			t.Fatal(err)
+		}
+		receivedBy[parsedMail.Header.Get("To")] = true
+		if parsedMail.Header.Get("Subject") != "[testcourse 2018-2019]" {
+			t.Error("Unexpected subject", parsedMail.Header.Get("Subject"))
+		}
+		if result.Delivered[parsedMail.Header.Get("To")] != parsedMail.Header.Get("Message-Id") {
+			t.Error("The Message-Id does not match the delivery result")
+		}
+		_, parameters, err := mime.ParseMediaType(parsedMail.Header.Get("Content-Type"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts := 0
+		reader := multipart.NewReader(parsedMail.Body, parameters["boundary"])
+		for part, err := reader.NextPart(); err == nil; part, err = reader.NextPart() {
+			body, _ := ioutil.ReadAll(part)
+			if string(body) != "Lesson cancelled" {
+				t.Error("Unexpected body", string(body))
+			}
+			parts++
+		}
+		if parts != 2 {
+			t.Error("Expected 2 parts but got", parts)
+		}
+		content.Close()
+	}
+	for _, recipient := range recipients {
+		if !receivedBy[recipient] {
+			t.Error("No mail for", recipient)
+		}
+	}
+}
+
+// TestFileSenderCancelled tests that no mail is written once the context of the sending has been cancelled
+func TestFileSenderCancelled(t *testing.T) {
+	directory, err := ioutil.TempDir("", "filesender")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	mailTemplate, err := mailtemplate.Load(filepath.Join("..", "..", "..", "config", "templates", "mailtemplate.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender, err := notificationhandler.NewFileSender(directory, mailtemplate.Set{Default: mailTemplate})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	message := entity.Notification{Name: "testcourse", Year: "2018-2019", Message: "Lesson cancelled"}
+	result, err := sender.SendNotification(ctx, message, "", "noreply@test.it", []string{"first@test.it", "second@test.it"})
+	if err != notificationhandler.NotDeliveredError || len(result.Failed) != 2 {
+		t.Error("Unexpected result", result, err)
+	}
+	files, _ := filepath.Glob(filepath.Join(directory, "*.eml"))
+	if len(files) != 0 {
+		t.Error("Expected no mail but got", files)
+	}
+}
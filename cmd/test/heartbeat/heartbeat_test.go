@@ -0,0 +1,106 @@
+package heartbeat
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/redefik/notificationmanagement/sqswrapper"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSqs records the visibility timeouts set for each receipt handle
+type fakeSqs struct {
+	sqsiface.SQSAPI
+	mutex   sync.Mutex
+	changes map[string][]int64
+}
+
+func (client *fakeSqs) ChangeMessageVisibilityWithContext(ctx aws.Context, input *sqs.ChangeMessageVisibilityInput,
+	options ...request.Option) (*sqs.ChangeMessageVisibilityOutput, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	handle := aws.StringValue(input.ReceiptHandle)
+	client.changes[handle] = append(client.changes[handle], aws.Int64Value(input.VisibilityTimeout))
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+// takeChanges returns the changes recorded since the previous call
+func (client *fakeSqs) takeChanges() map[string][]int64 {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	changes := client.changes
+	client.changes = make(map[string][]int64)
+	return changes
+}
+
+// newMessage returns a message received count times
+func newMessage(receiptHandle string, count string) *sqs.Message {
+	return &sqs.Message{
+		ReceiptHandle: aws.String(receiptHandle),
+		Attributes:    map[string]*string{sqs.MessageSystemAttributeNameApproximateReceiveCount: aws.String(count)},
+	}
+}
+
+// TestHeartbeatRenew tests that only the messages added and not yet removed or released are hidden again
+func TestHeartbeatRenew(t *testing.T) {
+	client := &fakeSqs{changes: make(map[string][]int64)}
+	beat := sqswrapper.NewHeartbeat(client, "queue", 60)
+	first := newMessage("first", "1")
+	second := newMessage("second", "1")
+	third := newMessage("third", "1")
+	beat.Add([]*sqs.Message{first, second, third})
+	beat.Renew(context.Background())
+	expected := map[string][]int64{"first": {60}, "second": {60}, "third": {60}}
+	if changes := client.takeChanges(); !reflect.DeepEqual(changes, expected) {
+		t.Error("Unexpected changes", changes)
+	}
+
+	beat.Remove(first)
+	if err := beat.Release(context.Background(), second, 30); err != nil {
+		t.Fatal(err)
+	}
+	if changes := client.takeChanges(); !reflect.DeepEqual(changes, map[string][]int64{"second": {30}}) {
+		t.Error("Unexpected changes", changes)
+	}
+	beat.Renew(context.Background())
+	if changes := client.takeChanges(); !reflect.DeepEqual(changes, map[string][]int64{"third": {60}}) {
+		t.Error("Unexpected changes", changes)
+	}
+}
+
+// TestHeartbeatRun tests that the messages are renewed periodically until the heartbeat is stopped
+func TestHeartbeatRun(t *testing.T) {
+	client := &fakeSqs{changes: make(map[string][]int64)}
+	beat := sqswrapper.NewHeartbeat(client, "queue", 1)
+	beat.Add([]*sqs.Message{newMessage("first", "1")})
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		beat.Run(ctx)
+		close(done)
+	}()
+	time.Sleep(800 * time.Millisecond)
+	stop()
+	<-done
+	if changes := client.takeChanges(); len(changes["first"]) < 2 {
+		t.Error("Expected at least 2 renewals but got", changes)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if changes := client.takeChanges(); len(changes) != 0 {
+		t.Error("Unexpected renewals after the stop", changes)
+	}
+}
+
+// TestRetryVisibilityTimeout tests that the delay of the retries doubles at each receive up to the maximum
+func TestRetryVisibilityTimeout(t *testing.T) {
+	for count, expected := range map[string]int64{"": 30, "1": 30, "2": 60, "3": 120, "5": 480, "6": 900, "100": 900} {
+		if timeout := sqswrapper.RetryVisibilityTimeout(newMessage("first", count), 30, 900); timeout != expected {
+			t.Error("Expected", expected, "for", count, "receives but got", timeout)
+		}
+	}
+}
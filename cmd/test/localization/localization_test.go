@@ -0,0 +1,217 @@
+package localization
+
+import (
+	"context"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/redefik/notificationmanagement/config"
+	"github.com/redefik/notificationmanagement/coursehandler"
+	"github.com/redefik/notificationmanagement/entity"
+	"github.com/redefik/notificationmanagement/notificationhandler"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// checkLocales tests that a repository stores the locales of the students and the default language of the courses
+func checkLocales(t *testing.T, repository coursehandler.CourseRepository) {
+	if err := repository.SetStudentLocale("First@Test.it", "en_GB"); err != nil {
+		t.Fatal(err)
+	}
+	repository.SetStudentLocale("second@test.it", "it")
+	repository.SetStudentLocale("third@test.it", "de")
+	repository.SetStudentLocale("third@test.it", "")
+	locales, err := repository.GetStudentLocales([]string{"first@test.it", "SECOND@test.it", "third@test.it", "first@test.it"})
+	expected := map[string]string{"first@test.it": "en-gb", "second@test.it": "it"}
+	if err != nil || !reflect.DeepEqual(locales, expected) {
+		t.Error("Unexpected locales", locales, err)
+	}
+
+	course := entity.Course{Name: "testcourse", Department: "testdepartment", Year: "2018-2019"}
+	if err = repository.SetCourseLanguage(course, "it"); err != coursehandler.NotFoundError {
+		t.Error("Expected NotFoundError but got", err)
+	}
+	repository.CreateCourse(course)
+	if err = repository.SetCourseLanguage(course, "it"); err != nil {
+		t.Fatal(err)
+	}
+	if language, err := repository.GetCourseLanguage(course); err != nil || language != "it" {
+		t.Error("Expected it but got", language, err)
+	}
+	repository.DeleteCourse(course)
+	if _, err = repository.GetCourseLanguage(course); err != coursehandler.NotFoundError {
+		t.Error("Expected NotFoundError but got", err)
+	}
+}
+
+// TestLocaleStores tests the locales in the memory, BoltDB and SQL backends
+func TestLocaleStores(t *testing.T) {
+	directory, err := ioutil.TempDir("", "localization")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	checkLocales(t, coursehandler.NewMemoryRepository())
+	boltRepository, err := coursehandler.NewBoltRepository(filepath.Join(directory, "courses.bolt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer boltRepository.Close()
+	checkLocales(t, boltRepository)
+	sqlRepository, err := coursehandler.NewSqlRepository("sqlite3", filepath.Join(directory, "courses.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlRepository.Close()
+	checkLocales(t, sqlRepository)
+}
+
+// TestGroupByLocale tests the choice of the body received by each student
+func TestGroupByLocale(t *testing.T) {
+	message := entity.Notification{Name: "testcourse", Message: "Lezione annullata",
+		Messages: map[string]string{"en": "Lesson cancelled", "fr": "Cours annulé"}}
+	locales := map[string]string{"en@test.it": "en-gb", "fr@test.it": "fr", "de@test.it": "de"}
+	groups := notificationhandler.GroupByLocale(message, "it", locales, []string{"en@test.it", "fr@test.it", "de@test.it", "none@test.it"})
+	expected := []struct {
+		language   string
+		body       string
+		recipients []string
+	}{
+		{"en", "Lesson cancelled", []string{"en@test.it"}},
+		{"fr", "Cours annulé", []string{"fr@test.it"}},
+		{"it", "Lezione annullata", []string{"de@test.it", "none@test.it"}},
+	}
+	if len(groups) != len(expected) {
+		t.Fatal("Unexpected groups", groups)
+	}
+	for i, group := range groups {
+		if group.Language != expected[i].language || group.Message.Message != expected[i].body ||
+			!reflect.DeepEqual(group.Recipients, expected[i].recipients) || group.Message.Messages != nil {
+			t.Error("Unexpected group", group)
+		}
+	}
+}
+
+// TestMixedCaseLanguages tests that the languages of the localized bodies are matched regardless of their case and
+// separator, and that the language of the body received by the students without a match is normalized too
+func TestMixedCaseLanguages(t *testing.T) {
+	message := entity.Notification{Name: "testcourse", Message: "Lezione annullata",
+		Messages: map[string]string{"EN_gb": "Lesson cancelled", "FR": "Cours annulé", "fr": "Cours supprimé"}}
+	locales := map[string]string{"gb@test.it": "en-gb", "en@test.it": "en", "fr@test.it": "fr-ca"}
+	groups := notificationhandler.GroupByLocale(message, "it", locales, []string{"gb@test.it", "en@test.it", "fr@test.it"})
+	expected := []struct {
+		language   string
+		body       string
+		recipients []string
+	}{
+		{"en-gb", "Lesson cancelled", []string{"gb@test.it"}},
+		{"fr", "Cours supprimé", []string{"fr@test.it"}},
+		{"it", "Lezione annullata", []string{"en@test.it"}},
+	}
+	if len(groups) != len(expected) {
+		t.Fatal("Unexpected groups", groups)
+	}
+	for i, group := range groups {
+		if group.Language != expected[i].language || group.Message.Message != expected[i].body ||
+			!reflect.DeepEqual(group.Recipients, expected[i].recipients) {
+			t.Error("Unexpected group", group)
+		}
+	}
+
+	// without a body in the default language, the first localized body is chosen with its normalized language
+	message = entity.Notification{Name: "testcourse", Messages: map[string]string{"EN_GB": "Lesson cancelled"}}
+	language, localizedMessage := notificationhandler.LocalizeNotification(message, "it", "it")
+	if language != "en-gb" || localizedMessage.Message != "Lesson cancelled" {
+		t.Error("Unexpected body", language, localizedMessage.Message)
+	}
+}
+
+// recordingSender records the template used for each recipient
+type recordingSender struct {
+	templates map[string]string
+}
+
+func (sender *recordingSender) SendNotification(ctx context.Context, message entity.Notification, templateName string, from string, to []string) (notificationhandler.DeliveryResult, error) {
+	result := notificationhandler.DeliveryResult{Delivered: make(map[string]string), Failed: make(map[string]string)}
+	for _, recipient := range to {
+		sender.templates[recipient] = templateName
+		result.Delivered[recipient] = "id"
+	}
+	return result, nil
+}
+
+// TestLocalizedTemplates tests that each group is sent with the localized template, when it exists
+func TestLocalizedTemplates(t *testing.T) {
+	directory, err := ioutil.TempDir("", "localization")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	for _, name := range []string{"MailTemplate", "MailTemplate-en"} {
+		content := `{"Template": {"TemplateName": "` + name + `", "SubjectPart": "{{courseName}}", "TextPart": "{{body}}"}}`
+		if err = ioutil.WriteFile(filepath.Join(directory, name+".json"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config.Configuration.MailSender = notificationhandler.FileSenderName
+	config.Configuration.MailSinkDirectory = filepath.Join(directory, "mails")
+	config.Configuration.MailTemplateFile = filepath.Join(directory, "MailTemplate.json")
+	config.Configuration.TemplateDirectory = directory
+	if err = notificationhandler.InitializeSender(); err != nil {
+		t.Fatal(err)
+	}
+
+	sender := &recordingSender{templates: make(map[string]string)}
+	message := entity.Notification{Message: "Lezione annullata", Messages: map[string]string{"en": "Lesson cancelled"}}
+	locales := map[string]string{"en@test.it": "en-us"}
+	result, err := notificationhandler.SendLocalizedNotification(context.Background(), sender, message, "MailTemplate", "it", locales,
+		"noreply@test.it", []string{"en@test.it", "it@test.it"})
+	if err != nil || len(result.Delivered) != 2 {
+		t.Fatal("Unexpected result", result, err)
+	}
+	expected := map[string]string{"en@test.it": "MailTemplate-en", "it@test.it": "MailTemplate"}
+	if !reflect.DeepEqual(sender.templates, expected) {
+		t.Error("Unexpected templates", sender.templates)
+	}
+}
+
+// failingSender fails the groups whose language is in errors with the given error
+type failingSender struct {
+	errors map[string]error
+}
+
+func (sender *failingSender) SendNotification(ctx context.Context, message entity.Notification, templateName string, from string, to []string) (notificationhandler.DeliveryResult, error) {
+	result := notificationhandler.DeliveryResult{Delivered: make(map[string]string), Failed: make(map[string]string)}
+	err := sender.errors[message.Message]
+	for _, recipient := range to {
+		if err != nil {
+			result.Failed[recipient] = err.Error()
+		} else {
+			result.Delivered[recipient] = "id"
+		}
+	}
+	return result, err
+}
+
+// TestLocalizedRejection tests that RejectedError is returned only when every group has been rejected permanently
+func TestLocalizedRejection(t *testing.T) {
+	message := entity.Notification{Message: "it", Messages: map[string]string{"en": "en"}}
+	locales := map[string]string{"en@test.it": "en"}
+	to := []string{"en@test.it", "it@test.it"}
+	for _, test := range []struct {
+		errors   map[string]error
+		expected error
+	}{
+		{map[string]error{"it": notificationhandler.RejectedError, "en": notificationhandler.RejectedError}, notificationhandler.RejectedError},
+		{map[string]error{"it": notificationhandler.RejectedError, "en": notificationhandler.NotDeliveredError}, notificationhandler.NotDeliveredError},
+		{map[string]error{"it": notificationhandler.RejectedError}, nil},
+	} {
+		_, err := notificationhandler.SendLocalizedNotification(context.Background(), &failingSender{errors: test.errors}, message,
+			"MailTemplate", "it", locales, "noreply@test.it", to)
+		if err != test.expected {
+			t.Error("Expected", test.expected, "for", test.errors, "but got", err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package mailtemplate
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/redefik/notificationmanagement/config"
+	"github.com/redefik/notificationmanagement/coursehandler"
+	"github.com/redefik/notificationmanagement/mailtemplate"
+	"github.com/redefik/notificationmanagement/resthandler"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// TestRender tests the rendering of the parameters, of the conditionals and of the loops
+func TestRender(t *testing.T) {
+	data := map[string]interface{}{
+		"courseName": "Calculus & Geometry",
+		"course":     map[string]interface{}{"year": "2018-2019"},
+		"lessons":    []interface{}{map[string]interface{}{"day": "Monday"}, map[string]interface{}{"day": "Friday"}},
+		"tags":       []string{"a", "b"},
+		"empty":      []interface{}{},
+		"urgent":     true,
+		"room":       float64(12),
+	}
+	tests := []struct {
+		source     string
+		escapeHtml bool
+		expected   string
+	}{
+		{"[{{courseName}}]", false, "[Calculus & Geometry]"},
+		{"[{{courseName}}]", true, "[Calculus &amp; Geometry]"},
+		{"{{{courseName}}}", true, "Calculus & Geometry"},
+		{"{{ course.year }} room {{room}}", false, "2018-2019 room 12"},
+		{"{{#if urgent}}URGENT {{else}}normal {{/if}}{{#unless missing}}ok{{/unless}}", false, "URGENT ok"},
+		{"{{#if empty}}some{{else}}none{{/if}}", false, "none"},
+		{"{{#each lessons}}{{@index}}:{{day}} {{courseName}};{{/each}}", false,
+			"0:Monday Calculus & Geometry;1:Friday Calculus & Geometry;"},
+		{"{{#each tags}}<{{this}}>{{/each}}{{#each empty}}x{{else}}no lessons{{/each}}", false, "<a><b>no lessons"},
+		{"a{{! comment }}b{{!-- {{courseName}} --}}c{{missing}}", false, "abc"},
+	}
+	for _, test := range tests {
+		template, err := mailtemplate.Parse(test.source)
+		if err != nil {
+			t.Error(test.source, err)
+			continue
+		}
+		if rendered := template.Render(data, test.escapeHtml); rendered != test.expected {
+			t.Error(test.source + ": expected " + test.expected + " but got " + rendered)
+		}
+	}
+}
+
+// TestParseErrors tests that the malformed templates are rejected
+func TestParseErrors(t *testing.T) {
+	for _, source := range []string{"{{name", "{{#if a}}x", "{{#if a}}x{{/each}}", "x{{/if}}", "{{else}}",
+		"{{#with a}}x{{/with}}", "{{#if}}x{{/if}}", "{{}}"} {
+		if _, err := mailtemplate.Parse(source); err == nil {
+			t.Error("Expected an error for", source)
+		}
+	}
+}
+
+// TestPreviewNotification tests the preview endpoint with the template of the repository
+func TestPreviewNotification(t *testing.T) {
+	config.Configuration.MailTemplateFile = filepath.Join("..", "..", "..", "config", "templates", "mailtemplate.json")
+	config.Configuration.TemplateDirectory = filepath.Join("..", "..", "..", "config", "templates")
+	coursehandler.Repository = coursehandler.NewMemoryRepository()
+	r := mux.NewRouter()
+	r.HandleFunc("/notification_management/api/v1.0/notification/preview", resthandler.PreviewNotification).Methods(http.MethodPost)
+
+	requestBody, _ := json.Marshal(map[string]string{
+		"name": "testcourse", "department": "testdepartment", "year": "2018-2019", "message": "<b>Exam</b>\nroom 1",
+	})
+	request, _ := http.NewRequest(http.MethodPost, "/notification_management/api/v1.0/notification/preview", bytes.NewBuffer(requestBody))
+	response := httptest.NewRecorder()
+	r.ServeHTTP(response, request)
+	if response.Code != http.StatusOK {
+		t.Fatal("Expected 200 OK but got " + strconv.Itoa(response.Code) + " " + http.StatusText(response.Code))
+	}
+	var renderedMail mailtemplate.RenderedMail
+	json.Unmarshal(response.Body.Bytes(), &renderedMail)
+	expected := mailtemplate.RenderedMail{
+		Subject: "[testcourse 2018-2019]",
+		Text:    "<b>Exam</b>\nroom 1",
+		Html:    "&lt;b&gt;Exam&lt;/b&gt;<br>\nroom 1",
+	}
+	if renderedMail != expected {
+		t.Error("Unexpected mail", renderedMail)
+	}
+}
@@ -0,0 +1,68 @@
+package markdown
+
+import (
+	"encoding/json"
+	"github.com/redefik/notificationmanagement/entity"
+	"github.com/redefik/notificationmanagement/markdown"
+	"github.com/redefik/notificationmanagement/notificationhandler"
+	"testing"
+)
+
+// TestRender tests the HTML and the text versions of the supported syntax
+func TestRender(t *testing.T) {
+	tests := []struct {
+		source       string
+		expectedHtml string
+		expectedText string
+	}{
+		{"Lesson **cancelled**\nsee *you*", "<p>Lesson <strong>cancelled</strong><br>\nsee <em>you</em></p>", "Lesson cancelled\nsee you"},
+		{"## Exam\n\nRoom `C1`", "<h2>Exam</h2>\n<p>Room <code>C1</code></p>", "Exam\n\nRoom C1"},
+		{"- slides\n- notes\n  on the web", "<ul>\n<li>slides</li>\n<li>notes on the web</li>\n</ul>", "- slides\n- notes on the web"},
+		{"3. first\n4. second", "<ol start=\"3\">\n<li>first</li>\n<li>second</li>\n</ol>", "3. first\n4. second"},
+		{"[Slides](https://uni.it/s?a=1&b=2)", "<p><a href=\"https://uni.it/s?a=1&amp;b=2\">Slides</a></p>", "Slides (https://uni.it/s?a=1&b=2)"},
+		{"[me](mailto:prof@uni.it)", "<p><a href=\"mailto:prof@uni.it\">me</a></p>", "me (prof@uni.it)"},
+		{"snake_case_name and 2 * 3", "<p>snake_case_name and 2 * 3</p>", "snake_case_name and 2 * 3"},
+		{"\\*not emphasis\\*", "<p>*not emphasis*</p>", "*not emphasis*"},
+	}
+	for _, test := range tests {
+		html, text := markdown.Render(test.source)
+		if html != test.expectedHtml {
+			t.Error(test.source + ": expected HTML " + test.expectedHtml + " but got " + html)
+		}
+		if text != test.expectedText {
+			t.Error(test.source + ": expected text " + test.expectedText + " but got " + text)
+		}
+	}
+}
+
+// TestRenderIsSafe tests that raw HTML is escaped and that the links to unsafe schemes are not rendered
+func TestRenderIsSafe(t *testing.T) {
+	tests := []struct {
+		source       string
+		expectedHtml string
+	}{
+		{"<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>"},
+		{"[click](javascript:alert(1))", "<p>[click](javascript:alert(1))</p>"},
+		{"[x](https://uni.it/\"onmouseover=\"a)", "<p><a href=\"https://uni.it/&#34;onmouseover=&#34;a\">x</a></p>"},
+		{"**<b>bold</b>**", "<p><strong>&lt;b&gt;bold&lt;/b&gt;</strong></p>"},
+	}
+	for _, test := range tests {
+		if html, _ := markdown.Render(test.source); html != test.expectedHtml {
+			t.Error(test.source + ": expected HTML " + test.expectedHtml + " but got " + html)
+		}
+	}
+}
+
+// TestMarkdownTemplateData tests that the Markdown bodies are rendered before filling the template
+func TestMarkdownTemplateData(t *testing.T) {
+	message := entity.Notification{Name: "testcourse", Year: "2018-2019", Message: "**Exam** moved", Format: notificationhandler.MarkdownFormat}
+	data, err := notificationhandler.BuildTemplateData(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parameters map[string]string
+	json.Unmarshal([]byte(data), &parameters)
+	if parameters["htmlBody"] != "<p><strong>Exam</strong> moved</p>" || parameters["body"] != "Exam moved" {
+		t.Error("Unexpected parameters", parameters)
+	}
+}
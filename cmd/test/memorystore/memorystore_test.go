@@ -0,0 +1,127 @@
+package memorystore
+
+import (
+	"bytes"
+	"github.com/bitly/go-simplejson"
+	"github.com/gorilla/mux"
+	"github.com/redefik/notificationmanagement/config"
+	"github.com/redefik/notificationmanagement/coursehandler"
+	"github.com/redefik/notificationmanagement/entity"
+	"github.com/redefik/notificationmanagement/resthandler"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// createTestMicroserviceMemoryStore builds an http handler used to test the course management functionality
+// on top of the in-memory course data store
+func createTestMicroserviceMemoryStore() http.Handler {
+	r := mux.NewRouter()
+	r.HandleFunc("/notification_management/api/v1.0/course", resthandler.NewCourse).Methods(http.MethodPost)
+	r.HandleFunc("/notification_management/api/v1.0/course", resthandler.DeleteCourse).Methods(http.MethodDelete)
+	r.HandleFunc("/notification_management/api/v1.0/course/student/{studentMail}", resthandler.AddCourseSubscription).Methods(http.MethodPut)
+	r.HandleFunc("/notification_management/api/v1.0/course/student/{studentMail}", resthandler.RemoveCourseSubscription).Methods(http.MethodDelete)
+	return r
+}
+
+// setup selects the in-memory backend, so the test does not need any AWS resource
+func setup(t *testing.T) {
+	config.Configuration.CourseStore = coursehandler.MemoryStore
+	err := coursehandler.InitializeRepository()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// makeRequest simulates a request-response interaction between client and microservice
+func makeRequest(method string, url string) *httptest.ResponseRecorder {
+	jsonBody := simplejson.New()
+	jsonBody.Set("name", "testcourse")
+	jsonBody.Set("department", "testdepartment")
+	jsonBody.Set("year", "2018-2019")
+
+	requestBody, _ := jsonBody.MarshalJSON()
+	request, _ := http.NewRequest(method, url, bytes.NewBuffer(requestBody))
+	request.Header.Set("Content-Type", "application/json")
+
+	response := httptest.NewRecorder()
+	handler := createTestMicroserviceMemoryStore()
+	handler.ServeHTTP(response, request)
+	return response
+}
+
+// TestMemoryStoreCourseLifecycle tests the following scenario: a course is created, a student subscribes to it and
+// then unsubscribes, finally the course is deleted. Every step must produce the same status codes returned
+// with the DynamoDB backend.
+func TestMemoryStoreCourseLifecycle(t *testing.T) {
+	setup(t)
+
+	courseUrl := "/notification_management/api/v1.0/course"
+	studentUrl := "/notification_management/api/v1.0/course/student/student@test.it"
+	steps := []struct {
+		method       string
+		url          string
+		expectedCode int
+	}{
+		{http.MethodPost, courseUrl, http.StatusCreated},
+		{http.MethodPost, courseUrl, http.StatusConflict},
+		{http.MethodPut, studentUrl, http.StatusOK},
+		{http.MethodPut, studentUrl, http.StatusConflict},
+		{http.MethodDelete, studentUrl, http.StatusOK},
+		{http.MethodDelete, courseUrl, http.StatusOK},
+		{http.MethodDelete, courseUrl, http.StatusNotFound},
+		{http.MethodPut, studentUrl, http.StatusNotFound},
+	}
+	for _, step := range steps {
+		response := makeRequest(step.method, step.url)
+		if response.Code != step.expectedCode {
+			t.Error(step.method + " " + step.url + ": expected " + strconv.Itoa(step.expectedCode) + " but got " +
+				strconv.Itoa(response.Code) + " " + http.StatusText(response.Code))
+		}
+	}
+}
+
+// TestMemoryStoreMailingList tests that the mailing list read by the notification thread reflects the subscriptions
+func TestMemoryStoreMailingList(t *testing.T) {
+	setup(t)
+
+	course := entity.Course{Name: "testcourse", Department: "testdepartment", Year: "2018-2019"}
+	makeRequest(http.MethodPost, "/notification_management/api/v1.0/course")
+	makeRequest(http.MethodPut, "/notification_management/api/v1.0/course/student/first@test.it")
+	makeRequest(http.MethodPut, "/notification_management/api/v1.0/course/student/second@test.it")
+	makeRequest(http.MethodDelete, "/notification_management/api/v1.0/course/student/first@test.it")
+
+	mailingList, err := coursehandler.Repository.GetCourseMailingList(course)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mailingList) != 1 || mailingList[0] != "second@test.it" {
+		t.Error("Expected [second@test.it] but got", mailingList)
+	}
+}
+
+// TestMemoryStoreCaseInsensitiveMail tests that mails differing only by case identify the same subscriber
+func TestMemoryStoreCaseInsensitiveMail(t *testing.T) {
+	setup(t)
+
+	makeRequest(http.MethodPost, "/notification_management/api/v1.0/course")
+	response := makeRequest(http.MethodPut, "/notification_management/api/v1.0/course/student/Student@Test.it")
+	if response.Code != http.StatusOK {
+		t.Error("Expected 200 OK but got " + strconv.Itoa(response.Code) + " " + http.StatusText(response.Code))
+	}
+	response = makeRequest(http.MethodPut, "/notification_management/api/v1.0/course/student/student@test.it")
+	if response.Code != http.StatusConflict {
+		t.Error("Expected 409 Conflict but got " + strconv.Itoa(response.Code) + " " + http.StatusText(response.Code))
+	}
+	makeRequest(http.MethodDelete, "/notification_management/api/v1.0/course/student/STUDENT@test.it")
+
+	course := entity.Course{Name: "testcourse", Department: "testdepartment", Year: "2018-2019"}
+	mailingList, err := coursehandler.Repository.GetCourseMailingList(course)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mailingList) != 0 {
+		t.Error("Expected an empty mailing list but got", mailingList)
+	}
+}
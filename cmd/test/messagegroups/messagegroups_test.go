@@ -0,0 +1,45 @@
+package messagegroups
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/redefik/notificationmanagement/sqswrapper"
+	"testing"
+)
+
+// newMessage returns a message with the given id belonging to the given group, to no group when it is empty
+func newMessage(id string, groupId string) *sqs.Message {
+	message := &sqs.Message{MessageId: aws.String(id), Attributes: make(map[string]*string)}
+	if groupId != "" {
+		message.Attributes[sqs.MessageSystemAttributeNameMessageGroupId] = aws.String(groupId)
+	}
+	return message
+}
+
+// TestGroupMessages tests that the messages of a group are kept together in the order in which they have been received
+func TestGroupMessages(t *testing.T) {
+	messages := []*sqs.Message{
+		newMessage("1", "calculus"),
+		newMessage("2", "physics"),
+		newMessage("3", ""),
+		newMessage("4", "calculus"),
+		newMessage("5", ""),
+		newMessage("6", "physics"),
+	}
+	expectedGroups := [][]string{{"1", "4"}, {"2", "6"}, {"3"}, {"5"}}
+	groups := sqswrapper.GroupMessages(messages)
+	if len(groups) != len(expectedGroups) {
+		t.Fatal("Expected", len(expectedGroups), "groups but got", len(groups))
+	}
+	for i, group := range groups {
+		if len(group) != len(expectedGroups[i]) {
+			t.Error("Group", i, "expected", expectedGroups[i], "but got", len(group), "messages")
+			continue
+		}
+		for j, message := range group {
+			if aws.StringValue(message.MessageId) != expectedGroups[i][j] {
+				t.Error("Group", i, "expected", expectedGroups[i], "but got message", aws.StringValue(message.MessageId))
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package sessender
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/ses/sesiface"
+	"github.com/redefik/notificationmanagement/entity"
+	"github.com/redefik/notificationmanagement/mailtemplate"
+	"github.com/redefik/notificationmanagement/notificationhandler"
+	"strconv"
+	"testing"
+)
+
+// fakeSes records the recipients of each SendBulkTemplatedEmail request and answers with respond
+type fakeSes struct {
+	sesiface.SESAPI
+	requests [][]string
+	respond  func(recipients []string) (*ses.SendBulkTemplatedEmailOutput, error)
+}
+
+func (client *fakeSes) SendBulkTemplatedEmailWithContext(ctx aws.Context, input *ses.SendBulkTemplatedEmailInput,
+	options ...request.Option) (*ses.SendBulkTemplatedEmailOutput, error) {
+	var recipients []string
+	for _, destination := range input.Destinations {
+		recipients = append(recipients, aws.StringValue(destination.Destination.BccAddresses[0]))
+	}
+	client.requests = append(client.requests, recipients)
+	return client.respond(recipients)
+}
+
+// statuses returns a response in which each recipient gets the status chosen by status
+func statuses(recipients []string, status func(recipient string) string) *ses.SendBulkTemplatedEmailOutput {
+	output := &ses.SendBulkTemplatedEmailOutput{}
+	for _, recipient := range recipients {
+		output.Status = append(output.Status, &ses.BulkEmailDestinationStatus{
+			Status:    aws.String(status(recipient)),
+			MessageId: aws.String("id-" + recipient),
+		})
+	}
+	return output
+}
+
+// send sends a notification to the recipients with a SesSender using the client
+func send(client *fakeSes, recipients []string) (notificationhandler.DeliveryResult, error) {
+	sender := notificationhandler.NewSesSender(client, "MailTemplate", mailtemplate.Set{})
+	message := entity.Notification{Name: "testcourse", Year: "2018-2019", Message: "Lesson cancelled"}
+	return sender.SendNotification(context.Background(), message, "", "noreply@test.it", recipients)
+}
+
+// TestSesDestinationsSplit tests that the recipients are sent in requests of at most 50 destinations
+func TestSesDestinationsSplit(t *testing.T) {
+	client := &fakeSes{respond: func(recipients []string) (*ses.SendBulkTemplatedEmailOutput, error) {
+		return statuses(recipients, func(string) string { return ses.BulkEmailStatusSuccess }), nil
+	}}
+	var recipients []string
+	for i := 0; i < 120; i++ {
+		recipients = append(recipients, "student"+strconv.Itoa(i)+"@test.it")
+	}
+	result, err := send(client, recipients)
+	if err != nil || len(result.Delivered) != 120 || len(result.Failed) != 0 {
+		t.Fatal("Unexpected result", len(result.Delivered), len(result.Failed), err)
+	}
+	if len(client.requests) != 3 || len(client.requests[0]) != 50 || len(client.requests[1]) != 50 ||
+		len(client.requests[2]) != 20 {
+		t.Error("Unexpected requests", len(client.requests))
+	}
+	if result.Delivered["student119@test.it"] != "id-student119@test.it" {
+		t.Error("Unexpected message id", result.Delivered["student119@test.it"])
+	}
+}
+
+// TestSesDestinationStatus tests that the status of each destination is mapped to its recipient and that only the
+// recipients that failed transiently are retried
+func TestSesDestinationStatus(t *testing.T) {
+	client := &fakeSes{}
+	client.respond = func(recipients []string) (*ses.SendBulkTemplatedEmailOutput, error) {
+		return statuses(recipients, func(recipient string) string {
+			switch {
+			case recipient == "rejected@test.it":
+				return ses.BulkEmailStatusMessageRejected
+			case recipient == "throttled@test.it" && len(client.requests) == 1:
+				return ses.BulkEmailStatusTransientFailure
+			}
+			return ses.BulkEmailStatusSuccess
+		}), nil
+	}
+	result, err := send(client, []string{"first@test.it", "rejected@test.it", "throttled@test.it"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(client.requests) != 2 || len(client.requests[1]) != 1 || client.requests[1][0] != "throttled@test.it" {
+		t.Error("Unexpected requests", client.requests)
+	}
+	if result.Delivered["first@test.it"] != "id-first@test.it" || result.Delivered["throttled@test.it"] == "" {
+		t.Error("Unexpected delivered recipients", result.Delivered)
+	}
+	if _, ok := result.Failed["rejected@test.it"]; !ok || len(result.Failed) != 1 {
+		t.Error("Unexpected failed recipients", result.Failed)
+	}
+}
+
+// TestSesRequestErrors tests that a request refused for a permanent reason is not retried, while a throttled one is
+func TestSesRequestErrors(t *testing.T) {
+	client := &fakeSes{respond: func([]string) (*ses.SendBulkTemplatedEmailOutput, error) {
+		return nil, awserr.New(ses.ErrCodeTemplateDoesNotExistException, "Template MailTemplate does not exist", nil)
+	}}
+	_, err := send(client, []string{"first@test.it", "second@test.it"})
+	if err != notificationhandler.RejectedError || len(client.requests) != 1 {
+		t.Error("Expected a single request but got", len(client.requests), err)
+	}
+
+	client = &fakeSes{}
+	client.respond = func(recipients []string) (*ses.SendBulkTemplatedEmailOutput, error) {
+		if len(client.requests) == 1 {
+			return nil, awserr.New("Throttling", "Maximum sending rate exceeded", nil)
+		}
+		return statuses(recipients, func(string) string { return ses.BulkEmailStatusSuccess }), nil
+	}
+	result, err := send(client, []string{"first@test.it", "second@test.it"})
+	if err != nil || len(result.Delivered) != 2 || len(client.requests) != 2 {
+		t.Error("Expected the request to be retried but got", result, len(client.requests), err)
+	}
+}
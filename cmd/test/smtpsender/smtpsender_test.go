@@ -0,0 +1,202 @@
+package smtpsender
+
+import (
+	"bufio"
+	"context"
+	"github.com/redefik/notificationmanagement/entity"
+	"github.com/redefik/notificationmanagement/mailtemplate"
+	"github.com/redefik/notificationmanagement/notificationhandler"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSmtpServer is a minimal SMTP server that accepts every mail, except the ones addressed to
+// rejected@test.it, and records the received messages. It never replies to the mails addressed to stalled@test.it
+type fakeSmtpServer struct {
+	listener    net.Listener
+	mutex       sync.Mutex
+	connections int
+	messages    map[string]string // recipient -> received message
+}
+
+// startFakeSmtpServer listens on a random local port
+func startFakeSmtpServer(t *testing.T) *fakeSmtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeSmtpServer{listener: listener, messages: make(map[string]string)}
+	go func() {
+		for {
+			connection, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.mutex.Lock()
+			server.connections++
+			server.mutex.Unlock()
+			go server.serve(connection)
+		}
+	}()
+	return server
+}
+
+// serve handles an SMTP session
+func (server *fakeSmtpServer) serve(connection net.Conn) {
+	defer connection.Close()
+	reader := bufio.NewReader(connection)
+	reply := func(line string) { connection.Write([]byte(line + "\r\n")) }
+	reply("220 fake SMTP server")
+	recipient := ""
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"):
+			reply("250-fake")
+			reply("250 8BITMIME")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			recipient = strings.ToLower(strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>"))
+			if recipient == "stalled@test.it" {
+				continue
+			}
+			if recipient == "rejected@test.it" {
+				reply("550 no such user")
+			} else {
+				reply("250 OK")
+			}
+		case command == "DATA":
+			reply("354 go ahead")
+			var message strings.Builder
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				message.WriteString(dataLine)
+			}
+			server.mutex.Lock()
+			server.messages[recipient] = message.String()
+			server.mutex.Unlock()
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			// MAIL, RSET and NOOP
+			reply("250 OK")
+		}
+	}
+}
+
+// TestSmtpSender tests that every recipient receives the rendered mail over a single connection, while the
+// recipients refused by the server are reported
+func TestSmtpSender(t *testing.T) {
+	server := startFakeSmtpServer(t)
+	defer server.listener.Close()
+	mailTemplate := &mailtemplate.MailTemplate{
+		SubjectPart: "[{{courseName}} {{year}}]",
+		TextPart:    "{{{body}}}",
+		HtmlPart:    "<p>{{{htmlBody}}}</p>",
+	}
+	if err := mailTemplate.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	sender := notificationhandler.NewSmtpSender(notificationhandler.SmtpSettings{
+		Address:  server.listener.Addr().String(),
+		Insecure: true,
+	}, mailtemplate.Set{Default: mailTemplate})
+	defer sender.Close()
+
+	message := entity.Notification{Name: "testcourse", Year: "2018-2019", Message: "Exam <b>moved</b>"}
+	result, err := sender.SendNotification(context.Background(), message, "", "noreply@test.it", []string{"first@test.it", "rejected@test.it"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Delivered) != 1 || result.Delivered["first@test.it"] == "" || result.Failed["rejected@test.it"] == "" {
+		t.Error("Unexpected result", result)
+	}
+	_, err = sender.SendNotification(context.Background(), message, "", "noreply@test.it", []string{"second@test.it"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.connections != 1 {
+		t.Error("Expected a single connection but got", server.connections)
+	}
+	received := server.messages["second@test.it"]
+	for _, expected := range []string{"To: second@test.it", "Subject: [testcourse 2018-2019]", "multipart/alternative",
+		"&lt;b&gt;moved&lt;/b&gt;"} {
+		if !strings.Contains(received, expected) {
+			t.Error("Expected " + expected + " in the message:\n" + received)
+		}
+	}
+	if _, ok := server.messages["rejected@test.it"]; ok {
+		t.Error("Unexpected message for rejected@test.it")
+	}
+}
+
+// TestSmtpSenderRequiresStartTls tests that the mails are not sent in clear unless it is allowed
+func TestSmtpSenderRequiresStartTls(t *testing.T) {
+	server := startFakeSmtpServer(t)
+	defer server.listener.Close()
+	mailTemplate := &mailtemplate.MailTemplate{TextPart: "{{body}}"}
+	if err := mailTemplate.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	sender := notificationhandler.NewSmtpSender(notificationhandler.SmtpSettings{
+		Address: server.listener.Addr().String(),
+	}, mailtemplate.Set{Default: mailTemplate})
+	defer sender.Close()
+
+	message := entity.Notification{Name: "testcourse", Year: "2018-2019", Message: "test"}
+	_, err := sender.SendNotification(context.Background(), message, "", "noreply@test.it", []string{"first@test.it"})
+	if err != notificationhandler.NotDeliveredError {
+		t.Error("Expected NotDeliveredError but got", err)
+	}
+}
+
+// TestSmtpSenderStalledServer tests that a send blocked by a server that does not reply is interrupted when its
+// context is cancelled
+func TestSmtpSenderStalledServer(t *testing.T) {
+	server := startFakeSmtpServer(t)
+	defer server.listener.Close()
+	mailTemplate := &mailtemplate.MailTemplate{TextPart: "{{body}}"}
+	if err := mailTemplate.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	sender := notificationhandler.NewSmtpSender(notificationhandler.SmtpSettings{
+		Address:  server.listener.Addr().String(),
+		Insecure: true,
+	}, mailtemplate.Set{Default: mailTemplate})
+	defer sender.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+	start := time.Now()
+	message := entity.Notification{Name: "testcourse", Year: "2018-2019", Message: "test"}
+	result, err := sender.SendNotification(ctx, message, "", "noreply@test.it", []string{"stalled@test.it", "first@test.it"})
+	if err != notificationhandler.NotDeliveredError || len(result.Failed) != 2 {
+		t.Error("Expected NotDeliveredError but got", result, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Error("The send has not been interrupted after", elapsed)
+	}
+
+	// the connection interrupted is replaced by a new one
+	result, err = sender.SendNotification(context.Background(), message, "", "noreply@test.it", []string{"first@test.it"})
+	if err != nil || len(result.Delivered) != 1 {
+		t.Error("Unexpected result", result, err)
+	}
+}
@@ -0,0 +1,201 @@
+package sqlstore
+
+import (
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/redefik/notificationmanagement/coursehandler"
+	"github.com/redefik/notificationmanagement/entity"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openTestRepository creates a SQL course store backed by a temporary SQLite database.
+// The returned function releases the database
+func openTestRepository(t *testing.T) (*coursehandler.SqlRepository, string, func()) {
+	directory, err := ioutil.TempDir("", "sqlstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(directory, "courses.db")
+	repository, err := coursehandler.NewSqlRepository("sqlite3", path)
+	if err != nil {
+		os.RemoveAll(directory)
+		t.Fatal(err)
+	}
+	return repository, path, func() {
+		repository.Close()
+		os.RemoveAll(directory)
+	}
+}
+
+// TestSqlStoreErrorContract tests that the SQL backend returns the same ad hoc errors of the DynamoDB backend
+func TestSqlStoreErrorContract(t *testing.T) {
+	repository, _, tearDown := openTestRepository(t)
+	defer tearDown()
+
+	course := entity.Course{Name: "testcourse", Department: "testdepartment", Year: "2018-2019"}
+	if err := repository.AddStudent(course, "student@test.it"); err != coursehandler.NotFoundError {
+		t.Error("Expected NotFoundError but got", err)
+	}
+	if err := repository.CreateCourse(course); err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.CreateCourse(course); err != coursehandler.ConflictError {
+		t.Error("Expected ConflictError but got", err)
+	}
+	mailingList, err := repository.GetCourseMailingList(course)
+	if err != nil || len(mailingList) != 0 {
+		t.Error("Expected an empty mailing list but got", mailingList, err)
+	}
+	if err = repository.AddStudent(course, "student@test.it"); err != nil {
+		t.Fatal(err)
+	}
+	if err = repository.AddStudent(course, "student@test.it"); err != coursehandler.ConflictMailError {
+		t.Error("Expected ConflictMailError but got", err)
+	}
+	if err = repository.DeleteCourse(course); err != nil {
+		t.Fatal(err)
+	}
+	if err = repository.DeleteCourse(course); err != coursehandler.NotFoundError {
+		t.Error("Expected NotFoundError but got", err)
+	}
+	if _, err = repository.GetCourseMailingList(course); err != coursehandler.NotFoundError {
+		t.Error("Expected NotFoundError but got", err)
+	}
+}
+
+// TestSqlStoreSubscriptions tests that the subscriptions are stored as rows of the subscriptions table and that
+// they are removed together with their course
+func TestSqlStoreSubscriptions(t *testing.T) {
+	repository, path, tearDown := openTestRepository(t)
+	defer tearDown()
+
+	course := entity.Course{Name: "testcourse", Department: "testdepartment", Year: "2018-2019"}
+	otherCourse := entity.Course{Name: "othercourse", Department: "testdepartment", Year: "2018-2019"}
+	repository.CreateCourse(course)
+	repository.CreateCourse(otherCourse)
+	repository.AddStudent(course, "second@test.it")
+	repository.AddStudent(course, "first@test.it")
+	repository.AddStudent(otherCourse, "first@test.it")
+	repository.RemoveStudent(course, "second@test.it")
+
+	mailingList, err := repository.GetCourseMailingList(course)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mailingList) != 1 || mailingList[0] != "first@test.it" {
+		t.Error("Expected [first@test.it] but got", mailingList)
+	}
+
+	repository.DeleteCourse(course)
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var subscriptions int
+	err = db.QueryRow("SELECT COUNT(*) FROM subscriptions").Scan(&subscriptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subscriptions != 1 {
+		t.Error("Expected 1 subscription but got", subscriptions)
+	}
+}
+
+// TestSqlStoreMigrations tests that reopening the database does not apply the migrations again
+func TestSqlStoreMigrations(t *testing.T) {
+	repository, path, tearDown := openTestRepository(t)
+	defer tearDown()
+
+	course := entity.Course{Name: "testcourse", Department: "testdepartment", Year: "2018-2019"}
+	repository.CreateCourse(course)
+	reopenedRepository, err := coursehandler.NewSqlRepository("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopenedRepository.Close()
+	if err = reopenedRepository.CreateCourse(course); err != coursehandler.ConflictError {
+		t.Error("Expected ConflictError but got", err)
+	}
+}
+
+// TestSqlStoreListCourses tests the keyset pagination of the courses
+func TestSqlStoreListCourses(t *testing.T) {
+	repository, _, tearDown := openTestRepository(t)
+	defer tearDown()
+
+	for _, name := range []string{"c", "a", "b"} {
+		repository.CreateCourse(entity.Course{Name: name, Department: "testdepartment", Year: "2018-2019"})
+	}
+	repository.CreateCourse(entity.Course{Name: "a", Department: "otherdepartment", Year: "2018-2019"})
+	filter := coursehandler.CourseFilter{Department: "testdepartment"}
+	courses, next, err := repository.ListCourses(filter, "", 2)
+	if err != nil || len(courses) != 2 || courses[0].Name != "a" || courses[1].Name != "b" || next == "" {
+		t.Fatal("Unexpected first page", courses, next, err)
+	}
+	courses, next, err = repository.ListCourses(filter, next, 2)
+	if err != nil || len(courses) != 1 || courses[0].Name != "c" || next != "" {
+		t.Error("Unexpected last page", courses, next, err)
+	}
+	if _, _, err = repository.ListCourses(filter, "invalid", 2); err != coursehandler.InvalidPageTokenError {
+		t.Error("Expected InvalidPageTokenError but got", err)
+	}
+}
+
+// TestSqlStoreStudentCourses tests the reverse lookup of the subscriptions and the removal of all of them
+func TestSqlStoreStudentCourses(t *testing.T) {
+	repository, _, tearDown := openTestRepository(t)
+	defer tearDown()
+
+	first := entity.Course{Name: "a", Department: "testdepartment", Year: "2018-2019"}
+	second := entity.Course{Name: "b", Department: "testdepartment", Year: "2018-2019"}
+	repository.CreateCourse(first)
+	repository.CreateCourse(second)
+	repository.AddStudent(first, "student@test.it")
+	repository.AddStudent(second, "Student@test.it")
+	repository.AddStudent(second, "other@test.it")
+
+	courses, err := repository.GetStudentCourses("STUDENT@test.it")
+	if err != nil || len(courses) != 2 || courses[0] != first || courses[1] != second {
+		t.Fatal("Unexpected courses", courses, err)
+	}
+	courses, err = repository.RemoveStudentFromAllCourses("student@test.it")
+	if err != nil || len(courses) != 2 {
+		t.Fatal("Unexpected removed courses", courses, err)
+	}
+	mailingList, err := repository.GetCourseMailingList(second)
+	if err != nil || len(mailingList) != 1 || mailingList[0] != "other@test.it" {
+		t.Error("Expected [other@test.it] but got", mailingList, err)
+	}
+	courses, err = repository.GetStudentCourses("student@test.it")
+	if err != nil || len(courses) != 0 {
+		t.Error("Expected no courses but got", courses, err)
+	}
+}
+
+// TestSqlStoreAddStudents tests that the import reports the mails already subscribed
+func TestSqlStoreAddStudents(t *testing.T) {
+	repository, _, tearDown := openTestRepository(t)
+	defer tearDown()
+
+	course := entity.Course{Name: "testcourse", Department: "testdepartment", Year: "2018-2019"}
+	if _, err := repository.AddStudents(course, []string{"student@test.it"}); err != coursehandler.NotFoundError {
+		t.Error("Expected NotFoundError but got", err)
+	}
+	repository.CreateCourse(course)
+	repository.AddStudent(course, "first@test.it")
+	results, err := repository.AddStudents(course, []string{"First@test.it", "second@test.it", "second@test.it"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0] != coursehandler.ConflictMailError || results[1] != nil || results[2] != coursehandler.ConflictMailError {
+		t.Error("Unexpected results", results)
+	}
+	mailingList, _ := repository.GetCourseMailingList(course)
+	if len(mailingList) != 2 {
+		t.Error("Expected 2 students but got", mailingList)
+	}
+}
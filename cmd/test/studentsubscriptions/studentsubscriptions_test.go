@@ -0,0 +1,109 @@
+package studentsubscriptions
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/redefik/notificationmanagement/config"
+	"github.com/redefik/notificationmanagement/coursehandler"
+	"github.com/redefik/notificationmanagement/entity"
+	"github.com/redefik/notificationmanagement/resthandler"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+var testCourses = []entity.Course{
+	{Name: "testcoursea", Department: "testdepartment", Year: "2018-2019"},
+	{Name: "testcourseb", Department: "testdepartment", Year: "2018-2019"},
+	{Name: "testcoursec", Department: "testdepartment", Year: "2018-2019"},
+}
+
+// createTestMicroserviceStudentSubscriptions builds an http handler used to test the functionality about the
+// subscriptions of a student
+func createTestMicroserviceStudentSubscriptions() http.Handler {
+	r := mux.NewRouter()
+	r.HandleFunc("/notification_management/api/v1.0/student/{studentMail}/courses", resthandler.GetStudentCourses).Methods(http.MethodGet)
+	r.HandleFunc("/notification_management/api/v1.0/student/{studentMail}/courses", resthandler.RemoveStudentSubscriptions).Methods(http.MethodDelete)
+	return r
+}
+
+// setup populates an in-memory course data store: the student is subscribed to the first two test courses
+func setup(t *testing.T) {
+	config.Configuration.CourseStore = coursehandler.MemoryStore
+	err := coursehandler.InitializeRepository()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, course := range testCourses {
+		coursehandler.Repository.CreateCourse(course)
+	}
+	coursehandler.Repository.AddStudent(testCourses[0], "student@test.it")
+	coursehandler.Repository.AddStudent(testCourses[1], "student@test.it")
+	coursehandler.Repository.AddStudent(testCourses[1], "other@test.it")
+}
+
+// makeRequest simulates a request-response interaction between client and microservice, decoding the courses
+// returned on success
+func makeRequest(t *testing.T, method string, url string, expectedCode int) []entity.Course {
+	request, _ := http.NewRequest(method, url, nil)
+	response := httptest.NewRecorder()
+	createTestMicroserviceStudentSubscriptions().ServeHTTP(response, request)
+	if response.Code != expectedCode {
+		t.Fatal(method + " " + url + ": expected " + strconv.Itoa(expectedCode) + " but got " +
+			strconv.Itoa(response.Code) + " " + http.StatusText(response.Code))
+	}
+	if response.Code != http.StatusOK {
+		return nil
+	}
+	var body struct {
+		Student string          `json:"student"`
+		Courses []entity.Course `json:"courses"`
+	}
+	err := json.Unmarshal(response.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body.Student != "student@test.it" {
+		t.Error("Expected student@test.it but got", body.Student)
+	}
+	return body.Courses
+}
+
+// TestGetStudentCourses tests that the courses of the student are returned, whatever the case of the mail
+func TestGetStudentCourses(t *testing.T) {
+	setup(t)
+
+	courses := makeRequest(t, http.MethodGet, "/notification_management/api/v1.0/student/Student@Test.it/courses", http.StatusOK)
+	if len(courses) != 2 || courses[0] != testCourses[0] || courses[1] != testCourses[1] {
+		t.Error("Unexpected courses", courses)
+	}
+}
+
+// TestRemoveStudentSubscriptions tests that the student is unsubscribed from every course, without affecting the
+// other students
+func TestRemoveStudentSubscriptions(t *testing.T) {
+	setup(t)
+
+	url := "/notification_management/api/v1.0/student/student@test.it/courses"
+	courses := makeRequest(t, http.MethodDelete, url, http.StatusOK)
+	if len(courses) != 2 {
+		t.Error("Expected 2 courses but got", courses)
+	}
+	courses = makeRequest(t, http.MethodGet, url, http.StatusOK)
+	if len(courses) != 0 {
+		t.Error("Expected no courses but got", courses)
+	}
+	mailingList, _ := coursehandler.Repository.GetCourseMailingList(testCourses[1])
+	if len(mailingList) != 1 || mailingList[0] != "other@test.it" {
+		t.Error("Expected [other@test.it] but got", mailingList)
+	}
+}
+
+// TestStudentCoursesInvalidMail tests that a malformed mail is rejected
+func TestStudentCoursesInvalidMail(t *testing.T) {
+	setup(t)
+
+	makeRequest(t, http.MethodGet, "/notification_management/api/v1.0/student/notamail/courses", http.StatusBadRequest)
+	makeRequest(t, http.MethodDelete, "/notification_management/api/v1.0/student/notamail/courses", http.StatusBadRequest)
+}
@@ -19,6 +19,9 @@ import (
 	"testing"
 )
 
+// Partition keys of the items used for testing purpose
+var testCourseSubscriptionKey = coursehandler.CourseKey{Name: "testcoursesubscription", Department: "testdepartment", Year: "2018-2019"}.Encode()
+
 // createTestMicroserviceCourseSubscriptionCreation builds an http handler used to test functionality about student subscriptions
 // to course's mailing list
 func createTestMicroserviceCourseSusbscriptionCreation() http.Handler {
@@ -32,7 +35,7 @@ func setup() {
 	newSession := session.Must(session.NewSessionWithOptions(session.Options{
 		SharedConfigState: session.SharedConfigEnable,
 	}))
-	courseItem := coursehandler.CourseCreationItem{CourseName: "testcoursesubscription_testdepartment_2018-2019"}
+	courseItem := coursehandler.CourseItem{CourseName: testCourseSubscriptionKey}
 	marshaledCourse, err := dynamodbattribute.MarshalMap(courseItem)
 	if err != nil {
 		log.Println(err)
@@ -56,7 +59,7 @@ func tearDown() {
 	input := &dynamodb.DeleteItemInput{
 		Key: map[string]*dynamodb.AttributeValue{
 			"CourseName": {
-				S: aws.String("testcoursesubscription_testdepartment_2018-2019"),
+				S: aws.String(testCourseSubscriptionKey),
 			},
 		},
 		TableName: aws.String(config.Configuration.CoursesTableName),
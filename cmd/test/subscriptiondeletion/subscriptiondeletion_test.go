@@ -19,6 +19,9 @@ import (
 	"testing"
 )
 
+// Partition keys of the items used for testing purpose
+var testCourseSubscriptionKey = coursehandler.CourseKey{Name: "testcoursesubscription", Department: "testdepartment", Year: "2018-2019"}.Encode()
+
 // createTestMicroserviceCourseSubscriptionDeletion builds an http handler used to test functionality about student subscriptions
 // to course's mailing list
 func createTestMicroserviceCourseSusbscriptionDeletion() http.Handler {
@@ -33,7 +36,7 @@ func setup() {
 		SharedConfigState: session.SharedConfigEnable,
 	}))
 	mailingList := []string{"isssr.ticketing@gmail.com", "other@mail.com"}
-	courseItem := coursehandler.CourseItem{CourseName: "testcoursesubscription_testdepartment_2018-2019", MailingList: mailingList}
+	courseItem := coursehandler.CourseItem{CourseName: testCourseSubscriptionKey, MailingList: mailingList}
 	marshaledCourse, err := dynamodbattribute.MarshalMap(courseItem)
 	if err != nil {
 		log.Println(err)
@@ -57,7 +60,7 @@ func tearDown() {
 	input := &dynamodb.DeleteItemInput{
 		Key: map[string]*dynamodb.AttributeValue{
 			"CourseName": {
-				S: aws.String("testcoursesubscription_testdepartment_2018-2019"),
+				S: aws.String(testCourseSubscriptionKey),
 			},
 		},
 		TableName: aws.String(config.Configuration.CoursesTableName),
@@ -110,7 +113,7 @@ func TestCourseSubscriptionDeletionSuccess(t *testing.T) {
 		TableName: aws.String(config.Configuration.CoursesTableName),
 		Key: map[string]*dynamodb.AttributeValue{
 			"CourseName": {
-				S: aws.String("testcoursesubscription_testdepartment_2018-2019"),
+				S: aws.String(testCourseSubscriptionKey),
 			},
 		},
 	}
@@ -0,0 +1,45 @@
+package templatedata
+
+import (
+	"encoding/json"
+	"github.com/redefik/notificationmanagement/entity"
+	"github.com/redefik/notificationmanagement/notificationhandler"
+	"testing"
+)
+
+// TestTemplateDataEncoding tests that a body containing quotes, backslashes and line breaks produces valid JSON
+// and is delivered unchanged to the TextPart
+func TestTemplateDataEncoding(t *testing.T) {
+	message := entity.Notification{
+		Name:    "Advanced \"Calculus\"",
+		Year:    "2018-2019",
+		Message: "The exam is in room C\\1.\r\nBring \"your\" ID",
+	}
+	data, err := notificationhandler.BuildTemplateData(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parameters map[string]string
+	err = json.Unmarshal([]byte(data), &parameters)
+	if err != nil {
+		t.Fatal("Invalid JSON:", data, err)
+	}
+	if parameters["courseName"] != message.Name || parameters["year"] != message.Year || parameters["body"] != message.Message {
+		t.Error("Unexpected parameters", parameters)
+	}
+}
+
+// TestTemplateDataHtmlEscaping tests that the markup written in the body is escaped in the HTML version
+func TestTemplateDataHtmlEscaping(t *testing.T) {
+	message := entity.Notification{Name: "testcourse", Year: "2018-2019", Message: "<script>alert('x')</script> & co.\nbye"}
+	data, err := notificationhandler.BuildTemplateData(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parameters map[string]string
+	json.Unmarshal([]byte(data), &parameters)
+	expectedHtmlBody := "&lt;script&gt;alert(&#39;x&#39;)&lt;/script&gt; &amp; co.<br>\nbye"
+	if parameters["htmlBody"] != expectedHtmlBody {
+		t.Error("Expected " + expectedHtmlBody + " but got " + parameters["htmlBody"])
+	}
+}
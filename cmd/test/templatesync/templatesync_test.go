@@ -0,0 +1,130 @@
+package templatesync
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/gorilla/mux"
+	"github.com/redefik/notificationmanagement/config"
+	"github.com/redefik/notificationmanagement/mailtemplate"
+	"github.com/redefik/notificationmanagement/notificationhandler"
+	"github.com/redefik/notificationmanagement/resthandler"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func writeTemplateFile(t *testing.T, directory string, file string, content string) {
+	err := ioutil.WriteFile(filepath.Join(directory, file), []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestLoadDirectory tests that the templates of a directory are indexed by name and that duplicates are rejected
+func TestLoadDirectory(t *testing.T) {
+	templates, err := mailtemplate.LoadDirectory(filepath.Join("..", "..", "..", "config", "templates"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := templates["MailTemplate"]; !ok || len(templates) != 1 {
+		t.Error("Unexpected templates", templates)
+	}
+
+	directory, err := ioutil.TempDir("", "templatesync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	writeTemplateFile(t, directory, "a.json", `{"Template": {"TemplateName": "Same", "SubjectPart": "a"}}`)
+	writeTemplateFile(t, directory, "b.json", `{"Template": {"TemplateName": "Same", "SubjectPart": "b"}}`)
+	if _, err = mailtemplate.LoadDirectory(directory); err == nil {
+		t.Error("Expected an error for the duplicated template")
+	}
+	writeTemplateFile(t, directory, "b.json", `{"Template": {"SubjectPart": "b"}}`)
+	if _, err = mailtemplate.LoadDirectory(directory); err == nil {
+		t.Error("Expected an error for the template without name")
+	}
+}
+
+// TestTemplateDeletion tests that only the templates not defined by any template file can be deleted
+func TestTemplateDeletion(t *testing.T) {
+	directory, err := ioutil.TempDir("", "templatesync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	writeTemplateFile(t, directory, "department.json", `{"Template": {"TemplateName": "DepartmentTemplate"}}`)
+	writeTemplateFile(t, directory, "english.json", `{"Template": {"TemplateName": "DepartmentTemplate-en"}}`)
+
+	// the SES client is never used, since the deletions are refused
+	manager := notificationhandler.NewTemplateManager(nil, directory)
+	for _, name := range []string{"DepartmentTemplate", "DepartmentTemplate-en"} {
+		if err = manager.Delete(name); err != notificationhandler.TemplateInUseError {
+			t.Error("Expected TemplateInUseError for", name, "but got", err)
+		}
+	}
+	if err = manager.CheckDeletion("OldTemplate"); err != nil {
+		t.Error("Expected the extra template to be deletable but got", err)
+	}
+}
+
+// TestDiffTemplates tests the comparison between the template files and the SES templates
+func TestDiffTemplates(t *testing.T) {
+	local := map[string]*mailtemplate.MailTemplate{
+		"Same":    {TemplateName: "Same", SubjectPart: "s", HtmlPart: "h", TextPart: "t"},
+		"Changed": {TemplateName: "Changed", SubjectPart: "s", HtmlPart: "new", TextPart: "t"},
+		"Missing": {TemplateName: "Missing"},
+	}
+	remote := map[string]*ses.Template{
+		"Same":    {SubjectPart: aws.String("s"), HtmlPart: aws.String("h"), TextPart: aws.String("t")},
+		"Changed": {SubjectPart: aws.String("s"), HtmlPart: aws.String("old"), TextPart: aws.String("t")},
+		"Extra":   {TemplateName: aws.String("Extra")},
+	}
+	expected := []notificationhandler.TemplateDiff{
+		{Name: "Changed", Status: notificationhandler.TemplateChanged, ChangedParts: []string{"HtmlPart"}},
+		{Name: "Extra", Status: notificationhandler.TemplateExtra},
+		{Name: "Missing", Status: notificationhandler.TemplateMissing},
+		{Name: "Same", Status: notificationhandler.TemplateUnchanged},
+	}
+	if diffs := notificationhandler.DiffTemplates(local, remote); !reflect.DeepEqual(diffs, expected) {
+		t.Error("Unexpected differences", diffs)
+	}
+}
+
+// TestAdminAuthorization tests that the admin endpoints require the configured token
+func TestAdminAuthorization(t *testing.T) {
+	r := mux.NewRouter()
+	r.HandleFunc("/notification_management/api/v1.0/admin/templates", resthandler.ListTemplates).Methods(http.MethodGet)
+	notificationhandler.Templates = nil
+
+	tests := []struct {
+		adminToken    string
+		authorization string
+		expected      int
+	}{
+		{"", "Bearer ", http.StatusForbidden},
+		{"secret", "", http.StatusUnauthorized},
+		{"secret", "Bearer wrong", http.StatusUnauthorized},
+		{"secret", "secret", http.StatusUnauthorized},
+		// the token is accepted, but SES is not in use
+		{"secret", "Bearer secret", http.StatusNotImplemented},
+	}
+	for _, test := range tests {
+		config.Configuration.AdminToken = test.adminToken
+		request, _ := http.NewRequest(http.MethodGet, "/notification_management/api/v1.0/admin/templates", nil)
+		if test.authorization != "" {
+			request.Header.Set("Authorization", test.authorization)
+		}
+		response := httptest.NewRecorder()
+		r.ServeHTTP(response, request)
+		if response.Code != test.expected {
+			t.Error("Expected " + strconv.Itoa(test.expected) + " but got " + strconv.Itoa(response.Code) + " " +
+				http.StatusText(response.Code))
+		}
+	}
+}
@@ -11,15 +11,44 @@ var Configuration Config
 
 // Encapsulates the fields of the configuration file
 type Config struct {
-	ListeningAddress  string
-	CoursesTableName  string
-	MessageQueueName  string
-	PollingWaitTime   int64
-	AwsSesRegion      string
-	AwsSqsRegion      string
-	AwsDynamoDbRegion string
-	MailTemplate      string
-	MailAddress       string
+	ListeningAddress       string
+	CoursesTableName       string
+	SubscriptionsTableName string // DynamoDB table mapping each student to the subscribed courses
+	StudentsTableName      string // DynamoDB table containing the preferred locale of each student
+	DeliveriesTableName    string // DynamoDB table recording the students each notification has been sent to
+	MessageQueueName       string
+	DeadLetterQueueName    string // queue receiving the notifications that cannot be sent, see DeadLetterDirectory
+	DeadLetterDirectory    string // directory storing the notifications that cannot be sent when no dead-letter queue is set
+	MaxReceiveCount        int64  // attempts after which a notification is dead-lettered (default 10)
+	VisibilityTimeout      int64  // seconds for which a notification being sent is hidden, renewed until the end (default 60)
+	PollingWaitTime        int64
+	NotificationWorkers    int   // number of notifications sent at the same time (default 4)
+	ShutdownTimeout        int64 // seconds given to the work in progress when the microservice is stopped (default 20)
+	AwsSesRegion           string
+	AwsSqsRegion           string
+	AwsDynamoDbRegion      string
+	AwsS3Region            string // region of the attachment bucket (default AwsSesRegion)
+	MailTemplate           string
+	MailTemplateFile       string // template rendered by the senders other than SES (default "config/templates/mailtemplate.json")
+	TemplateDirectory      string // template files uploaded to SES by templatesync (default "config/templates")
+	AdminToken             string // bearer token required by the admin endpoints, that are disabled when empty
+	MailSender             string // sender of the notifications: "ses" (default), "smtp" or "file"
+	SmtpAddress            string // host:port of the SMTP server used by the "smtp" sender
+	SmtpUsername           string // credentials of the SMTP server, authentication is skipped when empty
+	SmtpPassword           string
+	SmtpInsecure           bool   // allows the "smtp" sender to send in clear when the server does not support STARTTLS
+	MailSinkDirectory      string // directory of the .eml files written by the "file" sender, standard output when empty
+	AttachmentBucket       string // S3 bucket containing the files attached to the notifications by key
+	MaxAttachmentSize      int64  // maximum total size in bytes of the attachments of a notification (default 7 MB)
+	MailAddress            string
+	CourseStore            string // backend of the course data store: "dynamodb" (default), "memory", "bolt" or "sql"
+	BoltDbPath             string // file used by the "bolt" course store
+	SqlDriver              string // database/sql driver used by the "sql" course store (e.g. "postgres")
+	SqlDataSource          string // data source name used by the "sql" course store
+
+	// template used for the courses of each department that have no template of their own, MailTemplate is used
+	// for the departments that are not listed
+	DepartmentTemplates map[string]string
 }
 
 func SetConfiguration(configFile string) error {
@@ -0,0 +1,692 @@
+package coursehandler
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/redefik/notificationmanagement/entity"
+	"go.etcd.io/bbolt"
+	"time"
+)
+
+/*Embedded implementation of CourseRepository based on BoltDB. All the data live in a single file, so the backend
+is suitable for deployments made of a single node that cannot access DynamoDB*/
+
+// Name of the bucket containing the courses. The key of each entry is the encoded CourseKey, the value is the JSON
+// encoded mailing list
+var coursesBucket = []byte("Courses")
+
+// Name of the bucket indexing the subscriptions by student. It contains a nested bucket for each subscribed mail,
+// whose keys are the encoded CourseKeys of the courses the student is subscribed to
+var studentsBucket = []byte("Students")
+
+// Name of the bucket containing the names of the mail templates of the courses, indexed by encoded CourseKey. The
+// courses using the template of their department have no entry
+var templatesBucket = []byte("Templates")
+
+// Name of the bucket containing the default languages of the courses, indexed by encoded CourseKey
+var languagesBucket = []byte("Languages")
+
+// Name of the bucket containing the preferred locales of the students, indexed by normalized mail
+var localesBucket = []byte("Locales")
+
+// Name of the bucket containing the deliveries of the notifications, indexed by notification id. The value of each
+// entry is the JSON encoded boltDelivery
+var deliveriesBucket = []byte("Deliveries")
+
+// boltDelivery is the form in which a Delivery is stored
+type boltDelivery struct {
+	Delivery
+	ExpiresAt time.Time
+}
+
+// BoltRepository stores the courses in a BoltDB file. Every operation runs inside a BoltDB transaction, so the
+// repository is safe to be used concurrently
+type BoltRepository struct {
+	db *bbolt.DB
+}
+
+// NewBoltRepository opens (creating it if needed) the BoltDB file at the provided path.
+// The file is locked until Close is called, so it cannot be shared by different processes
+func NewBoltRepository(path string) (*BoltRepository, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(coursesBucket)
+		if err != nil {
+			return err
+		}
+		err = migrateLegacyBoltKeys(bucket)
+		if err != nil {
+			return err
+		}
+		for _, bucketName := range [][]byte{templatesBucket, languagesBucket, localesBucket, deliveriesBucket} {
+			_, err = tx.CreateBucketIfNotExists(bucketName)
+			if err != nil {
+				return err
+			}
+		}
+		// the files written before the introduction of the index are indexed once
+		if tx.Bucket(studentsBucket) == nil {
+			return buildStudentsIndex(tx)
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltRepository{db: db}, nil
+}
+
+// migrateLegacyBoltKeys re-encodes the keys of the entries written before the introduction of CourseKey
+func migrateLegacyBoltKeys(bucket *bbolt.Bucket) error {
+	var legacyKeys [][]byte
+	err := bucket.ForEach(func(key []byte, _ []byte) error {
+		if isLegacyCourseKey(string(key)) {
+			legacyKeys = append(legacyKeys, append([]byte(nil), key...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// the bucket cannot be modified while it is iterated, so the entries are moved afterwards
+	for _, legacyKey := range legacyKeys {
+		courseKey, err := parseLegacyCourseKey(string(legacyKey))
+		if err != nil {
+			return err
+		}
+		value := append([]byte(nil), bucket.Get(legacyKey)...)
+		err = bucket.Put([]byte(courseKey.Encode()), value)
+		if err != nil {
+			return err
+		}
+		err = bucket.Delete(legacyKey)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildStudentsIndex creates the bucket of the students and fills it with the subscriptions found in the mailing lists
+func buildStudentsIndex(tx *bbolt.Tx) error {
+	_, err := tx.CreateBucket(studentsBucket)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(coursesBucket).ForEach(func(key []byte, _ []byte) error {
+		mailingList, err := getMailingList(tx.Bucket(coursesBucket), key)
+		if err != nil {
+			return err
+		}
+		return indexSubscriptions(tx, key, mailingList, nil)
+	})
+}
+
+// indexSubscriptions updates the bucket of the students after the mails in added have been subscribed to the course
+// with the given key and the mails in removed have been unsubscribed from it
+func indexSubscriptions(tx *bbolt.Tx, key []byte, added []string, removed []string) error {
+	students := tx.Bucket(studentsBucket)
+	for _, studentMail := range added {
+		studentCourses, err := students.CreateBucketIfNotExists([]byte(studentMail))
+		if err != nil {
+			return err
+		}
+		err = studentCourses.Put(key, []byte{})
+		if err != nil {
+			return err
+		}
+	}
+	for _, studentMail := range removed {
+		studentCourses := students.Bucket([]byte(studentMail))
+		if studentCourses == nil {
+			continue
+		}
+		err := studentCourses.Delete(key)
+		if err != nil {
+			return err
+		}
+		// the bucket of a student without subscriptions is dropped
+		if courseKey, _ := studentCourses.Cursor().First(); courseKey == nil {
+			err = students.DeleteBucket([]byte(studentMail))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close releases the BoltDB file
+func (repository *BoltRepository) Close() error {
+	return repository.db.Close()
+}
+
+// getMailingList decodes the mailing list of the course with the given key. It returns NotFoundError if the course
+// does not exist
+func getMailingList(bucket *bbolt.Bucket, key []byte) ([]string, error) {
+	value := bucket.Get(key)
+	if value == nil {
+		return nil, NotFoundError
+	}
+	var mailingList []string
+	err := json.Unmarshal(value, &mailingList)
+	if err != nil {
+		return nil, err
+	}
+	return mailingList, nil
+}
+
+// putMailingList encodes the mailing list and stores it under the given key
+func putMailingList(bucket *bbolt.Bucket, key []byte, mailingList []string) error {
+	if mailingList == nil {
+		mailingList = []string{}
+	}
+	value, err := json.Marshal(mailingList)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, value)
+}
+
+// CreateCourse adds a course with an empty mailing list. It returns ConflictError if the course already exists
+func (repository *BoltRepository) CreateCourse(course entity.Course) error {
+	err := repository.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(coursesBucket)
+		key := []byte(NewCourseKey(course).Encode())
+		if bucket.Get(key) != nil {
+			return ConflictError
+		}
+		return putMailingList(bucket, key, nil)
+	})
+	return toRepositoryError(err)
+}
+
+// DeleteCourse removes the course. It returns NotFoundError if the course does not exist
+func (repository *BoltRepository) DeleteCourse(course entity.Course) error {
+	err := repository.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(coursesBucket)
+		key := []byte(NewCourseKey(course).Encode())
+		mailingList, err := getMailingList(bucket, key)
+		if err != nil {
+			return err
+		}
+		err = bucket.Delete(key)
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(templatesBucket).Delete(key)
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(languagesBucket).Delete(key)
+		if err != nil {
+			return err
+		}
+		return indexSubscriptions(tx, key, nil, mailingList)
+	})
+	return toRepositoryError(err)
+}
+
+// AddStudent appends the mail to the mailing list of the course. It returns NotFoundError if the course does not
+// exist and ConflictMailError if the mail is already in the mailing list
+func (repository *BoltRepository) AddStudent(course entity.Course, studentMail string) error {
+	studentMail = NormalizeMail(studentMail)
+	err := repository.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(coursesBucket)
+		key := []byte(NewCourseKey(course).Encode())
+		mailingList, err := getMailingList(bucket, key)
+		if err != nil {
+			return err
+		}
+		if contains(mailingList, studentMail) {
+			return ConflictMailError
+		}
+		err = putMailingList(bucket, key, append(mailingList, studentMail))
+		if err != nil {
+			return err
+		}
+		return indexSubscriptions(tx, key, []string{studentMail}, nil)
+	})
+	return toRepositoryError(err)
+}
+
+// AddStudents appends the mails to the mailing list of the course in a single transaction
+func (repository *BoltRepository) AddStudents(course entity.Course, studentMails []string) ([]error, error) {
+	results := make([]error, len(studentMails))
+	err := repository.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(coursesBucket)
+		key := []byte(NewCourseKey(course).Encode())
+		mailingList, err := getMailingList(bucket, key)
+		if err != nil {
+			return err
+		}
+		var addedMails []string
+		for i, studentMail := range studentMails {
+			studentMail = NormalizeMail(studentMail)
+			if contains(mailingList, studentMail) {
+				results[i] = ConflictMailError
+				continue
+			}
+			mailingList = append(mailingList, studentMail)
+			addedMails = append(addedMails, studentMail)
+		}
+		err = putMailingList(bucket, key, mailingList)
+		if err != nil {
+			return err
+		}
+		return indexSubscriptions(tx, key, addedMails, nil)
+	})
+	if err != nil {
+		return nil, toRepositoryError(err)
+	}
+	return results, nil
+}
+
+// RemoveStudent removes the mail from the mailing list of the course. It returns NotFoundError if the course does
+// not exist
+func (repository *BoltRepository) RemoveStudent(course entity.Course, studentMail string) error {
+	studentMail = NormalizeMail(studentMail)
+	err := repository.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(coursesBucket)
+		key := []byte(NewCourseKey(course).Encode())
+		mailingList, err := getMailingList(bucket, key)
+		if err != nil {
+			return err
+		}
+		err = putMailingList(bucket, key, removeMailFromList(studentMail, mailingList))
+		if err != nil {
+			return err
+		}
+		return indexSubscriptions(tx, key, nil, []string{studentMail})
+	})
+	return toRepositoryError(err)
+}
+
+// GetCourseMailingList returns the mailing list of the course. It returns NotFoundError if the course does not exist
+func (repository *BoltRepository) GetCourseMailingList(course entity.Course) ([]string, error) {
+	var mailingList []string
+	err := repository.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		mailingList, err = getMailingList(tx.Bucket(coursesBucket), []byte(NewCourseKey(course).Encode()))
+		return err
+	})
+	return mailingList, toRepositoryError(err)
+}
+
+// ImportCourse stores the course with the given mailing list, template and default language, overwriting the course
+// if it already exists. The mails are normalized and the duplicates removed, as AddStudent and RemoveStudent would
+// not match them otherwise
+func (repository *BoltRepository) ImportCourse(course entity.Course, mailingList []string, template string, language string) error {
+	mailingList = normalizeMailingList(mailingList)
+	err := repository.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(coursesBucket)
+		encodedKey := []byte(NewCourseKey(course).Encode())
+		// the subscriptions of the overwritten mailing list are removed from the index
+		previousMailingList, err := getMailingList(bucket, encodedKey)
+		if err != nil && err != NotFoundError {
+			return err
+		}
+		err = putMailingList(bucket, encodedKey, mailingList)
+		if err != nil {
+			return err
+		}
+		err = putOptionalValue(tx.Bucket(templatesBucket), encodedKey, template)
+		if err != nil {
+			return err
+		}
+		err = putOptionalValue(tx.Bucket(languagesBucket), encodedKey, language)
+		if err != nil {
+			return err
+		}
+		err = indexSubscriptions(tx, encodedKey, nil, previousMailingList)
+		if err != nil {
+			return err
+		}
+		return indexSubscriptions(tx, encodedKey, mailingList, nil)
+	})
+	return toRepositoryError(err)
+}
+
+// MigrateDynamoDbToBolt copies every course stored in DynamoDB, together with its mailing list, and the locales of
+// the students into the BoltDB repository. Courses already present in BoltDB are overwritten, so the migration can
+// be run more than once. The mails are normalized, so the DynamoDB tables need not be converted with
+// MigrateMailingListsToStringSets first. The function returns the number of copied courses
+func MigrateDynamoDbToBolt(source *DynamoDbRepository, destination *BoltRepository) (int, error) {
+	copiedCourses := 0
+	err := source.scanCourseItems(func(courseItem CourseItem) error {
+		// the items not yet migrated to the current CourseKey encoding are converted on the fly
+		key, err := parseStoredCourseKey(courseItem.CourseName)
+		if err != nil {
+			return err
+		}
+		err = destination.ImportCourse(key.Course(), courseItem.MailingList, courseItem.Template, courseItem.Language)
+		if err != nil {
+			return err
+		}
+		copiedCourses++
+		return nil
+	})
+	if err != nil {
+		return copiedCourses, err
+	}
+	// the locales of the students are copied as well
+	err = source.scanStudentItems(func(studentItem StudentItem) error {
+		return destination.SetStudentLocale(studentItem.StudentMail, studentItem.Locale)
+	})
+	return copiedCourses, err
+}
+
+// ListCourses returns the courses matching the filter, in the order of their keys inside the bucket
+func (repository *BoltRepository) ListCourses(filter CourseFilter, pageToken string, limit int) ([]entity.Course, string, error) {
+	var lastKey []byte
+	if pageToken != "" {
+		decodedToken, err := decodePageToken(pageToken)
+		if err != nil {
+			return nil, "", err
+		}
+		lastKey = []byte(decodedToken)
+	}
+	courses := make([]entity.Course, 0, limit)
+	nextPageToken := ""
+	err := repository.db.View(func(tx *bbolt.Tx) error {
+		cursor := tx.Bucket(coursesBucket).Cursor()
+		key, _ := cursor.First()
+		if lastKey != nil {
+			// the iteration restarts from the first key following the last course of the previous page
+			key, _ = cursor.Seek(lastKey)
+			if bytes.Equal(key, lastKey) {
+				key, _ = cursor.Next()
+			}
+		}
+		for ; key != nil; key, _ = cursor.Next() {
+			courseKey, err := ParseCourseKey(string(key))
+			if err != nil {
+				return err
+			}
+			if !filter.matches(courseKey) {
+				continue
+			}
+			if len(courses) == limit {
+				// there is at least another matching course, so a further page exists
+				nextPageToken = encodePageToken(NewCourseKey(courses[limit-1]).Encode())
+				return nil
+			}
+			courses = append(courses, courseKey.Course())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", toRepositoryError(err)
+	}
+	return courses, nextPageToken, nil
+}
+
+// studentCourseKeys returns the keys of the courses the mail is subscribed to, in the order of the index
+func studentCourseKeys(tx *bbolt.Tx, studentMail string) ([][]byte, error) {
+	var keys [][]byte
+	studentCourses := tx.Bucket(studentsBucket).Bucket([]byte(studentMail))
+	if studentCourses == nil {
+		return keys, nil
+	}
+	err := studentCourses.ForEach(func(key []byte, _ []byte) error {
+		keys = append(keys, append([]byte(nil), key...))
+		return nil
+	})
+	return keys, err
+}
+
+// coursesFromKeys decodes the keys of the courses
+func coursesFromKeys(keys [][]byte) ([]entity.Course, error) {
+	courses := make([]entity.Course, 0, len(keys))
+	for _, key := range keys {
+		courseKey, err := ParseCourseKey(string(key))
+		if err != nil {
+			return nil, err
+		}
+		courses = append(courses, courseKey.Course())
+	}
+	return courses, nil
+}
+
+// GetStudentCourses returns the courses whose mailing list contains the mail, reading the index of the students
+func (repository *BoltRepository) GetStudentCourses(studentMail string) ([]entity.Course, error) {
+	var courses []entity.Course
+	err := repository.db.View(func(tx *bbolt.Tx) error {
+		keys, err := studentCourseKeys(tx, NormalizeMail(studentMail))
+		if err != nil {
+			return err
+		}
+		courses, err = coursesFromKeys(keys)
+		return err
+	})
+	if err != nil {
+		return nil, toRepositoryError(err)
+	}
+	return courses, nil
+}
+
+// RemoveStudentFromAllCourses removes the mail from every mailing list in a single transaction and returns the
+// courses the student has been unsubscribed from
+func (repository *BoltRepository) RemoveStudentFromAllCourses(studentMail string) ([]entity.Course, error) {
+	studentMail = NormalizeMail(studentMail)
+	var courses []entity.Course
+	err := repository.db.Update(func(tx *bbolt.Tx) error {
+		keys, err := studentCourseKeys(tx, studentMail)
+		if err != nil {
+			return err
+		}
+		courses, err = coursesFromKeys(keys)
+		if err != nil {
+			return err
+		}
+		bucket := tx.Bucket(coursesBucket)
+		for _, key := range keys {
+			mailingList, err := getMailingList(bucket, key)
+			if err != nil {
+				return err
+			}
+			err = putMailingList(bucket, key, removeMailFromList(studentMail, mailingList))
+			if err != nil {
+				return err
+			}
+			err = indexSubscriptions(tx, key, nil, []string{studentMail})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, toRepositoryError(err)
+	}
+	return courses, nil
+}
+
+// putOptionalValue stores the value under the given key, removing the entry when the value is empty
+func putOptionalValue(bucket *bbolt.Bucket, key []byte, value string) error {
+	if value == "" {
+		return bucket.Delete(key)
+	}
+	return bucket.Put(key, []byte(value))
+}
+
+// SetCourseTemplate stores the name of the mail template of the course. It returns NotFoundError if the course does
+// not exist
+func (repository *BoltRepository) SetCourseTemplate(course entity.Course, templateName string) error {
+	err := repository.db.Update(func(tx *bbolt.Tx) error {
+		key := []byte(NewCourseKey(course).Encode())
+		if tx.Bucket(coursesBucket).Get(key) == nil {
+			return NotFoundError
+		}
+		return putOptionalValue(tx.Bucket(templatesBucket), key, templateName)
+	})
+	return toRepositoryError(err)
+}
+
+// GetCourseTemplate returns the name of the mail template of the course. It returns NotFoundError if the course does
+// not exist
+func (repository *BoltRepository) GetCourseTemplate(course entity.Course) (string, error) {
+	templateName := ""
+	err := repository.db.View(func(tx *bbolt.Tx) error {
+		key := []byte(NewCourseKey(course).Encode())
+		if tx.Bucket(coursesBucket).Get(key) == nil {
+			return NotFoundError
+		}
+		templateName = string(tx.Bucket(templatesBucket).Get(key))
+		return nil
+	})
+	return templateName, toRepositoryError(err)
+}
+
+// SetCourseLanguage stores the default language of the course. It returns NotFoundError if the course does not exist
+func (repository *BoltRepository) SetCourseLanguage(course entity.Course, language string) error {
+	err := repository.db.Update(func(tx *bbolt.Tx) error {
+		key := []byte(NewCourseKey(course).Encode())
+		if tx.Bucket(coursesBucket).Get(key) == nil {
+			return NotFoundError
+		}
+		return putOptionalValue(tx.Bucket(languagesBucket), key, language)
+	})
+	return toRepositoryError(err)
+}
+
+// GetCourseLanguage returns the default language of the course. It returns NotFoundError if the course does not
+// exist
+func (repository *BoltRepository) GetCourseLanguage(course entity.Course) (string, error) {
+	language := ""
+	err := repository.db.View(func(tx *bbolt.Tx) error {
+		key := []byte(NewCourseKey(course).Encode())
+		if tx.Bucket(coursesBucket).Get(key) == nil {
+			return NotFoundError
+		}
+		language = string(tx.Bucket(languagesBucket).Get(key))
+		return nil
+	})
+	return language, toRepositoryError(err)
+}
+
+// SetStudentLocale stores the preferred locale of the student
+func (repository *BoltRepository) SetStudentLocale(studentMail string, locale string) error {
+	err := repository.db.Update(func(tx *bbolt.Tx) error {
+		return putOptionalValue(tx.Bucket(localesBucket), []byte(NormalizeMail(studentMail)), NormalizeLocale(locale))
+	})
+	return toRepositoryError(err)
+}
+
+// GetStudentLocales returns the preferred locales of the given students
+func (repository *BoltRepository) GetStudentLocales(studentMails []string) (map[string]string, error) {
+	locales := make(map[string]string)
+	err := repository.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(localesBucket)
+		for _, studentMail := range studentMails {
+			studentMail = NormalizeMail(studentMail)
+			if locale := bucket.Get([]byte(studentMail)); locale != nil {
+				locales[studentMail] = string(locale)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, toRepositoryError(err)
+	}
+	return locales, nil
+}
+
+// updateDelivery applies the update to the delivery of the notification, creating it if needed. The expired deliveries
+// are removed when a new one is created
+func (repository *BoltRepository) updateDelivery(notificationId string, update func(delivery *boltDelivery)) error {
+	err := repository.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(deliveriesBucket)
+		var delivery boltDelivery
+		if value := bucket.Get([]byte(notificationId)); value != nil {
+			err := json.Unmarshal(value, &delivery)
+			if err != nil {
+				return err
+			}
+		} else {
+			now := time.Now()
+			err := removeExpiredDeliveries(bucket, now)
+			if err != nil {
+				return err
+			}
+			delivery.ExpiresAt = now.Add(DeliveryRetention)
+		}
+		update(&delivery)
+		value, err := json.Marshal(delivery)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(notificationId), value)
+	})
+	return toRepositoryError(err)
+}
+
+// removeExpiredDeliveries deletes the deliveries expired before now
+func removeExpiredDeliveries(bucket *bbolt.Bucket, now time.Time) error {
+	var expiredKeys [][]byte
+	err := bucket.ForEach(func(key []byte, value []byte) error {
+		var delivery boltDelivery
+		err := json.Unmarshal(value, &delivery)
+		if err != nil {
+			return err
+		}
+		if now.After(delivery.ExpiresAt) {
+			expiredKeys = append(expiredKeys, append([]byte(nil), key...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range expiredKeys {
+		err = bucket.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddDeliveredRecipients records that the notification has been sent to the students
+func (repository *BoltRepository) AddDeliveredRecipients(notificationId string, studentMails []string) error {
+	return repository.updateDelivery(notificationId, func(delivery *boltDelivery) {
+		delivered := make(map[string]bool)
+		for _, recipient := range delivery.Recipients {
+			delivered[recipient] = true
+		}
+		for _, studentMail := range studentMails {
+			studentMail = NormalizeMail(studentMail)
+			if !delivered[studentMail] {
+				delivered[studentMail] = true
+				delivery.Recipients = append(delivery.Recipients, studentMail)
+			}
+		}
+	})
+}
+
+// CompleteDelivery records that the notification has been sent to the whole mailing list
+func (repository *BoltRepository) CompleteDelivery(notificationId string) error {
+	return repository.updateDelivery(notificationId, func(delivery *boltDelivery) {
+		delivery.Completed = true
+	})
+}
+
+// GetDelivery returns the delivery of the notification
+func (repository *BoltRepository) GetDelivery(notificationId string) (Delivery, error) {
+	var delivery boltDelivery
+	err := repository.db.View(func(tx *bbolt.Tx) error {
+		value := tx.Bucket(deliveriesBucket).Get([]byte(notificationId))
+		if value == nil {
+			return nil
+		}
+		return json.Unmarshal(value, &delivery)
+	})
+	if err != nil {
+		return Delivery{}, toRepositoryError(err)
+	}
+	return delivery.Delivery, nil
+}
@@ -1,209 +1,216 @@
 package coursehandler
 
 import (
+	"encoding/base64"
 	"errors"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/redefik/notificationmanagement/config"
 	"github.com/redefik/notificationmanagement/entity"
 	"log"
+	"strings"
+	"time"
 )
 
 /*This package provides a set of functionality used to interact with the persistence layer
 that store information about the courses*/
 
-var Client *dynamodb.DynamoDB
-
 // Ad hoc errors returned by the functions
 var UnknownError = errors.New("an unknown error occurred during the interaction with course data store")
 var ConflictError = errors.New("a course with the provided information already exists")
 var ConflictMailError = errors.New("the mail already exists in the mailing list")
 var NotFoundError = errors.New("the provided course does not exist in the data store")
+var ConcurrentUpdateError = errors.New("the course has been modified concurrently, the operation can be retried")
+var InvalidPageTokenError = errors.New("the provided page token is not valid")
 
-// Encapsulates the fields of the DynamoDB item representing a course
-type CourseItem struct {
-	CourseName  string
-	MailingList []string
-}
-
-// As above but without MailingList field (to avoid problems about the type when the mailing list gets empty)
-type CourseCreationItem struct {
-	CourseName string
+// CourseFilter restricts the courses returned by ListCourses. An empty field matches every course
+type CourseFilter struct {
+	Department string
+	Year       string
 }
 
-// InitializeDynamoDbClient instantiate a DynamoDB client that will be used to make API requests to DynamoDB. The initialization
-// is performed once because, as reported in the documentation, the client is safe to be used concurrently
-func InitializeDynamoDbClient() {
-	sessionInitializer := session.Must(session.NewSession(&aws.Config{
-		Region: aws.String(config.Configuration.AwsDynamoDbRegion),
-	}))
-	//sessionInitializer := session.Must(session.NewSessionWithOptions(session.Options{
-	//	SharedConfigState: session.SharedConfigEnable,
-	//}))
-	Client = dynamodb.New(sessionInitializer)
+// Delivery is the progress of the sending of a notification, recorded so that a notification read again from the
+// queue is not sent twice to the same students
+type Delivery struct {
+	Completed  bool     // the notification has been sent to the whole mailing list
+	Recipients []string // normalized mails of the students the notification has already been sent to
 }
 
-// Add a course to the data store returning a not-nil value in case of error:
-// - ConflictError it the caller try to create an existent course
-// - UnknownError otherwise
-func CreateCourse(course entity.Course) error {
-	// Convert the course in the format read by dynamodb
-	courseItem := CourseCreationItem{CourseName: course.Name + "_" + course.Department + "_" + course.Year} // Name, Department and Year acts as a composite key
-	marshaledCourse, err := dynamodbattribute.MarshalMap(courseItem)
-	if err != nil {
-		log.Println(err)
-		return UnknownError
+// PendingRecipients returns the mails of the mailing list that are not among the recipients of the delivery
+func (delivery Delivery) PendingRecipients(mailingList []string) []string {
+	if len(delivery.Recipients) == 0 {
+		return mailingList
 	}
-	// Build the request for DynamoDB
-	putItemInput := &dynamodb.PutItemInput{
-		Item:                marshaledCourse,
-		ConditionExpression: aws.String("attribute_not_exists(CourseName)"),
-		TableName:           aws.String(config.Configuration.CoursesTableName),
+	delivered := make(map[string]bool, len(delivery.Recipients))
+	for _, recipient := range delivery.Recipients {
+		delivered[recipient] = true
 	}
-	_, err = Client.PutItem(putItemInput)
-	if err != nil {
-		log.Println(err)
-		// check if AWS DynamoDB raised an error
-		awsError, ok := err.(awserr.Error)
-		if ok {
-			switch awsError.Code() {
-			// raised when the client try to create a course that already exists
-			case dynamodb.ErrCodeConditionalCheckFailedException:
-				return ConflictError
-			default:
-				return UnknownError
-			}
+	var pending []string
+	for _, studentMail := range mailingList {
+		if !delivered[NormalizeMail(studentMail)] {
+			pending = append(pending, studentMail)
 		}
-		return UnknownError
 	}
-	return nil
+	return pending
 }
 
-// Delete the given course from the data store returning a not-nil value in case of error:
-// - NotFoundError when the caller try to delete a not existent course
-// - UnknownError otherwise
-func DeleteCourse(course entity.Course) error {
-	deleteItemInput := &dynamodb.DeleteItemInput{
-		Key: map[string]*dynamodb.AttributeValue{
-			"CourseName": {
-				S: aws.String(course.Name + "_" + course.Department + "_" + course.Year),
-			},
-		},
-		ConditionExpression: aws.String("attribute_exists(CourseName)"),
-		TableName:           aws.String(config.Configuration.CoursesTableName),
-	}
-	_, err := Client.DeleteItem(deleteItemInput)
-	if err != nil {
-		log.Println(err)
-		// check if AWS DynamoDB raised an error
-		awsError, ok := err.(awserr.Error)
-		if ok {
-			switch awsError.Code() {
-			// raised when the given course does not exist in the data store
-			case dynamodb.ErrCodeConditionalCheckFailedException:
-				return NotFoundError
-			default:
-				return UnknownError
-			}
-		}
-		return UnknownError
-	}
-	return nil
+// DeliveryRetention is the time for which the deliveries are kept. SQS keeps a message for 14 days at most, so a
+// notification cannot be read again after that time
+const DeliveryRetention = 14 * 24 * time.Hour
+
+// CourseRepository abstracts the data store containing the courses and their mailing lists.
+// Every implementation must return the ad hoc errors declared above, so that the callers can handle them
+// without knowing which backend is in use.
+type CourseRepository interface {
+	// CreateCourse adds a course with an empty mailing list. It returns ConflictError if the course already exists
+	CreateCourse(course entity.Course) error
+	// DeleteCourse removes a course and its mailing list. It returns NotFoundError if the course does not exist
+	DeleteCourse(course entity.Course) error
+	// AddStudent adds the mail, normalized by NormalizeMail, to the mailing list of the course. It returns
+	// NotFoundError if the course does not exist and ConflictMailError if the mail is already in the mailing list.
+	// Backends based on optimistic concurrency may return ConcurrentUpdateError, in which case the operation
+	// had no effect and can be retried
+	AddStudent(course entity.Course, studentMail string) error
+	// AddStudents subscribes many mails to the mailing list of the course, applying them in batches. The returned
+	// slice contains, for each mail, nil if it has been subscribed or the error that prevented its subscription
+	// (ConflictMailError for the mails already subscribed or repeated). The second return value is NotFoundError
+	// if the course does not exist
+	AddStudents(course entity.Course, studentMails []string) ([]error, error)
+	// RemoveStudent removes the mail from the mailing list of the course. It returns NotFoundError if the course
+	// does not exist. As AddStudent, it may return ConcurrentUpdateError
+	RemoveStudent(course entity.Course, studentMail string) error
+	// GetCourseMailingList returns the mailing list of the course. It returns NotFoundError if the course does
+	// not exist
+	GetCourseMailingList(course entity.Course) ([]string, error)
+	// ListCourses returns at most limit courses matching the filter. The courses are returned in pages: pageToken
+	// is empty for the first page and, for the following ones, it is the token returned together with the
+	// previous page. An empty token is returned with the last page. It returns InvalidPageTokenError if pageToken
+	// has not been produced by the same backend
+	ListCourses(filter CourseFilter, pageToken string, limit int) ([]entity.Course, string, error)
+	// GetStudentCourses returns the courses whose mailing list contains the mail. A student without subscriptions
+	// is not an error: an empty list is returned
+	GetStudentCourses(studentMail string) ([]entity.Course, error)
+	// RemoveStudentFromAllCourses removes the mail from every mailing list and returns the courses the student
+	// has been unsubscribed from. Backends that cannot update every course atomically may fail after removing
+	// some subscriptions: the operation can be repeated to complete it
+	RemoveStudentFromAllCourses(studentMail string) ([]entity.Course, error)
+	// SetCourseTemplate sets the name of the mail template used for the notifications of the course. An empty name
+	// removes the template of the course, that falls back to the one of its department. It returns NotFoundError if
+	// the course does not exist
+	SetCourseTemplate(course entity.Course, templateName string) error
+	// GetCourseTemplate returns the name of the mail template of the course, empty when the course has no template
+	// of its own. It returns NotFoundError if the course does not exist
+	GetCourseTemplate(course entity.Course) (string, error)
+	// SetCourseLanguage sets the default language of the course, used for the students without a locale or whose
+	// locale is not available in a notification. An empty language removes it. It returns NotFoundError if the course
+	// does not exist
+	SetCourseLanguage(course entity.Course, language string) error
+	// GetCourseLanguage returns the default language of the course, empty when it has not been set. It returns
+	// NotFoundError if the course does not exist
+	GetCourseLanguage(course entity.Course) (string, error)
+	// SetStudentLocale stores the preferred locale of the student, normalized by NormalizeLocale. The locale does not
+	// depend on the subscriptions of the student, and an empty locale removes it
+	SetStudentLocale(studentMail string, locale string) error
+	// GetStudentLocales returns the preferred locales of the given students, indexed by normalized mail. The students
+	// without a locale are not included
+	GetStudentLocales(studentMails []string) (map[string]string, error)
+	// AddDeliveredRecipients records that the notification with the given id has been sent to the students. The
+	// delivery is created by the first call and expires after DeliveryRetention
+	AddDeliveredRecipients(notificationId string, studentMails []string) error
+	// CompleteDelivery records that the notification with the given id has been sent to the whole mailing list
+	CompleteDelivery(notificationId string) error
+	// GetDelivery returns the progress of the sending of the notification with the given id. A notification that
+	// has never been sent is not an error: an empty Delivery is returned
+	GetDelivery(notificationId string) (Delivery, error)
 }
 
-func contains(list []string, elem string) bool {
-	for i := 0; i < len(list); i++ {
-		if list[i] == elem {
-			return true
+// Repository is the course data store used by the microservice. It is set up once at startup and, like the
+// AWS clients, it is safe to be used concurrently
+var Repository CourseRepository
+
+// Names of the course data store backends that can be selected in the configuration file
+const (
+	DynamoDbStore = "dynamodb"
+	MemoryStore   = "memory"
+	BoltStore     = "bolt"
+	SqlStore      = "sql"
+)
+
+// InitializeRepository sets up Repository using the backend named by config.Configuration.CourseStore.
+// When no backend is configured DynamoDB is used.
+func InitializeRepository() error {
+	switch config.Configuration.CourseStore {
+	case "", DynamoDbStore:
+		InitializeDynamoDbClient()
+	case MemoryStore:
+		Repository = NewMemoryRepository()
+	case BoltStore:
+		boltRepository, err := NewBoltRepository(config.Configuration.BoltDbPath)
+		if err != nil {
+			return err
+		}
+		Repository = boltRepository
+	case SqlStore:
+		sqlRepository, err := NewSqlRepository(config.Configuration.SqlDriver, config.Configuration.SqlDataSource)
+		if err != nil {
+			return err
 		}
+		Repository = sqlRepository
+	default:
+		return errors.New("unknown course store: " + config.Configuration.CourseStore)
 	}
-	return false
+	return nil
 }
 
-// Add the provided mail to the list of mail address associated to the given course. So the student will receive news
-// about the course. The function returns a not-nil value in case of error:
-// - NotFoundError when the caller try to update a not existent course
-// - UnknownError otherwise
-// - ConflictMail when the e-mail already exists in the mailing list
-func AddStudent(course entity.Course, studentMail string) error {
-
-	// Search for the provided course
-	getItemInput := &dynamodb.GetItemInput{
-		TableName: aws.String(config.Configuration.CoursesTableName),
-		Key: map[string]*dynamodb.AttributeValue{
-			"CourseName": {
-				S: aws.String(course.Name + "_" + course.Department + "_" + course.Year),
-			},
-		},
-	}
-	getResult, err := Client.GetItem(getItemInput)
-	if err != nil {
+// toRepositoryError converts the error returned by a transaction of an embedded or SQL backend in one of the ad hoc
+// errors of the package
+func toRepositoryError(err error) error {
+	switch err {
+	case nil, ConflictError, ConflictMailError, NotFoundError, ConcurrentUpdateError, InvalidPageTokenError:
+		return err
+	default:
 		log.Println(err)
 		return UnknownError
 	}
-	var matchingCourse CourseItem
-	err = dynamodbattribute.UnmarshalMap(getResult.Item, &matchingCourse)
-	if err != nil {
-		log.Println(err)
-		return UnknownError
-	}
-	if matchingCourse.CourseName == "" {
-		return NotFoundError
-	}
+}
 
-	// check if the given mail does not exist in the mailing list
-	if contains(matchingCourse.MailingList, studentMail) {
-		return ConflictMailError
-	}
+// NormalizeMail returns the form in which a mail is stored in the mailing lists. Mails are compared
+// case-insensitively, so Student@Uni.it and student@uni.it identify the same subscriber
+func NormalizeMail(mail string) string {
+	return strings.ToLower(strings.TrimSpace(mail))
+}
 
-	newMail := &dynamodb.AttributeValue{
-		S: aws.String(studentMail),
-	}
-	var mailList []*dynamodb.AttributeValue
-	// the mail address will be appended to the mailing list of the course.
-	// it must be embedded inside a slice
-	mailList = append(mailList, newMail)
+// NormalizeLocale returns the form in which a locale is stored, e.g. en-gb for en_GB
+func NormalizeLocale(locale string) string {
+	return strings.Replace(strings.ToLower(strings.TrimSpace(locale)), "_", "-", -1)
+}
+
+// matches tells if the course identified by the key satisfies the filter
+func (filter CourseFilter) matches(key CourseKey) bool {
+	return (filter.Department == "" || filter.Department == key.Department) &&
+		(filter.Year == "" || filter.Year == key.Year)
+}
+
+// encodePageToken builds the token of the page following the course stored with the given key. The token is opaque
+// for the callers, that just pass it back to ListCourses
+func encodePageToken(storedKey string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(storedKey))
+}
 
-	updateItemInput := &dynamodb.UpdateItemInput{
-		Key: map[string]*dynamodb.AttributeValue{
-			"CourseName": {S: aws.String(course.Name + "_" + course.Department + "_" + course.Year)},
-		},
-		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":mail": {
-				L: mailList,
-			},
-			":empty_list": {
-				// this must be provided for the first mail, when the list does not exist yet
-				L: []*dynamodb.AttributeValue{},
-			},
-		},
-		ConditionExpression: aws.String("attribute_exists(CourseName)"),
-		UpdateExpression:    aws.String("SET MailingList = list_append(if_not_exists(MailingList, :empty_list), :mail)"),
-		TableName:           aws.String(config.Configuration.CoursesTableName),
+// decodePageToken returns the stored key of the last course of the previous page
+func decodePageToken(pageToken string) (string, error) {
+	storedKey, err := base64.RawURLEncoding.DecodeString(pageToken)
+	if err != nil || len(storedKey) == 0 {
+		return "", InvalidPageTokenError
 	}
+	return string(storedKey), nil
+}
 
-	_, err = Client.UpdateItem(updateItemInput)
-	if err != nil {
-		log.Println(err)
-		log.Println(err)
-		// check if AWS DynamoDB raised an error
-		awsError, ok := err.(awserr.Error)
-		if ok {
-			switch awsError.Code() {
-			// raised when the given course does not exist in the data store
-			case dynamodb.ErrCodeConditionalCheckFailedException:
-				return NotFoundError
-			default:
-				return UnknownError
-			}
+func contains(list []string, elem string) bool {
+	for i := 0; i < len(list); i++ {
+		if list[i] == elem {
+			return true
 		}
-		return UnknownError
 	}
-
-	return nil
+	return false
 }
 
 func removeMailFromList(mail string, list []string) []string {
@@ -215,87 +222,3 @@ func removeMailFromList(mail string, list []string) []string {
 	}
 	return newSlice
 }
-
-// Remove the provided mail from the list of mail address associated to the given course.
-// The function returns a not-nil value in case of error:
-// - NotFoundError when the caller try to update a not existent course
-// - UnknownError otherwise
-func RemoveStudent(course entity.Course, studentMail string) error {
-	// Search for the provided course
-	getItemInput := &dynamodb.GetItemInput{
-		TableName: aws.String(config.Configuration.CoursesTableName),
-		Key: map[string]*dynamodb.AttributeValue{
-			"CourseName": {
-				S: aws.String(course.Name + "_" + course.Department + "_" + course.Year),
-			},
-		},
-	}
-	getResult, err := Client.GetItem(getItemInput)
-	if err != nil {
-		log.Println(err)
-		return UnknownError
-	}
-	var matchingCourse CourseItem
-	err = dynamodbattribute.UnmarshalMap(getResult.Item, &matchingCourse)
-	if err != nil {
-		log.Println(err)
-		return UnknownError
-	}
-	if matchingCourse.CourseName == "" {
-		return NotFoundError
-	}
-	// If the course exist, its mailing list is updated removing the given address
-	matchingCourse.MailingList = removeMailFromList(studentMail, matchingCourse.MailingList)
-	var marshaledCourse map[string]*dynamodb.AttributeValue
-	if len(matchingCourse.MailingList) == 0 {
-		// If the mailing list is empty, the corresponding attribute is removed to avoid problems concerning the type
-		updatedCourse := CourseCreationItem{CourseName: matchingCourse.CourseName}
-		marshaledCourse, err = dynamodbattribute.MarshalMap(updatedCourse)
-	} else {
-		marshaledCourse, err = dynamodbattribute.MarshalMap(matchingCourse)
-	}
-	if err != nil {
-		log.Println(err)
-		return UnknownError
-	}
-	putItemInput := &dynamodb.PutItemInput{
-		Item:      marshaledCourse,
-		TableName: aws.String(config.Configuration.CoursesTableName),
-	}
-	_, err = Client.PutItem(putItemInput)
-	if err != nil {
-		log.Println(err)
-		return UnknownError
-	}
-	return nil
-}
-
-// GetCourseMailingList returns the mailing list associated to the course.
-// On error, the second return value has a not-nil value:
-// NotFoundError if the provided course does not exists
-// UnknownError otherwise
-func GetCourseMailingList(course entity.Course) ([]string, error) {
-	getItemInput := &dynamodb.GetItemInput{
-		TableName: aws.String(config.Configuration.CoursesTableName),
-		Key: map[string]*dynamodb.AttributeValue{
-			"CourseName": {
-				S: aws.String(course.Name + "_" + course.Department + "_" + course.Year),
-			},
-		},
-	}
-	getResult, err := Client.GetItem(getItemInput)
-	if err != nil {
-		log.Println(err)
-		return nil, UnknownError
-	}
-	var matchingCourse CourseItem
-	err = dynamodbattribute.UnmarshalMap(getResult.Item, &matchingCourse)
-	if err != nil {
-		log.Println(err)
-		return nil, UnknownError
-	}
-	if matchingCourse.CourseName == "" {
-		return nil, NotFoundError
-	}
-	return matchingCourse.MailingList, nil
-}
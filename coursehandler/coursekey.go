@@ -0,0 +1,89 @@
+package coursehandler
+
+import (
+	"errors"
+	"github.com/redefik/notificationmanagement/entity"
+	"net/url"
+	"strings"
+)
+
+/*Identity of a course inside the data store*/
+
+// Separator of the components of an encoded CourseKey. The character is escaped inside the components, so the
+// encoding is unambiguous whatever the content of name, department and year
+const courseKeySeparator = "#"
+
+// InvalidCourseKeyError is returned when a string cannot be decoded as a CourseKey
+var InvalidCourseKeyError = errors.New("the string is not a valid course key")
+
+// CourseKey identifies a course: two courses with the same name, department and year are the same course
+type CourseKey struct {
+	Name       string
+	Department string
+	Year       string
+}
+
+// NewCourseKey returns the key identifying the given course
+func NewCourseKey(course entity.Course) CourseKey {
+	return CourseKey{Name: course.Name, Department: course.Department, Year: course.Year}
+}
+
+// Course returns the course identified by the key
+func (key CourseKey) Course() entity.Course {
+	return entity.Course{Name: key.Name, Department: key.Department, Year: key.Year}
+}
+
+// escapeKeyComponent percent-encodes the characters that have a special meaning in an encoded CourseKey
+func escapeKeyComponent(component string) string {
+	return strings.NewReplacer("%", "%25", courseKeySeparator, "%23").Replace(component)
+}
+
+// Encode returns the string representation of the key, used as partition key of the DynamoDB items and as key of
+// the BoltDB entries. The components are escaped and joined by courseKeySeparator (e.g. "Calculus#Science#2018-2019")
+func (key CourseKey) Encode() string {
+	return escapeKeyComponent(key.Name) + courseKeySeparator +
+		escapeKeyComponent(key.Department) + courseKeySeparator +
+		escapeKeyComponent(key.Year)
+}
+
+// ParseCourseKey decodes a string produced by CourseKey.Encode. It returns InvalidCourseKeyError if the string is
+// not a valid encoding
+func ParseCourseKey(encodedKey string) (CourseKey, error) {
+	components := strings.Split(encodedKey, courseKeySeparator)
+	if len(components) != 3 {
+		return CourseKey{}, InvalidCourseKeyError
+	}
+	for i := range components {
+		component, err := url.PathUnescape(components[i])
+		if err != nil {
+			return CourseKey{}, InvalidCourseKeyError
+		}
+		components[i] = component
+	}
+	return CourseKey{Name: components[0], Department: components[1], Year: components[2]}, nil
+}
+
+// parseLegacyCourseKey decodes the keys written before the introduction of CourseKey, built concatenating name,
+// department and year separated by underscores. Such keys can be decoded only because the REST interface never
+// accepted underscores in the fields of a course
+func parseLegacyCourseKey(legacyKey string) (CourseKey, error) {
+	components := strings.Split(legacyKey, "_")
+	if len(components) != 3 {
+		return CourseKey{}, InvalidCourseKeyError
+	}
+	return CourseKey{Name: components[0], Department: components[1], Year: components[2]}, nil
+}
+
+// isLegacyCourseKey tells if the stored key has been written with the legacy format
+func isLegacyCourseKey(storedKey string) bool {
+	return !strings.Contains(storedKey, courseKeySeparator)
+}
+
+// parseStoredCourseKey decodes a key read from the data store, that can be either in the current or in the legacy
+// format
+func parseStoredCourseKey(storedKey string) (CourseKey, error) {
+	if isLegacyCourseKey(storedKey) {
+		return parseLegacyCourseKey(storedKey)
+	}
+	return ParseCourseKey(storedKey)
+}
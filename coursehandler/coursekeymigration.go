@@ -0,0 +1,78 @@
+package coursehandler
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"log"
+	"strconv"
+)
+
+/*One-shot migration of the DynamoDB course items from the legacy "Name_Department_Year" partition key to the
+encoded CourseKey, with name, department and year stored as separate attributes*/
+
+// migrateLegacyCourseItem replaces the item having a legacy key with an equivalent item identified by the encoded
+// CourseKey. The insertion of the new item and the deletion of the old one are made in a single transaction,
+// that fails if the old item has been modified after being read or if the new item already exists
+func (repository *DynamoDbRepository) migrateLegacyCourseItem(legacyItem CourseItem) error {
+	key, err := parseLegacyCourseKey(legacyItem.CourseName)
+	if err != nil {
+		return err
+	}
+	migratedItem := legacyItem
+	migratedItem.CourseName = key.Encode()
+	migratedItem.Name = key.Name
+	migratedItem.Department = key.Department
+	migratedItem.Year = key.Year
+	marshaledItem, err := dynamodbattribute.MarshalMap(migratedItem)
+	if err != nil {
+		return err
+	}
+	transactWriteItemsInput := &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Put: &dynamodb.Put{
+					TableName:           aws.String(repository.tableName),
+					Item:                marshaledItem,
+					ConditionExpression: aws.String("attribute_not_exists(CourseName)"),
+				},
+			},
+			{
+				Delete: &dynamodb.Delete{
+					TableName: aws.String(repository.tableName),
+					Key: map[string]*dynamodb.AttributeValue{
+						"CourseName": {S: aws.String(legacyItem.CourseName)},
+					},
+					ConditionExpression: aws.String("attribute_not_exists(Version) OR Version = :version"),
+					ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+						":version": {N: aws.String(strconv.FormatInt(legacyItem.Version, 10))},
+					},
+				},
+			},
+		},
+	}
+	_, err = repository.client.TransactWriteItems(transactWriteItemsInput)
+	return err
+}
+
+// MigrateCourseKeys converts all the course items having a legacy partition key. The items already converted are
+// skipped, so the migration can be run more than once (e.g. after an interruption). A failure on an item is logged
+// and the migration goes on with the other items. The function returns the number of migrated and failed items
+func MigrateCourseKeys(repository *DynamoDbRepository) (int, int, error) {
+	migratedCourses := 0
+	failedCourses := 0
+	err := repository.scanCourseItems(func(courseItem CourseItem) error {
+		if !isLegacyCourseKey(courseItem.CourseName) {
+			return nil
+		}
+		err := repository.migrateLegacyCourseItem(courseItem)
+		if err != nil {
+			log.Println("cannot migrate course", courseItem.CourseName, err)
+			failedCourses++
+			return nil
+		}
+		migratedCourses++
+		return nil
+	})
+	return migratedCourses, failedCourses, err
+}
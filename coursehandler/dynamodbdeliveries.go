@@ -0,0 +1,125 @@
+package coursehandler
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"log"
+	"strconv"
+	"time"
+)
+
+/*Deliveries of the notifications on DynamoDB. The partition key of the deliveries table is the id of the notification
+and the sort key identifies an entry of the delivery: each call of AddDeliveredRecipients writes new entries
+containing the recipients, so a large mailing list never has to be rewritten as a single item, and CompleteDelivery
+writes the completion entry. The entries are removed by the time to live of the table, that must be enabled on the
+ExpiresAt attribute*/
+
+// Sort key of the entry written by CompleteDelivery
+const completedDeliveryEntry = "completed"
+
+// Maximum number of recipients stored in an entry, that keeps it well below the limit on the size of an item
+const maxDeliveryEntryRecipients = 1000
+
+// Encapsulates the fields of the DynamoDB item representing an entry of the delivery of a notification
+type DeliveryItem struct {
+	NotificationId string   // partition key
+	Entry          string   // sort key, completedDeliveryEntry or the id of a group of recipients
+	Recipients     []string `dynamodbav:",stringset,omitempty"`
+	ExpiresAt      int64    // expiration time in seconds since the epoch, read by the time to live of the table
+}
+
+// putDeliveryItems writes the items to the deliveries table
+func (repository *DynamoDbRepository) putDeliveryItems(deliveryItems []DeliveryItem) error {
+	writeRequests := make([]*dynamodb.WriteRequest, 0, len(deliveryItems))
+	for _, deliveryItem := range deliveryItems {
+		marshaledItem, err := dynamodbattribute.MarshalMap(deliveryItem)
+		if err != nil {
+			log.Println(err)
+			return UnknownError
+		}
+		writeRequests = append(writeRequests, &dynamodb.WriteRequest{
+			PutRequest: &dynamodb.PutRequest{Item: marshaledItem},
+		})
+	}
+	err := repository.batchWrite(repository.deliveriesTableName, writeRequests)
+	if err != nil {
+		log.Println(err)
+		return UnknownError
+	}
+	return nil
+}
+
+// AddDeliveredRecipients writes the recipients in entries of maxDeliveryEntryRecipients mails. It returns UnknownError
+// if DynamoDB cannot be updated
+func (repository *DynamoDbRepository) AddDeliveredRecipients(notificationId string, studentMails []string) error {
+	now := time.Now()
+	entryPrefix := strconv.FormatInt(now.UnixNano(), 10) + "-"
+	var deliveryItems []DeliveryItem
+	for start := 0; start < len(studentMails); start += maxDeliveryEntryRecipients {
+		end := start + maxDeliveryEntryRecipients
+		if end > len(studentMails) {
+			end = len(studentMails)
+		}
+		recipients := make([]string, 0, end-start)
+		for _, studentMail := range studentMails[start:end] {
+			recipients = append(recipients, NormalizeMail(studentMail))
+		}
+		deliveryItems = append(deliveryItems, DeliveryItem{
+			NotificationId: notificationId,
+			Entry:          entryPrefix + strconv.Itoa(start),
+			Recipients:     recipients,
+			ExpiresAt:      now.Add(DeliveryRetention).Unix(),
+		})
+	}
+	return repository.putDeliveryItems(deliveryItems)
+}
+
+// CompleteDelivery writes the completion entry of the delivery. It returns UnknownError if DynamoDB cannot be updated
+func (repository *DynamoDbRepository) CompleteDelivery(notificationId string) error {
+	return repository.putDeliveryItems([]DeliveryItem{{
+		NotificationId: notificationId,
+		Entry:          completedDeliveryEntry,
+		ExpiresAt:      time.Now().Add(DeliveryRetention).Unix(),
+	}})
+}
+
+// GetDelivery reads every entry of the delivery with a consistent query, so the entries written just before by
+// another consumer are not missed. It returns UnknownError if DynamoDB cannot be queried
+func (repository *DynamoDbRepository) GetDelivery(notificationId string) (Delivery, error) {
+	var delivery Delivery
+	delivered := make(map[string]bool)
+	var unmarshalErr error
+	queryInput := &dynamodb.QueryInput{
+		TableName:              aws.String(repository.deliveriesTableName),
+		ConsistentRead:         aws.Bool(true),
+		KeyConditionExpression: aws.String("NotificationId = :id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":id": {S: aws.String(notificationId)},
+		},
+	}
+	err := repository.client.QueryPages(queryInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		var deliveryItems []DeliveryItem
+		unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &deliveryItems)
+		for _, deliveryItem := range deliveryItems {
+			if deliveryItem.Entry == completedDeliveryEntry {
+				delivery.Completed = true
+			}
+			for _, recipient := range deliveryItem.Recipients {
+				if !delivered[recipient] {
+					delivered[recipient] = true
+					delivery.Recipients = append(delivery.Recipients, recipient)
+				}
+			}
+		}
+		return unmarshalErr == nil
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+	if err != nil {
+		log.Println(err)
+		return Delivery{}, UnknownError
+	}
+	return delivery, nil
+}
@@ -0,0 +1,580 @@
+package coursehandler
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/redefik/notificationmanagement/config"
+	"github.com/redefik/notificationmanagement/entity"
+	"log"
+	"strings"
+)
+
+/*DynamoDB implementation of CourseRepository*/
+
+var Client *dynamodb.DynamoDB
+
+// Encapsulates the fields of the DynamoDB item representing a course. The mailing list is stored as a string set of
+// normalized mails: an empty set cannot be stored in DynamoDB, so the attribute is omitted when the list is empty
+type CourseItem struct {
+	CourseName  string   // partition key, it is the encoded CourseKey of the course
+	Name        string   `dynamodbav:",omitempty"`
+	Department  string   `dynamodbav:",omitempty"`
+	Year        string   `dynamodbav:",omitempty"`
+	MailingList []string `dynamodbav:",stringset,omitempty"`
+	Version     int64    `dynamodbav:",omitempty"` // incremented by every update of the mailing list
+	Template    string   `dynamodbav:",omitempty"` // mail template of the course, omitted when the department one is used
+	Language    string   `dynamodbav:",omitempty"` // default language of the notifications of the course
+}
+
+// DynamoDbRepository stores each course as an item of a DynamoDB table. The partition key of the table is
+// CourseName, that contains the encoded CourseKey, while name, department and year are also stored as separate
+// attributes. The mailing list is kept in the MailingList string set attribute of the item.
+// Each subscription is also stored in a second table, keyed by the mail of the student, that is used to find the
+// courses of a student without scanning the courses table (see dynamodbsubscriptions.go)
+// The preferred locales of the students are stored in a third table, keyed by the mail of the student (see
+// dynamodbstudents.go), while the deliveries of the notifications are stored in a fourth table (see
+// dynamodbdeliveries.go)
+type DynamoDbRepository struct {
+	client                 *dynamodb.DynamoDB
+	tableName              string
+	subscriptionsTableName string
+	studentsTableName      string
+	deliveriesTableName    string
+}
+
+// NewDynamoDbRepository returns a CourseRepository that uses the provided client to access the given courses,
+// subscriptions, students and deliveries tables
+func NewDynamoDbRepository(client *dynamodb.DynamoDB, tableName string, subscriptionsTableName string, studentsTableName string,
+	deliveriesTableName string) *DynamoDbRepository {
+	return &DynamoDbRepository{client: client, tableName: tableName, subscriptionsTableName: subscriptionsTableName,
+		studentsTableName: studentsTableName, deliveriesTableName: deliveriesTableName}
+}
+
+// InitializeDynamoDbClient instantiate a DynamoDB client that will be used to make API requests to DynamoDB. The initialization
+// is performed once because, as reported in the documentation, the client is safe to be used concurrently.
+// The client is then used to set up the DynamoDB course Repository
+func InitializeDynamoDbClient() {
+	sessionInitializer := session.Must(session.NewSession(&aws.Config{
+		Region: aws.String(config.Configuration.AwsDynamoDbRegion),
+	}))
+	//sessionInitializer := session.Must(session.NewSessionWithOptions(session.Options{
+	//	SharedConfigState: session.SharedConfigEnable,
+	//}))
+	Client = dynamodb.New(sessionInitializer)
+	Repository = NewDynamoDbRepository(Client, config.Configuration.CoursesTableName,
+		config.Configuration.SubscriptionsTableName, config.Configuration.StudentsTableName,
+		config.Configuration.DeliveriesTableName)
+}
+
+// Add a course to the data store returning a not-nil value in case of error:
+// - ConflictError it the caller try to create an existent course
+// - UnknownError otherwise
+func (repository *DynamoDbRepository) CreateCourse(course entity.Course) error {
+	// Convert the course in the format read by dynamodb
+	courseItem := CourseItem{
+		CourseName: NewCourseKey(course).Encode(),
+		Name:       course.Name,
+		Department: course.Department,
+		Year:       course.Year,
+	}
+	marshaledCourse, err := dynamodbattribute.MarshalMap(courseItem)
+	if err != nil {
+		log.Println(err)
+		return UnknownError
+	}
+	// Build the request for DynamoDB
+	putItemInput := &dynamodb.PutItemInput{
+		Item:                marshaledCourse,
+		ConditionExpression: aws.String("attribute_not_exists(CourseName)"),
+		TableName:           aws.String(repository.tableName),
+	}
+	_, err = repository.client.PutItem(putItemInput)
+	if err != nil {
+		log.Println(err)
+		// check if AWS DynamoDB raised an error
+		awsError, ok := err.(awserr.Error)
+		if ok {
+			switch awsError.Code() {
+			// raised when the client try to create a course that already exists
+			case dynamodb.ErrCodeConditionalCheckFailedException:
+				return ConflictError
+			default:
+				return UnknownError
+			}
+		}
+		return UnknownError
+	}
+	return nil
+}
+
+// Delete the given course from the data store returning a not-nil value in case of error:
+// - NotFoundError when the caller try to delete a not existent course
+// - UnknownError otherwise
+// The subscriptions of the deleted course are then removed from the subscriptions table
+func (repository *DynamoDbRepository) DeleteCourse(course entity.Course) error {
+	deleteItemInput := &dynamodb.DeleteItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"CourseName": {
+				S: aws.String(NewCourseKey(course).Encode()),
+			},
+		},
+		ConditionExpression: aws.String("attribute_exists(CourseName)"),
+		// the deleted item is returned to know the subscriptions to be removed
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
+		TableName:    aws.String(repository.tableName),
+	}
+	deleteItemOutput, err := repository.client.DeleteItem(deleteItemInput)
+	if err != nil {
+		log.Println(err)
+		// check if AWS DynamoDB raised an error
+		awsError, ok := err.(awserr.Error)
+		if ok {
+			switch awsError.Code() {
+			// raised when the given course does not exist in the data store
+			case dynamodb.ErrCodeConditionalCheckFailedException:
+				return NotFoundError
+			default:
+				return UnknownError
+			}
+		}
+		return UnknownError
+	}
+	var deletedCourse CourseItem
+	err = dynamodbattribute.UnmarshalMap(deleteItemOutput.Attributes, &deletedCourse)
+	if err == nil {
+		err = repository.deleteSubscriptions(deletedCourse.CourseName, deletedCourse.MailingList)
+	}
+	if err != nil {
+		// The course has been deleted anyway: the subscriptions left behind are removed by
+		// RemoveStudentFromAllCourses or by the backfill of the subscriptions table
+		log.Println("subscriptions of the deleted course", deletedCourse.CourseName, "not removed:", err)
+	}
+	return nil
+}
+
+// getCourseItem reads the item representing the given course. It returns NotFoundError if the item does not exist
+// and UnknownError if DynamoDB cannot be queried
+func (repository *DynamoDbRepository) getCourseItem(course entity.Course) (CourseItem, error) {
+	return repository.getCourseItemByKey(NewCourseKey(course).Encode())
+}
+
+// getCourseItemByKey is as getCourseItem, but the item is identified by the value of its partition key
+func (repository *DynamoDbRepository) getCourseItemByKey(key string) (CourseItem, error) {
+	var matchingCourse CourseItem
+	getItemInput := &dynamodb.GetItemInput{
+		TableName: aws.String(repository.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"CourseName": {
+				S: aws.String(key),
+			},
+		},
+	}
+	getResult, err := repository.client.GetItem(getItemInput)
+	if err != nil {
+		log.Println(err)
+		return matchingCourse, UnknownError
+	}
+	err = dynamodbattribute.UnmarshalMap(getResult.Item, &matchingCourse)
+	if err != nil {
+		log.Println(err)
+		return matchingCourse, UnknownError
+	}
+	if matchingCourse.CourseName == "" {
+		return matchingCourse, NotFoundError
+	}
+	return matchingCourse, nil
+}
+
+// Add the provided mail to the set of mail address associated to the given course. So the student will receive news
+// about the course. The mail is normalized and added to the MailingList string set by a conditional update, so
+// concurrent subscriptions cannot be lost. The update and the insertion of the subscription in the subscriptions
+// table are performed in a single transaction. The function returns a not-nil value in case of error:
+// - NotFoundError when the caller try to update a not existent course
+// - ConflictMail when the e-mail already exists in the mailing list
+// - ConcurrentUpdateError when the transaction conflicts with another update of the course
+// - UnknownError otherwise
+func (repository *DynamoDbRepository) AddStudent(course entity.Course, studentMail string) error {
+	studentMail = NormalizeMail(studentMail)
+	key := NewCourseKey(course).Encode()
+	subscriptionItem, err := dynamodbattribute.MarshalMap(newSubscriptionItem(studentMail, key, course))
+	if err != nil {
+		log.Println(err)
+		return UnknownError
+	}
+	transactWriteItemsInput := &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Update: &dynamodb.Update{
+					Key: map[string]*dynamodb.AttributeValue{
+						"CourseName": {S: aws.String(key)},
+					},
+					ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+						":mail":     {S: aws.String(studentMail)},
+						":mail_set": {SS: []*string{aws.String(studentMail)}},
+						":one":      {N: aws.String("1")},
+					},
+					// When the set does not exist yet, contains evaluates to false and ADD creates it
+					ConditionExpression: aws.String("attribute_exists(CourseName) AND NOT contains(MailingList, :mail)"),
+					UpdateExpression:    aws.String("ADD MailingList :mail_set, Version :one"),
+					TableName:           aws.String(repository.tableName),
+				},
+			},
+			{
+				Put: &dynamodb.Put{
+					Item:      subscriptionItem,
+					TableName: aws.String(repository.subscriptionsTableName),
+				},
+			},
+		},
+	}
+	_, err = repository.client.TransactWriteItems(transactWriteItemsInput)
+	if err != nil {
+		log.Println(err)
+		switch transactionFailureCause(err) {
+		// the given course does not exist or the mail is already in the mailing list
+		case transactionConditionFailed:
+			// the course is read to tell the two cases apart
+			_, err = repository.getCourseItem(course)
+			if err != nil {
+				return err
+			}
+			return ConflictMailError
+		case transactionConflict:
+			return ConcurrentUpdateError
+		default:
+			return UnknownError
+		}
+	}
+	return nil
+}
+
+// Maximum number of subscriptions written by a single transaction of AddStudents: together with the update of the
+// course they fill the 25 items allowed in a DynamoDB transaction
+const maxTransactionSubscriptions = 24
+
+// AddStudents subscribes the mails to the course in batches of maxTransactionSubscriptions. Each batch is a
+// transaction that adds the mails to the MailingList string set and writes their subscriptions, so a failed batch
+// has no effect and its mails can be imported again. The mails found in the mailing list read before the first
+// batch are reported as ConflictMailError
+func (repository *DynamoDbRepository) AddStudents(course entity.Course, studentMails []string) ([]error, error) {
+	courseItem, err := repository.getCourseItem(course)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]error, len(studentMails))
+	normalizedMails := make([]string, len(studentMails))
+	// indexes of the mails that are not yet subscribed
+	var pendingMails []int
+	var pendingMailSet []string
+	for i, studentMail := range studentMails {
+		normalizedMails[i] = NormalizeMail(studentMail)
+		if contains(courseItem.MailingList, normalizedMails[i]) || contains(pendingMailSet, normalizedMails[i]) {
+			results[i] = ConflictMailError
+			continue
+		}
+		pendingMails = append(pendingMails, i)
+		pendingMailSet = append(pendingMailSet, normalizedMails[i])
+	}
+	for start := 0; start < len(pendingMails); start += maxTransactionSubscriptions {
+		end := start + maxTransactionSubscriptions
+		if end > len(pendingMails) {
+			end = len(pendingMails)
+		}
+		batch := make([]string, 0, end-start)
+		for _, i := range pendingMails[start:end] {
+			batch = append(batch, normalizedMails[i])
+		}
+		err = repository.addStudentsBatch(course, courseItem.CourseName, batch)
+		for _, i := range pendingMails[start:end] {
+			results[i] = err
+		}
+	}
+	return results, nil
+}
+
+// addStudentsBatch adds the mails to the mailing list of the course with the given key and writes their
+// subscriptions in a single transaction. It returns NotFoundError if the course has been deleted,
+// ConcurrentUpdateError if the transaction conflicts with another update and UnknownError otherwise
+func (repository *DynamoDbRepository) addStudentsBatch(course entity.Course, key string, studentMails []string) error {
+	mailSet := make([]*string, 0, len(studentMails))
+	transactItems := make([]*dynamodb.TransactWriteItem, 0, len(studentMails)+1)
+	for _, studentMail := range studentMails {
+		mailSet = append(mailSet, aws.String(studentMail))
+		subscriptionItem, err := dynamodbattribute.MarshalMap(newSubscriptionItem(studentMail, key, course))
+		if err != nil {
+			log.Println(err)
+			return UnknownError
+		}
+		transactItems = append(transactItems, &dynamodb.TransactWriteItem{
+			Put: &dynamodb.Put{
+				Item:      subscriptionItem,
+				TableName: aws.String(repository.subscriptionsTableName),
+			},
+		})
+	}
+	transactItems = append(transactItems, &dynamodb.TransactWriteItem{
+		Update: &dynamodb.Update{
+			Key: map[string]*dynamodb.AttributeValue{
+				"CourseName": {S: aws.String(key)},
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":mail_set": {SS: mailSet},
+				":one":      {N: aws.String("1")},
+			},
+			// a mail subscribed concurrently is already in the set, where ADD leaves it unchanged
+			ConditionExpression: aws.String("attribute_exists(CourseName)"),
+			UpdateExpression:    aws.String("ADD MailingList :mail_set, Version :one"),
+			TableName:           aws.String(repository.tableName),
+		},
+	})
+	_, err := repository.client.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: transactItems})
+	if err != nil {
+		log.Println(err)
+		switch transactionFailureCause(err) {
+		case transactionConditionFailed:
+			return NotFoundError
+		case transactionConflict:
+			return ConcurrentUpdateError
+		default:
+			return UnknownError
+		}
+	}
+	return nil
+}
+
+// Remove the provided mail from the set of mail address associated to the given course. The subscription is removed
+// from the subscriptions table in the same transaction.
+// The function returns a not-nil value in case of error:
+// - NotFoundError when the caller try to update a not existent course
+// - ConcurrentUpdateError when the transaction conflicts with another update of the course
+// - UnknownError otherwise
+func (repository *DynamoDbRepository) RemoveStudent(course entity.Course, studentMail string) error {
+	studentMail = NormalizeMail(studentMail)
+	key := NewCourseKey(course).Encode()
+	transactWriteItemsInput := &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Update: &dynamodb.Update{
+					Key: map[string]*dynamodb.AttributeValue{
+						"CourseName": {S: aws.String(key)},
+					},
+					ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+						":mail_set": {SS: []*string{aws.String(studentMail)}},
+						":one":      {N: aws.String("1")},
+					},
+					// DynamoDB removes the attribute when the last element of the set is deleted
+					ConditionExpression: aws.String("attribute_exists(CourseName)"),
+					UpdateExpression:    aws.String("DELETE MailingList :mail_set ADD Version :one"),
+					TableName:           aws.String(repository.tableName),
+				},
+			},
+			{
+				Delete: &dynamodb.Delete{
+					Key:       subscriptionKey(studentMail, key),
+					TableName: aws.String(repository.subscriptionsTableName),
+				},
+			},
+		},
+	}
+	_, err := repository.client.TransactWriteItems(transactWriteItemsInput)
+	if err != nil {
+		log.Println(err)
+		switch transactionFailureCause(err) {
+		// the given course does not exist in the data store
+		case transactionConditionFailed:
+			return NotFoundError
+		case transactionConflict:
+			return ConcurrentUpdateError
+		default:
+			return UnknownError
+		}
+	}
+	return nil
+}
+
+// GetCourseMailingList returns the mailing list associated to the course.
+// On error, the second return value has a not-nil value:
+// NotFoundError if the provided course does not exists
+// UnknownError otherwise
+func (repository *DynamoDbRepository) GetCourseMailingList(course entity.Course) ([]string, error) {
+	matchingCourse, err := repository.getCourseItem(course)
+	if err != nil {
+		return nil, err
+	}
+	return matchingCourse.MailingList, nil
+}
+
+// scanCourseItems reads every item of the table, page by page, and passes it to the provided function.
+// The scan stops at the first error returned by the function
+func (repository *DynamoDbRepository) scanCourseItems(handleItem func(CourseItem) error) error {
+	var handleErr error
+	scanInput := &dynamodb.ScanInput{
+		TableName: aws.String(repository.tableName),
+	}
+	err := repository.client.ScanPages(scanInput, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		var courseItems []CourseItem
+		handleErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &courseItems)
+		if handleErr != nil {
+			return false
+		}
+		for _, courseItem := range courseItems {
+			handleErr = handleItem(courseItem)
+			if handleErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return handleErr
+}
+
+// ListCourses scans the table returning the courses matching the filter. Since DynamoDB applies the filter after
+// reading a page, more scans may be needed to fill a page of courses. The page token is the partition key of the last
+// returned item, that is used as the exclusive start key of the next scan
+func (repository *DynamoDbRepository) ListCourses(filter CourseFilter, pageToken string, limit int) ([]entity.Course, string, error) {
+	scanInput := &dynamodb.ScanInput{
+		TableName: aws.String(repository.tableName),
+		// Name and Year are reserved words of DynamoDB
+		ProjectionExpression: aws.String("CourseName, #name, Department, #year"),
+		ExpressionAttributeNames: map[string]*string{
+			"#name": aws.String("Name"),
+			"#year": aws.String("Year"),
+		},
+	}
+	var conditions []string
+	expressionAttributeValues := map[string]*dynamodb.AttributeValue{}
+	if filter.Department != "" {
+		conditions = append(conditions, "Department = :department")
+		expressionAttributeValues[":department"] = &dynamodb.AttributeValue{S: aws.String(filter.Department)}
+	}
+	if filter.Year != "" {
+		conditions = append(conditions, "#year = :year")
+		expressionAttributeValues[":year"] = &dynamodb.AttributeValue{S: aws.String(filter.Year)}
+	}
+	if len(conditions) > 0 {
+		scanInput.FilterExpression = aws.String(strings.Join(conditions, " AND "))
+		scanInput.ExpressionAttributeValues = expressionAttributeValues
+	}
+	if pageToken != "" {
+		lastKey, err := decodePageToken(pageToken)
+		if err != nil {
+			return nil, "", err
+		}
+		scanInput.ExclusiveStartKey = map[string]*dynamodb.AttributeValue{
+			"CourseName": {S: aws.String(lastKey)},
+		}
+	}
+	courses := make([]entity.Course, 0, limit)
+	lastKey := ""
+	for {
+		scanInput.Limit = aws.Int64(int64(limit - len(courses)))
+		scanOutput, err := repository.client.Scan(scanInput)
+		if err != nil {
+			log.Println(err)
+			// check if AWS DynamoDB raised an error
+			awsError, ok := err.(awserr.Error)
+			if ok && awsError.Code() == "ValidationException" && pageToken != "" {
+				// raised when the exclusive start key is not a valid key of the table
+				return nil, "", InvalidPageTokenError
+			}
+			return nil, "", UnknownError
+		}
+		var courseItems []CourseItem
+		err = dynamodbattribute.UnmarshalListOfMaps(scanOutput.Items, &courseItems)
+		if err != nil {
+			log.Println(err)
+			return nil, "", UnknownError
+		}
+		for _, courseItem := range courseItems {
+			courses = append(courses, entity.Course{
+				Name:       courseItem.Name,
+				Department: courseItem.Department,
+				Year:       courseItem.Year,
+			})
+			lastKey = courseItem.CourseName
+		}
+		if len(scanOutput.LastEvaluatedKey) == 0 {
+			// the whole table has been read
+			return courses, "", nil
+		}
+		if len(courses) == limit {
+			return courses, encodePageToken(lastKey), nil
+		}
+		scanInput.ExclusiveStartKey = scanOutput.LastEvaluatedKey
+	}
+}
+
+// SetCourseTemplate stores the name of the mail template in the Template attribute of the course item, removing the
+// attribute when the name is empty. The update does not touch the mailing list, so it does not increment Version.
+// It returns NotFoundError if the course does not exist and UnknownError otherwise
+func (repository *DynamoDbRepository) SetCourseTemplate(course entity.Course, templateName string) error {
+	return repository.setCourseAttribute(course, "Template", templateName)
+}
+
+// setCourseAttribute sets a string attribute of the course item, removing it when the value is empty. It returns
+// NotFoundError if the course does not exist and UnknownError otherwise
+func (repository *DynamoDbRepository) setCourseAttribute(course entity.Course, attribute string, value string) error {
+	updateItemInput := &dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"CourseName": {S: aws.String(NewCourseKey(course).Encode())},
+		},
+		ConditionExpression:      aws.String("attribute_exists(CourseName)"),
+		UpdateExpression:         aws.String("REMOVE #attribute"),
+		ExpressionAttributeNames: map[string]*string{"#attribute": aws.String(attribute)},
+		TableName:                aws.String(repository.tableName),
+	}
+	if value != "" {
+		updateItemInput.UpdateExpression = aws.String("SET #attribute = :value")
+		updateItemInput.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":value": {S: aws.String(value)},
+		}
+	}
+	_, err := repository.client.UpdateItem(updateItemInput)
+	if err != nil {
+		log.Println(err)
+		// check if AWS DynamoDB raised an error
+		awsError, ok := err.(awserr.Error)
+		if ok && awsError.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			// raised when the given course does not exist in the data store
+			return NotFoundError
+		}
+		return UnknownError
+	}
+	return nil
+}
+
+// GetCourseTemplate returns the name of the mail template of the course.
+// On error, the second return value has a not-nil value:
+// NotFoundError if the provided course does not exists
+// UnknownError otherwise
+func (repository *DynamoDbRepository) GetCourseTemplate(course entity.Course) (string, error) {
+	matchingCourse, err := repository.getCourseItem(course)
+	if err != nil {
+		return "", err
+	}
+	return matchingCourse.Template, nil
+}
+
+// SetCourseLanguage stores the default language in the Language attribute of the course item, removing the attribute
+// when the language is empty. It returns NotFoundError if the course does not exist and UnknownError otherwise
+func (repository *DynamoDbRepository) SetCourseLanguage(course entity.Course, language string) error {
+	return repository.setCourseAttribute(course, "Language", language)
+}
+
+// GetCourseLanguage returns the default language of the course.
+// On error, the second return value has a not-nil value:
+// NotFoundError if the provided course does not exists
+// UnknownError otherwise
+func (repository *DynamoDbRepository) GetCourseLanguage(course entity.Course) (string, error) {
+	matchingCourse, err := repository.getCourseItem(course)
+	if err != nil {
+		return "", err
+	}
+	return matchingCourse.Language, nil
+}
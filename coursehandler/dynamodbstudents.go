@@ -0,0 +1,133 @@
+package coursehandler
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"log"
+	"time"
+)
+
+/*Preferred locales of the students on DynamoDB. The locale belongs to the student rather than to a subscription, so it
+is stored in a table whose partition key is the mail of the student. The items are read in batches when a
+notification is sent to a mailing list*/
+
+// Maximum number of keys accepted by a single BatchGetItem and number of attempts made to read the keys left
+// unprocessed by DynamoDB
+const maxBatchGetItems = 100
+const maxBatchGetAttempts = 5
+
+// Encapsulates the fields of the DynamoDB item containing the preferences of a student
+type StudentItem struct {
+	StudentMail string // partition key, it is the normalized mail of the student
+	Locale      string
+}
+
+// SetStudentLocale stores the preferred locale of the student, deleting the item when the locale is empty.
+// It returns UnknownError if DynamoDB cannot be updated
+func (repository *DynamoDbRepository) SetStudentLocale(studentMail string, locale string) error {
+	studentItem := StudentItem{StudentMail: NormalizeMail(studentMail), Locale: NormalizeLocale(locale)}
+	var err error
+	if studentItem.Locale == "" {
+		_, err = repository.client.DeleteItem(&dynamodb.DeleteItemInput{
+			Key: map[string]*dynamodb.AttributeValue{
+				"StudentMail": {S: aws.String(studentItem.StudentMail)},
+			},
+			TableName: aws.String(repository.studentsTableName),
+		})
+	} else {
+		var marshaledStudent map[string]*dynamodb.AttributeValue
+		marshaledStudent, err = dynamodbattribute.MarshalMap(studentItem)
+		if err == nil {
+			_, err = repository.client.PutItem(&dynamodb.PutItemInput{
+				Item:      marshaledStudent,
+				TableName: aws.String(repository.studentsTableName),
+			})
+		}
+	}
+	if err != nil {
+		log.Println(err)
+		return UnknownError
+	}
+	return nil
+}
+
+// GetStudentLocales reads the items of the given students in batches of maxBatchGetItems keys. The keys left
+// unprocessed because of the throughput limits are requested again, up to maxBatchGetAttempts times
+func (repository *DynamoDbRepository) GetStudentLocales(studentMails []string) (map[string]string, error) {
+	locales := make(map[string]string)
+	// DynamoDB rejects a batch containing the same key twice
+	var keys []map[string]*dynamodb.AttributeValue
+	requestedMails := make(map[string]bool)
+	for _, studentMail := range studentMails {
+		studentMail = NormalizeMail(studentMail)
+		if requestedMails[studentMail] {
+			continue
+		}
+		requestedMails[studentMail] = true
+		keys = append(keys, map[string]*dynamodb.AttributeValue{"StudentMail": {S: aws.String(studentMail)}})
+	}
+	for start := 0; start < len(keys); start += maxBatchGetItems {
+		end := start + maxBatchGetItems
+		if end > len(keys) {
+			end = len(keys)
+		}
+		requestItems := map[string]*dynamodb.KeysAndAttributes{
+			repository.studentsTableName: {Keys: keys[start:end]},
+		}
+		delay := 100 * time.Millisecond
+		for attempt := 1; len(requestItems) > 0; attempt++ {
+			if attempt > maxBatchGetAttempts {
+				log.Println("student locales not read after", maxBatchGetAttempts, "attempts")
+				return nil, UnknownError
+			}
+			if attempt > 1 {
+				time.Sleep(delay)
+				delay *= 2
+			}
+			batchGetItemOutput, err := repository.client.BatchGetItem(&dynamodb.BatchGetItemInput{RequestItems: requestItems})
+			if err != nil {
+				log.Println(err)
+				return nil, UnknownError
+			}
+			var studentItems []StudentItem
+			err = dynamodbattribute.UnmarshalListOfMaps(batchGetItemOutput.Responses[repository.studentsTableName], &studentItems)
+			if err != nil {
+				log.Println(err)
+				return nil, UnknownError
+			}
+			for _, studentItem := range studentItems {
+				locales[studentItem.StudentMail] = studentItem.Locale
+			}
+			requestItems = batchGetItemOutput.UnprocessedKeys
+		}
+	}
+	return locales, nil
+}
+
+// scanStudentItems reads every item of the students table and passes it to the provided function. The scan stops at
+// the first error returned by the function
+func (repository *DynamoDbRepository) scanStudentItems(handleItem func(StudentItem) error) error {
+	var handleErr error
+	scanInput := &dynamodb.ScanInput{
+		TableName: aws.String(repository.studentsTableName),
+	}
+	err := repository.client.ScanPages(scanInput, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		var studentItems []StudentItem
+		handleErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &studentItems)
+		if handleErr != nil {
+			return false
+		}
+		for _, studentItem := range studentItems {
+			handleErr = handleItem(studentItem)
+			if handleErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return handleErr
+}
@@ -0,0 +1,313 @@
+package coursehandler
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/redefik/notificationmanagement/entity"
+	"log"
+	"strings"
+	"time"
+)
+
+/*Reverse lookup of the subscriptions of a student on DynamoDB. Every subscription is stored both in the mailing list
+of the course and as an item of the subscriptions table, whose partition key is the mail of the student and whose
+sort key is the encoded CourseKey. The two copies are written in the same transaction by AddStudent and
+RemoveStudent*/
+
+// Maximum number of requests accepted by a single BatchWriteItem and number of attempts made to write the items left
+// unprocessed by DynamoDB
+const maxBatchWriteItems = 25
+const maxBatchWriteAttempts = 5
+
+// Causes of the failure of a DynamoDB transaction
+const (
+	transactionFailed          = iota // any other failure
+	transactionConditionFailed        // a condition expression of the transaction is not satisfied
+	transactionConflict               // another request is updating one of the items of the transaction
+)
+
+// Encapsulates the fields of the DynamoDB item representing the subscription of a student to a course
+type SubscriptionItem struct {
+	StudentMail string // partition key, it is the normalized mail of the student
+	CourseName  string // sort key, it is the encoded CourseKey of the course
+	Name        string
+	Department  string
+	Year        string
+}
+
+// newSubscriptionItem returns the item representing the subscription of the mail to the course with the given key
+func newSubscriptionItem(studentMail string, key string, course entity.Course) SubscriptionItem {
+	return SubscriptionItem{
+		StudentMail: studentMail,
+		CourseName:  key,
+		Name:        course.Name,
+		Department:  course.Department,
+		Year:        course.Year,
+	}
+}
+
+// subscriptionKey returns the primary key of the item representing the subscription of the mail to the course
+func subscriptionKey(studentMail string, key string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"StudentMail": {S: aws.String(studentMail)},
+		"CourseName":  {S: aws.String(key)},
+	}
+}
+
+// transactionFailureCause tells why TransactWriteItems failed. The SDK reports the reasons of the cancellation of a
+// transaction inside the message of the error, e.g. "... [ConditionalCheckFailed, None]"
+func transactionFailureCause(err error) int {
+	awsError, ok := err.(awserr.Error)
+	if !ok || awsError.Code() != dynamodb.ErrCodeTransactionCanceledException {
+		return transactionFailed
+	}
+	if strings.Contains(awsError.Message(), "ConditionalCheckFailed") {
+		return transactionConditionFailed
+	}
+	if strings.Contains(awsError.Message(), "TransactionConflict") {
+		return transactionConflict
+	}
+	return transactionFailed
+}
+
+// batchWrite sends the requests to the given table in batches of maxBatchWriteItems. The requests left unprocessed
+// by DynamoDB are sent again, waiting a little longer after each attempt
+func (repository *DynamoDbRepository) batchWrite(tableName string, writeRequests []*dynamodb.WriteRequest) error {
+	for start := 0; start < len(writeRequests); start += maxBatchWriteItems {
+		end := start + maxBatchWriteItems
+		if end > len(writeRequests) {
+			end = len(writeRequests)
+		}
+		requestItems := map[string][]*dynamodb.WriteRequest{tableName: writeRequests[start:end]}
+		for attempt := 0; len(requestItems) > 0; attempt++ {
+			if attempt == maxBatchWriteAttempts {
+				return errors.New("some items have not been processed by DynamoDB")
+			}
+			if attempt > 0 {
+				time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+			}
+			batchWriteItemOutput, err := repository.client.BatchWriteItem(&dynamodb.BatchWriteItemInput{
+				RequestItems: requestItems,
+			})
+			if err != nil {
+				return err
+			}
+			requestItems = batchWriteItemOutput.UnprocessedItems
+		}
+	}
+	return nil
+}
+
+// deleteSubscriptions removes from the subscriptions table the subscriptions of the mails to the course with the
+// given key
+func (repository *DynamoDbRepository) deleteSubscriptions(key string, mailingList []string) error {
+	writeRequests := make([]*dynamodb.WriteRequest, 0, len(mailingList))
+	for _, studentMail := range mailingList {
+		writeRequests = append(writeRequests, &dynamodb.WriteRequest{
+			DeleteRequest: &dynamodb.DeleteRequest{Key: subscriptionKey(studentMail, key)},
+		})
+	}
+	return repository.batchWrite(repository.subscriptionsTableName, writeRequests)
+}
+
+// getSubscriptionItems returns the items of the subscriptions table belonging to the given mail
+func (repository *DynamoDbRepository) getSubscriptionItems(studentMail string) ([]SubscriptionItem, error) {
+	var subscriptionItems []SubscriptionItem
+	var unmarshalErr error
+	queryInput := &dynamodb.QueryInput{
+		TableName:              aws.String(repository.subscriptionsTableName),
+		KeyConditionExpression: aws.String("StudentMail = :mail"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":mail": {S: aws.String(studentMail)},
+		},
+	}
+	err := repository.client.QueryPages(queryInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		var pageItems []SubscriptionItem
+		unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &pageItems)
+		subscriptionItems = append(subscriptionItems, pageItems...)
+		return unmarshalErr == nil
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+	return subscriptionItems, err
+}
+
+// GetStudentCourses returns the courses whose mailing list contains the given mail, querying the subscriptions
+// table. It returns UnknownError if DynamoDB cannot be queried
+func (repository *DynamoDbRepository) GetStudentCourses(studentMail string) ([]entity.Course, error) {
+	subscriptionItems, err := repository.getSubscriptionItems(NormalizeMail(studentMail))
+	if err != nil {
+		log.Println(err)
+		return nil, UnknownError
+	}
+	courses := make([]entity.Course, 0, len(subscriptionItems))
+	for _, subscriptionItem := range subscriptionItems {
+		courses = append(courses, entity.Course{
+			Name:       subscriptionItem.Name,
+			Department: subscriptionItem.Department,
+			Year:       subscriptionItem.Year,
+		})
+	}
+	return courses, nil
+}
+
+// RemoveStudentFromAllCourses removes the given mail from the mailing list of every course, returning the courses
+// the student has been unsubscribed from. Each course is updated by its own transaction, so on error the student
+// may still be subscribed to some courses and the operation can be repeated
+func (repository *DynamoDbRepository) RemoveStudentFromAllCourses(studentMail string) ([]entity.Course, error) {
+	studentMail = NormalizeMail(studentMail)
+	subscriptionItems, err := repository.getSubscriptionItems(studentMail)
+	if err != nil {
+		log.Println(err)
+		return nil, UnknownError
+	}
+	courses := make([]entity.Course, 0, len(subscriptionItems))
+	for _, subscriptionItem := range subscriptionItems {
+		course := entity.Course{
+			Name:       subscriptionItem.Name,
+			Department: subscriptionItem.Department,
+			Year:       subscriptionItem.Year,
+		}
+		err = repository.RemoveStudent(course, studentMail)
+		if err == NotFoundError {
+			// the course has been deleted but its subscriptions have not been cleaned up
+			err = repository.deleteSubscriptions(subscriptionItem.CourseName, []string{studentMail})
+			if err != nil {
+				log.Println(err)
+				return courses, UnknownError
+			}
+			continue
+		}
+		if err != nil {
+			return courses, err
+		}
+		courses = append(courses, course)
+	}
+	return courses, nil
+}
+
+// deleteOrphanSubscription removes the subscription of the mail to the course with the given key, provided that the
+// course does not exist or the mail is not in its mailing list. The check and the deletion are a single transaction,
+// so a student who subscribes in the meantime keeps the subscription. It returns false when the subscription has been
+// kept
+func (repository *DynamoDbRepository) deleteOrphanSubscription(studentMail string, key string) (bool, error) {
+	transactWriteItemsInput := &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				ConditionCheck: &dynamodb.ConditionCheck{
+					Key: map[string]*dynamodb.AttributeValue{
+						"CourseName": {S: aws.String(key)},
+					},
+					ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+						":mail": {S: aws.String(studentMail)},
+					},
+					ConditionExpression: aws.String("attribute_not_exists(CourseName) OR NOT contains(MailingList, :mail)"),
+					TableName:           aws.String(repository.tableName),
+				},
+			},
+			{
+				Delete: &dynamodb.Delete{
+					Key:       subscriptionKey(studentMail, key),
+					TableName: aws.String(repository.subscriptionsTableName),
+				},
+			},
+		},
+	}
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+		}
+		_, err := repository.client.TransactWriteItems(transactWriteItemsInput)
+		if err == nil {
+			return true, nil
+		}
+		switch transactionFailureCause(err) {
+		// the student has been subscribed to the course after the mailing lists have been read
+		case transactionConditionFailed:
+			return false, nil
+		case transactionConflict:
+			if attempt+1 < maxBatchWriteAttempts {
+				continue
+			}
+		}
+		return false, err
+	}
+}
+
+// BackfillSubscriptions rebuilds the subscriptions table from the mailing lists stored in the courses table: the
+// missing subscriptions are written and the ones that do not match any mailing list are deleted. It must be run once
+// when the subscriptions table is introduced and after MigrateCourseKeys, which changes the keys of the courses.
+// The subscriptions can change while it runs: a subscription is deleted only if the mailing list still does not
+// contain the mail, while a subscription written for a student who unsubscribes in the meantime is deleted by the
+// next run. It returns the number of written and deleted subscriptions
+func BackfillSubscriptions(repository *DynamoDbRepository) (int, int, error) {
+	// subscriptions found in the courses table, indexed by mail and course key
+	subscriptions := make(map[[2]string]bool)
+	var putRequests []*dynamodb.WriteRequest
+	err := repository.scanCourseItems(func(courseItem CourseItem) error {
+		key, err := parseStoredCourseKey(courseItem.CourseName)
+		if err != nil {
+			log.Println("course", courseItem.CourseName, "skipped:", err)
+			return nil
+		}
+		for _, studentMail := range courseItem.MailingList {
+			subscriptions[[2]string{studentMail, courseItem.CourseName}] = true
+			subscriptionItem, err := dynamodbattribute.MarshalMap(
+				newSubscriptionItem(studentMail, courseItem.CourseName, key.Course()))
+			if err != nil {
+				return err
+			}
+			putRequests = append(putRequests, &dynamodb.WriteRequest{
+				PutRequest: &dynamodb.PutRequest{Item: subscriptionItem},
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	err = repository.batchWrite(repository.subscriptionsTableName, putRequests)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// subscriptions that do not match the mailing lists read before
+	var orphanItems []SubscriptionItem
+	var unmarshalErr error
+	scanInput := &dynamodb.ScanInput{
+		TableName: aws.String(repository.subscriptionsTableName),
+	}
+	err = repository.client.ScanPages(scanInput, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		var subscriptionItems []SubscriptionItem
+		unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &subscriptionItems)
+		for _, subscriptionItem := range subscriptionItems {
+			if !subscriptions[[2]string{subscriptionItem.StudentMail, subscriptionItem.CourseName}] {
+				orphanItems = append(orphanItems, subscriptionItem)
+			}
+		}
+		return unmarshalErr == nil
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+	if err != nil {
+		return len(putRequests), 0, err
+	}
+	deletedSubscriptions := 0
+	for _, orphanItem := range orphanItems {
+		deleted, err := repository.deleteOrphanSubscription(orphanItem.StudentMail, orphanItem.CourseName)
+		if err != nil {
+			return len(putRequests), deletedSubscriptions, err
+		}
+		if deleted {
+			deletedSubscriptions++
+		} else {
+			log.Println("subscription of", orphanItem.StudentMail, "to", orphanItem.CourseName, "kept: added meanwhile")
+		}
+	}
+	return len(putRequests), deletedSubscriptions, nil
+}
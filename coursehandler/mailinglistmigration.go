@@ -0,0 +1,152 @@
+package coursehandler
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"log"
+	"strconv"
+)
+
+/*One-shot migration of the DynamoDB course items from the original representation of the mailing list (a list of
+mails compared case-sensitively) to a string set of normalized mails*/
+
+// Maximum number of times the rewrite of a mailing list is attempted before giving up with ConcurrentUpdateError
+const maxUpdateAttempts = 3
+
+// updateMailingList replaces the mailing list of the course with the provided one, written as a string set, provided
+// that the item has not been modified since it was read. The check relies on the Version attribute, that is
+// incremented by every update (items written before its introduction do not have it, so a missing attribute is
+// treated as version 0). It returns ConcurrentUpdateError if the item has been modified (or deleted) in the meantime
+func (repository *DynamoDbRepository) updateMailingList(readCourse CourseItem, mailingList []string) error {
+	expressionAttributeValues := map[string]*dynamodb.AttributeValue{
+		":version":      {N: aws.String(strconv.FormatInt(readCourse.Version, 10))},
+		":next_version": {N: aws.String(strconv.FormatInt(readCourse.Version+1, 10))},
+	}
+	updateExpression := "SET Version = :next_version"
+	if len(mailingList) == 0 {
+		// An empty set cannot be stored, so the attribute is removed
+		updateExpression += " REMOVE MailingList"
+	} else {
+		expressionAttributeValues[":mailing_list"] = &dynamodb.AttributeValue{SS: aws.StringSlice(mailingList)}
+		updateExpression += ", MailingList = :mailing_list"
+	}
+	updateItemInput := &dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"CourseName": {S: aws.String(readCourse.CourseName)},
+		},
+		ExpressionAttributeValues: expressionAttributeValues,
+		ConditionExpression: aws.String("attribute_exists(CourseName) AND " +
+			"(attribute_not_exists(Version) OR Version = :version)"),
+		UpdateExpression: aws.String(updateExpression),
+		TableName:        aws.String(repository.tableName),
+	}
+	_, err := repository.client.UpdateItem(updateItemInput)
+	if err != nil {
+		log.Println(err)
+		// check if AWS DynamoDB raised an error
+		awsError, ok := err.(awserr.Error)
+		if ok {
+			switch awsError.Code() {
+			// raised when the item has been updated or deleted after being read
+			case dynamodb.ErrCodeConditionalCheckFailedException:
+				return ConcurrentUpdateError
+			default:
+				return UnknownError
+			}
+		}
+		return UnknownError
+	}
+	return nil
+}
+
+// normalizeMailingList returns the normalized mails of the list, without duplicates. The order of the first
+// occurrences is preserved
+func normalizeMailingList(mailingList []string) []string {
+	var normalizedList []string
+	for _, mail := range mailingList {
+		normalizedMail := NormalizeMail(mail)
+		if !contains(normalizedList, normalizedMail) {
+			normalizedList = append(normalizedList, normalizedMail)
+		}
+	}
+	return normalizedList
+}
+
+// isNormalized tells if the mailing list is already made of distinct normalized mails
+func isNormalized(mailingList []string) bool {
+	normalizedList := normalizeMailingList(mailingList)
+	if len(normalizedList) != len(mailingList) {
+		return false
+	}
+	for i := range mailingList {
+		if mailingList[i] != normalizedList[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// migrateCourseItem rewrites the mailing list of the item as a normalized string set. If the item is modified
+// concurrently, it is read again and the rewrite is retried. The function returns the number of mails merged
+// because they differed only by case (or by surrounding spaces)
+func (repository *DynamoDbRepository) migrateCourseItem(courseItem CourseItem) (int, error) {
+	var err error
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		if attempt > 0 {
+			courseItem, err = repository.getCourseItemByKey(courseItem.CourseName)
+			if err == NotFoundError {
+				// the course has been deleted in the meantime, so there is nothing left to migrate
+				return 0, nil
+			}
+			if err != nil {
+				return 0, err
+			}
+		}
+		normalizedList := normalizeMailingList(courseItem.MailingList)
+		err = repository.updateMailingList(courseItem, normalizedList)
+		if err != ConcurrentUpdateError {
+			return len(courseItem.MailingList) - len(normalizedList), err
+		}
+	}
+	return 0, err
+}
+
+// MigrateMailingListsToStringSets converts the mailing lists of all the courses stored in DynamoDB to string sets of
+// normalized mails, merging the mails that differ only by case. Items already converted are skipped, so the
+// migration can be run more than once. The function returns the number of migrated courses and of merged mails
+func MigrateMailingListsToStringSets(repository *DynamoDbRepository) (int, int, error) {
+	migratedCourses := 0
+	mergedMails := 0
+	var migrationErr error
+	scanInput := &dynamodb.ScanInput{
+		TableName: aws.String(repository.tableName),
+	}
+	err := repository.client.ScanPages(scanInput, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			var courseItem CourseItem
+			migrationErr = dynamodbattribute.UnmarshalMap(item, &courseItem)
+			if migrationErr != nil {
+				return false
+			}
+			// only the mailing lists still stored as lists or containing not normalized mails are rewritten
+			mailingList := item["MailingList"]
+			if mailingList == nil || (mailingList.L == nil && isNormalized(courseItem.MailingList)) {
+				continue
+			}
+			var merged int
+			merged, migrationErr = repository.migrateCourseItem(courseItem)
+			if migrationErr != nil {
+				return false
+			}
+			migratedCourses++
+			mergedMails += merged
+		}
+		return true
+	})
+	if err != nil {
+		return migratedCourses, mergedMails, err
+	}
+	return migratedCourses, mergedMails, migrationErr
+}
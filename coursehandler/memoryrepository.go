@@ -0,0 +1,345 @@
+package coursehandler
+
+import (
+	"github.com/redefik/notificationmanagement/entity"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*In-memory implementation of CourseRepository. The data are lost when the microservice stops, so the backend is meant
+for local development and testing*/
+
+// MemoryRepository keeps the mailing list of each course in a map, indexed by CourseKey and protected by a mutex.
+// The names of the mail templates and the default languages of the courses, as well as the locales of the students,
+// are kept in other maps, as well as the deliveries of the notifications
+type MemoryRepository struct {
+	mutex      sync.RWMutex
+	courses    map[CourseKey][]string
+	templates  map[CourseKey]string
+	languages  map[CourseKey]string
+	locales    map[string]string
+	deliveries map[string]*memoryDelivery
+}
+
+// memoryDelivery is a Delivery together with the set of its recipients and its expiration time
+type memoryDelivery struct {
+	Delivery
+	delivered map[string]bool
+	expiresAt time.Time
+}
+
+// NewMemoryRepository returns an empty MemoryRepository
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		courses:    make(map[CourseKey][]string),
+		templates:  make(map[CourseKey]string),
+		languages:  make(map[CourseKey]string),
+		locales:    make(map[string]string),
+		deliveries: make(map[string]*memoryDelivery),
+	}
+}
+
+// CreateCourse adds a course with an empty mailing list. It returns ConflictError if the course already exists
+func (repository *MemoryRepository) CreateCourse(course entity.Course) error {
+	key := NewCourseKey(course)
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	if _, ok := repository.courses[key]; ok {
+		return ConflictError
+	}
+	repository.courses[key] = nil
+	return nil
+}
+
+// DeleteCourse removes the course. It returns NotFoundError if the course does not exist
+func (repository *MemoryRepository) DeleteCourse(course entity.Course) error {
+	key := NewCourseKey(course)
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	if _, ok := repository.courses[key]; !ok {
+		return NotFoundError
+	}
+	delete(repository.courses, key)
+	delete(repository.templates, key)
+	delete(repository.languages, key)
+	return nil
+}
+
+// AddStudent appends the mail to the mailing list of the course. It returns NotFoundError if the course does not
+// exist and ConflictMailError if the mail is already in the mailing list
+func (repository *MemoryRepository) AddStudent(course entity.Course, studentMail string) error {
+	studentMail = NormalizeMail(studentMail)
+	key := NewCourseKey(course)
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	mailingList, ok := repository.courses[key]
+	if !ok {
+		return NotFoundError
+	}
+	if contains(mailingList, studentMail) {
+		return ConflictMailError
+	}
+	repository.courses[key] = append(mailingList, studentMail)
+	return nil
+}
+
+// RemoveStudent removes the mail from the mailing list of the course. It returns NotFoundError if the course does
+// not exist
+func (repository *MemoryRepository) RemoveStudent(course entity.Course, studentMail string) error {
+	studentMail = NormalizeMail(studentMail)
+	key := NewCourseKey(course)
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	mailingList, ok := repository.courses[key]
+	if !ok {
+		return NotFoundError
+	}
+	repository.courses[key] = removeMailFromList(studentMail, mailingList)
+	return nil
+}
+
+// GetCourseMailingList returns a copy of the mailing list of the course. It returns NotFoundError if the course
+// does not exist
+func (repository *MemoryRepository) GetCourseMailingList(course entity.Course) ([]string, error) {
+	key := NewCourseKey(course)
+	repository.mutex.RLock()
+	defer repository.mutex.RUnlock()
+	mailingList, ok := repository.courses[key]
+	if !ok {
+		return nil, NotFoundError
+	}
+	return append([]string(nil), mailingList...), nil
+}
+
+// ListCourses returns the courses matching the filter, ordered by encoded CourseKey
+func (repository *MemoryRepository) ListCourses(filter CourseFilter, pageToken string, limit int) ([]entity.Course, string, error) {
+	lastKey := ""
+	if pageToken != "" {
+		var err error
+		lastKey, err = decodePageToken(pageToken)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	repository.mutex.RLock()
+	var matchingKeys []string
+	for key := range repository.courses {
+		encodedKey := key.Encode()
+		if filter.matches(key) && encodedKey > lastKey {
+			matchingKeys = append(matchingKeys, encodedKey)
+		}
+	}
+	repository.mutex.RUnlock()
+	sort.Strings(matchingKeys)
+
+	nextPageToken := ""
+	if len(matchingKeys) > limit {
+		matchingKeys = matchingKeys[:limit]
+		nextPageToken = encodePageToken(matchingKeys[limit-1])
+	}
+	courses := make([]entity.Course, 0, len(matchingKeys))
+	for _, encodedKey := range matchingKeys {
+		key, _ := ParseCourseKey(encodedKey)
+		courses = append(courses, key.Course())
+	}
+	return courses, nextPageToken, nil
+}
+
+// GetStudentCourses returns the courses whose mailing list contains the mail, ordered by encoded CourseKey
+func (repository *MemoryRepository) GetStudentCourses(studentMail string) ([]entity.Course, error) {
+	studentMail = NormalizeMail(studentMail)
+	repository.mutex.RLock()
+	defer repository.mutex.RUnlock()
+	return repository.studentCourses(studentMail), nil
+}
+
+// RemoveStudentFromAllCourses removes the mail from every mailing list and returns the courses the student has
+// been unsubscribed from
+func (repository *MemoryRepository) RemoveStudentFromAllCourses(studentMail string) ([]entity.Course, error) {
+	studentMail = NormalizeMail(studentMail)
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	courses := repository.studentCourses(studentMail)
+	for _, course := range courses {
+		key := NewCourseKey(course)
+		repository.courses[key] = removeMailFromList(studentMail, repository.courses[key])
+	}
+	return courses, nil
+}
+
+// studentCourses scans the mailing lists looking for the mail. The caller must hold the mutex
+func (repository *MemoryRepository) studentCourses(studentMail string) []entity.Course {
+	var matchingKeys []string
+	for key, mailingList := range repository.courses {
+		if contains(mailingList, studentMail) {
+			matchingKeys = append(matchingKeys, key.Encode())
+		}
+	}
+	sort.Strings(matchingKeys)
+	courses := make([]entity.Course, 0, len(matchingKeys))
+	for _, encodedKey := range matchingKeys {
+		key, _ := ParseCourseKey(encodedKey)
+		courses = append(courses, key.Course())
+	}
+	return courses
+}
+
+// AddStudents appends the mails to the mailing list of the course while holding the lock, so the whole import is
+// atomic
+func (repository *MemoryRepository) AddStudents(course entity.Course, studentMails []string) ([]error, error) {
+	key := NewCourseKey(course)
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	mailingList, ok := repository.courses[key]
+	if !ok {
+		return nil, NotFoundError
+	}
+	results := make([]error, len(studentMails))
+	for i, studentMail := range studentMails {
+		studentMail = NormalizeMail(studentMail)
+		if contains(mailingList, studentMail) {
+			results[i] = ConflictMailError
+			continue
+		}
+		mailingList = append(mailingList, studentMail)
+	}
+	repository.courses[key] = mailingList
+	return results, nil
+}
+
+// SetCourseTemplate stores the name of the mail template of the course. It returns NotFoundError if the course does
+// not exist
+func (repository *MemoryRepository) SetCourseTemplate(course entity.Course, templateName string) error {
+	key := NewCourseKey(course)
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	if _, ok := repository.courses[key]; !ok {
+		return NotFoundError
+	}
+	if templateName == "" {
+		delete(repository.templates, key)
+	} else {
+		repository.templates[key] = templateName
+	}
+	return nil
+}
+
+// GetCourseTemplate returns the name of the mail template of the course. It returns NotFoundError if the course does
+// not exist
+func (repository *MemoryRepository) GetCourseTemplate(course entity.Course) (string, error) {
+	key := NewCourseKey(course)
+	repository.mutex.RLock()
+	defer repository.mutex.RUnlock()
+	if _, ok := repository.courses[key]; !ok {
+		return "", NotFoundError
+	}
+	return repository.templates[key], nil
+}
+
+// SetCourseLanguage stores the default language of the course. It returns NotFoundError if the course does not exist
+func (repository *MemoryRepository) SetCourseLanguage(course entity.Course, language string) error {
+	key := NewCourseKey(course)
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	if _, ok := repository.courses[key]; !ok {
+		return NotFoundError
+	}
+	if language == "" {
+		delete(repository.languages, key)
+	} else {
+		repository.languages[key] = language
+	}
+	return nil
+}
+
+// GetCourseLanguage returns the default language of the course. It returns NotFoundError if the course does not
+// exist
+func (repository *MemoryRepository) GetCourseLanguage(course entity.Course) (string, error) {
+	key := NewCourseKey(course)
+	repository.mutex.RLock()
+	defer repository.mutex.RUnlock()
+	if _, ok := repository.courses[key]; !ok {
+		return "", NotFoundError
+	}
+	return repository.languages[key], nil
+}
+
+// SetStudentLocale stores the preferred locale of the student
+func (repository *MemoryRepository) SetStudentLocale(studentMail string, locale string) error {
+	studentMail = NormalizeMail(studentMail)
+	locale = NormalizeLocale(locale)
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	if locale == "" {
+		delete(repository.locales, studentMail)
+	} else {
+		repository.locales[studentMail] = locale
+	}
+	return nil
+}
+
+// GetStudentLocales returns the preferred locales of the given students
+func (repository *MemoryRepository) GetStudentLocales(studentMails []string) (map[string]string, error) {
+	repository.mutex.RLock()
+	defer repository.mutex.RUnlock()
+	locales := make(map[string]string)
+	for _, studentMail := range studentMails {
+		studentMail = NormalizeMail(studentMail)
+		if locale, ok := repository.locales[studentMail]; ok {
+			locales[studentMail] = locale
+		}
+	}
+	return locales, nil
+}
+
+// delivery returns the delivery of the notification, creating it if needed. The expired deliveries are removed when
+// a new one is created. The caller must hold the write lock
+func (repository *MemoryRepository) delivery(notificationId string) *memoryDelivery {
+	if delivery, ok := repository.deliveries[notificationId]; ok {
+		return delivery
+	}
+	now := time.Now()
+	for id, delivery := range repository.deliveries {
+		if now.After(delivery.expiresAt) {
+			delete(repository.deliveries, id)
+		}
+	}
+	delivery := &memoryDelivery{delivered: make(map[string]bool), expiresAt: now.Add(DeliveryRetention)}
+	repository.deliveries[notificationId] = delivery
+	return delivery
+}
+
+// AddDeliveredRecipients records that the notification has been sent to the students
+func (repository *MemoryRepository) AddDeliveredRecipients(notificationId string, studentMails []string) error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	delivery := repository.delivery(notificationId)
+	for _, studentMail := range studentMails {
+		studentMail = NormalizeMail(studentMail)
+		if !delivery.delivered[studentMail] {
+			delivery.delivered[studentMail] = true
+			delivery.Recipients = append(delivery.Recipients, studentMail)
+		}
+	}
+	return nil
+}
+
+// CompleteDelivery records that the notification has been sent to the whole mailing list
+func (repository *MemoryRepository) CompleteDelivery(notificationId string) error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	repository.delivery(notificationId).Completed = true
+	return nil
+}
+
+// GetDelivery returns a copy of the delivery of the notification
+func (repository *MemoryRepository) GetDelivery(notificationId string) (Delivery, error) {
+	repository.mutex.RLock()
+	defer repository.mutex.RUnlock()
+	delivery, ok := repository.deliveries[notificationId]
+	if !ok {
+		return Delivery{}, nil
+	}
+	return Delivery{Completed: delivery.Completed, Recipients: append([]string(nil), delivery.Recipients...)}, nil
+}
@@ -0,0 +1,101 @@
+package coursehandler
+
+import (
+	"database/sql"
+	"log"
+)
+
+/*Schema of the SQL course store. The migrations are embedded in the binary and applied in order at startup, the
+version reached by the database is tracked in the schema_migrations table. A new migration must be appended to
+sqlMigrations, the existing ones must never be modified.
+The statements are written in the subset of SQL understood both by PostgreSQL and by SQLite*/
+
+var sqlMigrations = [][]string{
+	// 1: courses and the subscriptions to their mailing lists
+	{
+		`CREATE TABLE courses (
+			name VARCHAR(255) NOT NULL,
+			department VARCHAR(255) NOT NULL,
+			year VARCHAR(9) NOT NULL,
+			PRIMARY KEY (name, department, year)
+		)`,
+		`CREATE TABLE subscriptions (
+			course_name VARCHAR(255) NOT NULL,
+			course_department VARCHAR(255) NOT NULL,
+			course_year VARCHAR(9) NOT NULL,
+			student_mail VARCHAR(320) NOT NULL,
+			PRIMARY KEY (course_name, course_department, course_year, student_mail),
+			FOREIGN KEY (course_name, course_department, course_year)
+				REFERENCES courses (name, department, year) ON DELETE CASCADE
+		)`,
+	},
+	// 2: lookup of the courses a student is subscribed to
+	{
+		`CREATE INDEX subscriptions_student_mail ON subscriptions (student_mail)`,
+	},
+	// 3: mail template of the course, NULL when the course uses the template of its department
+	{
+		`ALTER TABLE courses ADD COLUMN template VARCHAR(255)`,
+	},
+	// 4: default language of the courses and preferred locale of the students
+	{
+		`ALTER TABLE courses ADD COLUMN language VARCHAR(35)`,
+		`CREATE TABLE student_locales (
+			student_mail VARCHAR(320) NOT NULL PRIMARY KEY,
+			locale VARCHAR(35) NOT NULL
+		)`,
+	},
+	// 5: deliveries of the notifications and students they have already been sent to. The expiration time is stored
+	// in seconds since the epoch
+	{
+		`CREATE TABLE deliveries (
+			notification_id VARCHAR(255) NOT NULL PRIMARY KEY,
+			completed BOOLEAN NOT NULL DEFAULT FALSE,
+			expires_at BIGINT NOT NULL
+		)`,
+		`CREATE INDEX deliveries_expires_at ON deliveries (expires_at)`,
+		`CREATE TABLE delivered_recipients (
+			notification_id VARCHAR(255) NOT NULL,
+			student_mail VARCHAR(320) NOT NULL,
+			PRIMARY KEY (notification_id, student_mail),
+			FOREIGN KEY (notification_id) REFERENCES deliveries (notification_id) ON DELETE CASCADE
+		)`,
+	},
+}
+
+// migrateSchema applies the migrations not yet applied to the database. Each migration runs in its own transaction
+func migrateSchema(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`)
+	if err != nil {
+		return err
+	}
+	var currentVersion int
+	err = db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&currentVersion)
+	if err != nil {
+		return err
+	}
+	for version := currentVersion + 1; version <= len(sqlMigrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		for _, statement := range sqlMigrations[version-1] {
+			_, err = tx.Exec(statement)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		_, err = tx.Exec(`INSERT INTO schema_migrations (version) VALUES ($1)`, version)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+		log.Println("Applied migration", version, "to the course store")
+	}
+	return nil
+}
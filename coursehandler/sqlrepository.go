@@ -0,0 +1,576 @@
+package coursehandler
+
+import (
+	"database/sql"
+	"github.com/redefik/notificationmanagement/entity"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*SQL implementation of CourseRepository. The courses and the subscriptions to their mailing lists are kept in two
+normalized tables (see sqlmigrations.go), so they can be queried directly from the relational data warehouse.
+The repository is tested with SQLite and targets PostgreSQL in production: the driver must be registered by the
+main package*/
+
+// SqlRepository stores the courses in a relational database through database/sql
+type SqlRepository struct {
+	db *sql.DB
+}
+
+// NewSqlRepository connects to the database with the given driver and data source name and brings its schema up
+// to date
+func NewSqlRepository(driverName string, dataSourceName string) (*SqlRepository, error) {
+	db, err := sql.Open(driverName, dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Ping()
+	if err == nil {
+		err = migrateSchema(db)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SqlRepository{db: db}, nil
+}
+
+// Close releases the connections to the database
+func (repository *SqlRepository) Close() error {
+	return repository.db.Close()
+}
+
+// inTransaction runs the provided function inside a transaction, that is committed only if the function succeeds.
+// The error returned by the function or by the database is converted in one of the ad hoc errors of the package
+func (repository *SqlRepository) inTransaction(operation func(tx *sql.Tx) error) error {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		log.Println(err)
+		return UnknownError
+	}
+	err = operation(tx)
+	if err != nil {
+		tx.Rollback()
+		return toRepositoryError(err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Println(err)
+		return UnknownError
+	}
+	return nil
+}
+
+// checkCourseExists returns NotFoundError if the course is not stored in the database
+func checkCourseExists(tx *sql.Tx, course entity.Course) error {
+	var found int
+	err := tx.QueryRow(`SELECT 1 FROM courses WHERE name = $1 AND department = $2 AND year = $3`,
+		course.Name, course.Department, course.Year).Scan(&found)
+	if err == sql.ErrNoRows {
+		return NotFoundError
+	}
+	return err
+}
+
+// CreateCourse adds a course with an empty mailing list. It returns ConflictError if the course already exists
+func (repository *SqlRepository) CreateCourse(course entity.Course) error {
+	return repository.inTransaction(func(tx *sql.Tx) error {
+		result, err := tx.Exec(`INSERT INTO courses (name, department, year) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`,
+			course.Name, course.Department, course.Year)
+		if err != nil {
+			return err
+		}
+		insertedRows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if insertedRows == 0 {
+			return ConflictError
+		}
+		return nil
+	})
+}
+
+// DeleteCourse removes the course together with its subscriptions. It returns NotFoundError if the course does not
+// exist
+func (repository *SqlRepository) DeleteCourse(course entity.Course) error {
+	return repository.inTransaction(func(tx *sql.Tx) error {
+		// The subscriptions are deleted explicitly because SQLite does not enforce foreign keys by default
+		_, err := tx.Exec(`DELETE FROM subscriptions WHERE course_name = $1 AND course_department = $2 AND course_year = $3`,
+			course.Name, course.Department, course.Year)
+		if err != nil {
+			return err
+		}
+		result, err := tx.Exec(`DELETE FROM courses WHERE name = $1 AND department = $2 AND year = $3`,
+			course.Name, course.Department, course.Year)
+		if err != nil {
+			return err
+		}
+		deletedRows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if deletedRows == 0 {
+			return NotFoundError
+		}
+		return nil
+	})
+}
+
+// AddStudent subscribes the mail to the mailing list of the course. It returns NotFoundError if the course does not
+// exist and ConflictMailError if the mail is already in the mailing list
+func (repository *SqlRepository) AddStudent(course entity.Course, studentMail string) error {
+	studentMail = NormalizeMail(studentMail)
+	return repository.inTransaction(func(tx *sql.Tx) error {
+		err := checkCourseExists(tx, course)
+		if err != nil {
+			return err
+		}
+		result, err := tx.Exec(`INSERT INTO subscriptions (course_name, course_department, course_year, student_mail)
+			VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING`, course.Name, course.Department, course.Year, studentMail)
+		if err != nil {
+			return err
+		}
+		insertedRows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if insertedRows == 0 {
+			return ConflictMailError
+		}
+		return nil
+	})
+}
+
+// AddStudents subscribes the mails to the mailing list of the course in a single transaction
+func (repository *SqlRepository) AddStudents(course entity.Course, studentMails []string) ([]error, error) {
+	results := make([]error, len(studentMails))
+	err := repository.inTransaction(func(tx *sql.Tx) error {
+		err := checkCourseExists(tx, course)
+		if err != nil {
+			return err
+		}
+		statement, err := tx.Prepare(`INSERT INTO subscriptions (course_name, course_department, course_year, student_mail)
+			VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING`)
+		if err != nil {
+			return err
+		}
+		defer statement.Close()
+		for i, studentMail := range studentMails {
+			result, err := statement.Exec(course.Name, course.Department, course.Year, NormalizeMail(studentMail))
+			if err != nil {
+				return err
+			}
+			insertedRows, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			if insertedRows == 0 {
+				results[i] = ConflictMailError
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// RemoveStudent removes the mail from the mailing list of the course. It returns NotFoundError if the course does
+// not exist
+func (repository *SqlRepository) RemoveStudent(course entity.Course, studentMail string) error {
+	studentMail = NormalizeMail(studentMail)
+	return repository.inTransaction(func(tx *sql.Tx) error {
+		err := checkCourseExists(tx, course)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`DELETE FROM subscriptions
+			WHERE course_name = $1 AND course_department = $2 AND course_year = $3 AND student_mail = $4`,
+			course.Name, course.Department, course.Year, studentMail)
+		return err
+	})
+}
+
+// GetCourseMailingList returns the mailing list of the course. It returns NotFoundError if the course does not exist
+func (repository *SqlRepository) GetCourseMailingList(course entity.Course) ([]string, error) {
+	// The outer join returns a single row with a NULL mail when the course exists but has no subscriptions,
+	// and no rows at all when the course does not exist
+	rows, err := repository.db.Query(`SELECT s.student_mail FROM courses c
+		LEFT JOIN subscriptions s
+			ON s.course_name = c.name AND s.course_department = c.department AND s.course_year = c.year
+		WHERE c.name = $1 AND c.department = $2 AND c.year = $3
+		ORDER BY s.student_mail`, course.Name, course.Department, course.Year)
+	if err != nil {
+		log.Println(err)
+		return nil, UnknownError
+	}
+	defer rows.Close()
+	found := false
+	var mailingList []string
+	for rows.Next() {
+		found = true
+		var studentMail sql.NullString
+		err = rows.Scan(&studentMail)
+		if err != nil {
+			log.Println(err)
+			return nil, UnknownError
+		}
+		if studentMail.Valid {
+			mailingList = append(mailingList, studentMail.String)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		log.Println(err)
+		return nil, UnknownError
+	}
+	if !found {
+		return nil, NotFoundError
+	}
+	return mailingList, nil
+}
+
+// ListCourses returns the courses matching the filter, ordered by name, department and year. The pages are
+// computed with keyset pagination, so each query uses the primary key index of the courses table
+func (repository *SqlRepository) ListCourses(filter CourseFilter, pageToken string, limit int) ([]entity.Course, string, error) {
+	var conditions []string
+	var args []interface{}
+	// placeholder adds an argument to the query and returns the corresponding placeholder
+	placeholder := func(arg interface{}) string {
+		args = append(args, arg)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if filter.Department != "" {
+		conditions = append(conditions, "department = "+placeholder(filter.Department))
+	}
+	if filter.Year != "" {
+		conditions = append(conditions, "year = "+placeholder(filter.Year))
+	}
+	if pageToken != "" {
+		storedKey, err := decodePageToken(pageToken)
+		if err != nil {
+			return nil, "", err
+		}
+		lastKey, err := ParseCourseKey(storedKey)
+		if err != nil {
+			return nil, "", InvalidPageTokenError
+		}
+		// (name, department, year) > last key, written without row values that older databases do not support
+		conditions = append(conditions, "(name > "+placeholder(lastKey.Name)+
+			" OR (name = "+placeholder(lastKey.Name)+" AND department > "+placeholder(lastKey.Department)+")"+
+			" OR (name = "+placeholder(lastKey.Name)+" AND department = "+placeholder(lastKey.Department)+
+			" AND year > "+placeholder(lastKey.Year)+"))")
+	}
+	query := "SELECT name, department, year FROM courses"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	// an additional course is read to know if a further page exists
+	query += " ORDER BY name, department, year LIMIT " + placeholder(limit+1)
+
+	rows, err := repository.db.Query(query, args...)
+	if err != nil {
+		log.Println(err)
+		return nil, "", UnknownError
+	}
+	defer rows.Close()
+	courses := make([]entity.Course, 0, limit)
+	nextPageToken := ""
+	for rows.Next() {
+		if len(courses) == limit {
+			nextPageToken = encodePageToken(NewCourseKey(courses[limit-1]).Encode())
+			break
+		}
+		var course entity.Course
+		err = rows.Scan(&course.Name, &course.Department, &course.Year)
+		if err != nil {
+			log.Println(err)
+			return nil, "", UnknownError
+		}
+		courses = append(courses, course)
+	}
+	if err = rows.Err(); err != nil {
+		log.Println(err)
+		return nil, "", UnknownError
+	}
+	return courses, nextPageToken, nil
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// queryStudentCourses returns the courses the mail is subscribed to, ordered by name, department and year
+func queryStudentCourses(q querier, studentMail string) ([]entity.Course, error) {
+	rows, err := q.Query(`SELECT course_name, course_department, course_year FROM subscriptions
+		WHERE student_mail = $1 ORDER BY course_name, course_department, course_year`, studentMail)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	courses := []entity.Course{}
+	for rows.Next() {
+		var course entity.Course
+		err = rows.Scan(&course.Name, &course.Department, &course.Year)
+		if err != nil {
+			return nil, err
+		}
+		courses = append(courses, course)
+	}
+	return courses, rows.Err()
+}
+
+// GetStudentCourses returns the courses whose mailing list contains the mail, using the index on student_mail
+func (repository *SqlRepository) GetStudentCourses(studentMail string) ([]entity.Course, error) {
+	courses, err := queryStudentCourses(repository.db, NormalizeMail(studentMail))
+	if err != nil {
+		log.Println(err)
+		return nil, UnknownError
+	}
+	return courses, nil
+}
+
+// RemoveStudentFromAllCourses removes the mail from every mailing list in a single transaction and returns the
+// courses the student has been unsubscribed from
+func (repository *SqlRepository) RemoveStudentFromAllCourses(studentMail string) ([]entity.Course, error) {
+	studentMail = NormalizeMail(studentMail)
+	var courses []entity.Course
+	err := repository.inTransaction(func(tx *sql.Tx) error {
+		var err error
+		courses, err = queryStudentCourses(tx, studentMail)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`DELETE FROM subscriptions WHERE student_mail = $1`, studentMail)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return courses, nil
+}
+
+// SetCourseTemplate stores the name of the mail template of the course, or NULL when the name is empty. It returns
+// NotFoundError if the course does not exist
+func (repository *SqlRepository) SetCourseTemplate(course entity.Course, templateName string) error {
+	return repository.inTransaction(func(tx *sql.Tx) error {
+		template := sql.NullString{String: templateName, Valid: templateName != ""}
+		result, err := tx.Exec(`UPDATE courses SET template = $1 WHERE name = $2 AND department = $3 AND year = $4`,
+			template, course.Name, course.Department, course.Year)
+		if err != nil {
+			return err
+		}
+		updatedRows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if updatedRows == 0 {
+			return NotFoundError
+		}
+		return nil
+	})
+}
+
+// GetCourseTemplate returns the name of the mail template of the course. It returns NotFoundError if the course does
+// not exist
+func (repository *SqlRepository) GetCourseTemplate(course entity.Course) (string, error) {
+	var template sql.NullString
+	err := repository.db.QueryRow(`SELECT template FROM courses WHERE name = $1 AND department = $2 AND year = $3`,
+		course.Name, course.Department, course.Year).Scan(&template)
+	if err == sql.ErrNoRows {
+		return "", NotFoundError
+	}
+	if err != nil {
+		log.Println(err)
+		return "", UnknownError
+	}
+	return template.String, nil
+}
+
+// SetCourseLanguage stores the default language of the course, or NULL when the language is empty. It returns
+// NotFoundError if the course does not exist
+func (repository *SqlRepository) SetCourseLanguage(course entity.Course, language string) error {
+	return repository.inTransaction(func(tx *sql.Tx) error {
+		result, err := tx.Exec(`UPDATE courses SET language = $1 WHERE name = $2 AND department = $3 AND year = $4`,
+			sql.NullString{String: language, Valid: language != ""}, course.Name, course.Department, course.Year)
+		if err != nil {
+			return err
+		}
+		updatedRows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if updatedRows == 0 {
+			return NotFoundError
+		}
+		return nil
+	})
+}
+
+// GetCourseLanguage returns the default language of the course. It returns NotFoundError if the course does not
+// exist
+func (repository *SqlRepository) GetCourseLanguage(course entity.Course) (string, error) {
+	var language sql.NullString
+	err := repository.db.QueryRow(`SELECT language FROM courses WHERE name = $1 AND department = $2 AND year = $3`,
+		course.Name, course.Department, course.Year).Scan(&language)
+	if err == sql.ErrNoRows {
+		return "", NotFoundError
+	}
+	if err != nil {
+		log.Println(err)
+		return "", UnknownError
+	}
+	return language.String, nil
+}
+
+// SetStudentLocale stores the preferred locale of the student, replacing the previous one
+func (repository *SqlRepository) SetStudentLocale(studentMail string, locale string) error {
+	studentMail = NormalizeMail(studentMail)
+	locale = NormalizeLocale(locale)
+	return repository.inTransaction(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`DELETE FROM student_locales WHERE student_mail = $1`, studentMail)
+		if err != nil || locale == "" {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO student_locales (student_mail, locale) VALUES ($1, $2)`, studentMail, locale)
+		return err
+	})
+}
+
+// Maximum number of mails looked up by a single query of GetStudentLocales, well below the limit on the number of
+// parameters of SQLite
+const maxLocalesPerQuery = 500
+
+// GetStudentLocales returns the preferred locales of the given students, querying them in groups of
+// maxLocalesPerQuery mails
+func (repository *SqlRepository) GetStudentLocales(studentMails []string) (map[string]string, error) {
+	locales := make(map[string]string)
+	for start := 0; start < len(studentMails); start += maxLocalesPerQuery {
+		end := start + maxLocalesPerQuery
+		if end > len(studentMails) {
+			end = len(studentMails)
+		}
+		placeholders := make([]string, 0, end-start)
+		args := make([]interface{}, 0, end-start)
+		for _, studentMail := range studentMails[start:end] {
+			args = append(args, NormalizeMail(studentMail))
+			placeholders = append(placeholders, "$"+strconv.Itoa(len(args)))
+		}
+		rows, err := repository.db.Query(`SELECT student_mail, locale FROM student_locales WHERE student_mail IN (`+
+			strings.Join(placeholders, ", ")+`)`, args...)
+		if err != nil {
+			log.Println(err)
+			return nil, UnknownError
+		}
+		for rows.Next() {
+			var studentMail, locale string
+			err = rows.Scan(&studentMail, &locale)
+			if err != nil {
+				rows.Close()
+				log.Println(err)
+				return nil, UnknownError
+			}
+			locales[studentMail] = locale
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			log.Println(err)
+			return nil, UnknownError
+		}
+	}
+	return locales, nil
+}
+
+// createDelivery inserts the delivery of the notification if it does not exist yet
+func createDelivery(tx *sql.Tx, notificationId string) error {
+	_, err := tx.Exec(`INSERT INTO deliveries (notification_id, expires_at) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+		notificationId, time.Now().Add(DeliveryRetention).Unix())
+	return err
+}
+
+// AddDeliveredRecipients records that the notification has been sent to the students
+func (repository *SqlRepository) AddDeliveredRecipients(notificationId string, studentMails []string) error {
+	return repository.inTransaction(func(tx *sql.Tx) error {
+		err := createDelivery(tx, notificationId)
+		if err != nil {
+			return err
+		}
+		statement, err := tx.Prepare(`INSERT INTO delivered_recipients (notification_id, student_mail) VALUES ($1, $2)
+			ON CONFLICT DO NOTHING`)
+		if err != nil {
+			return err
+		}
+		defer statement.Close()
+		for _, studentMail := range studentMails {
+			_, err = statement.Exec(notificationId, NormalizeMail(studentMail))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CompleteDelivery records that the notification has been sent to the whole mailing list and removes the expired
+// deliveries. The recipients are removed explicitly, because SQLite ignores the foreign keys unless they are enabled
+func (repository *SqlRepository) CompleteDelivery(notificationId string) error {
+	return repository.inTransaction(func(tx *sql.Tx) error {
+		err := createDelivery(tx, notificationId)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE deliveries SET completed = $1 WHERE notification_id = $2`, true, notificationId)
+		if err != nil {
+			return err
+		}
+		now := time.Now().Unix()
+		_, err = tx.Exec(`DELETE FROM delivered_recipients WHERE notification_id IN
+			(SELECT notification_id FROM deliveries WHERE expires_at < $1)`, now)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`DELETE FROM deliveries WHERE expires_at < $1`, now)
+		return err
+	})
+}
+
+// GetDelivery returns the delivery of the notification
+func (repository *SqlRepository) GetDelivery(notificationId string) (Delivery, error) {
+	var delivery Delivery
+	err := repository.db.QueryRow(`SELECT completed FROM deliveries WHERE notification_id = $1`,
+		notificationId).Scan(&delivery.Completed)
+	if err == sql.ErrNoRows {
+		return Delivery{}, nil
+	}
+	if err != nil {
+		log.Println(err)
+		return Delivery{}, UnknownError
+	}
+	rows, err := repository.db.Query(`SELECT student_mail FROM delivered_recipients WHERE notification_id = $1`,
+		notificationId)
+	if err != nil {
+		log.Println(err)
+		return Delivery{}, UnknownError
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var studentMail string
+		err = rows.Scan(&studentMail)
+		if err != nil {
+			log.Println(err)
+			return Delivery{}, UnknownError
+		}
+		delivery.Recipients = append(delivery.Recipients, studentMail)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Println(err)
+		return Delivery{}, UnknownError
+	}
+	return delivery, nil
+}
@@ -0,0 +1,80 @@
+package deadletter
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/redefik/notificationmanagement/config"
+	"github.com/redefik/notificationmanagement/sqswrapper"
+	"time"
+)
+
+/*This package keeps the notifications that cannot be sent, because they are not valid or because they refer to
+courses or attachments that do not exist. Those messages would be read from the queue again and again, so they are
+moved to a dead-letter store together with the reason of the failure, from which they can be sent back to the queue
+with the redrive command once the problem has been solved*/
+
+// Letter is a message removed from the notification queue because it could not be processed
+type Letter struct {
+	Id             string    `json:"id"`
+	Body           string    `json:"body"`                     // body of the original message
+	GroupId        string    `json:"groupId,omitempty"`        // MessageGroupId of the original message, if it was read from a FIFO queue
+	NotificationId string    `json:"notificationId,omitempty"` // id under which the delivery of the original message is recorded
+	Reason         string    `json:"reason"`
+	FailedAt       time.Time `json:"failedAt"`
+}
+
+// LetterStore keeps the dead letters until they are sent back to the queue
+type LetterStore interface {
+	// Put stores the letter
+	Put(letter Letter) error
+	// Process passes the stored letters, at most max of them when max is positive, to handle in the order in which they
+	// have been stored. The letters for which handle returns true are removed from the store. The processing stops at
+	// the first error returned by handle. It returns the number of letters handled
+	Process(max int, handle func(letter Letter) (bool, error)) (int, error)
+}
+
+// Store is the LetterStore used by the microservice. It is nil when no dead-letter store is configured, in which case
+// the invalid messages are only logged before being removed from the queue
+var Store LetterStore
+
+// NewLetter returns the letter for a message read from the queue that failed for the given reason
+func NewLetter(message *sqs.Message, reason string) Letter {
+	return Letter{
+		Id:             newLetterId(),
+		Body:           aws.StringValue(message.Body),
+		GroupId:        sqswrapper.GroupId(message),
+		NotificationId: sqswrapper.NotificationId(message),
+		Reason:         reason,
+		FailedAt:       time.Now().UTC(),
+	}
+}
+
+// newLetterId generates an id that sorts the letters by the time in which they are created
+func newLetterId() string {
+	randomBytes := make([]byte, 4)
+	rand.Read(randomBytes)
+	return time.Now().UTC().Format("20060102T150405.000000000") + "-" + hex.EncodeToString(randomBytes)
+}
+
+// InitializeStore sets up Store: the dead-letter queue named by config.Configuration.DeadLetterQueueName, that is
+// read and written with the provided client, or the directory config.Configuration.DeadLetterDirectory
+func InitializeStore(sqsClient *sqs.SQS) error {
+	if config.Configuration.DeadLetterQueueName != "" {
+		queueUrl, err := sqswrapper.GetMessageQueueUrl(sqsClient, config.Configuration.DeadLetterQueueName)
+		if err != nil {
+			return err
+		}
+		Store = NewSqsStore(sqsClient, queueUrl)
+		return nil
+	}
+	if config.Configuration.DeadLetterDirectory != "" {
+		directoryStore, err := NewDirectoryStore(config.Configuration.DeadLetterDirectory)
+		if err != nil {
+			return err
+		}
+		Store = directoryStore
+	}
+	return nil
+}
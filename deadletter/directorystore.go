@@ -0,0 +1,84 @@
+package deadletter
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*Dead letters stored as JSON files in a local directory, for the deployments without a dead-letter queue and for
+development. Each file is named after the id of its letter*/
+
+// DirectoryStore keeps each letter in a file of the directory
+type DirectoryStore struct {
+	directory string
+}
+
+// NewDirectoryStore returns a LetterStore that writes the letters inside the directory, that is created if needed
+func NewDirectoryStore(directory string) (*DirectoryStore, error) {
+	err := os.MkdirAll(directory, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &DirectoryStore{directory: directory}, nil
+}
+
+// Put writes the letter to a temporary file that is then renamed, so the readers never see a partial letter
+func (store *DirectoryStore) Put(letter Letter) error {
+	data, err := json.MarshalIndent(letter, "", "  ")
+	if err != nil {
+		return err
+	}
+	temporaryPath := filepath.Join(store.directory, "."+letter.Id+".tmp")
+	err = ioutil.WriteFile(temporaryPath, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(temporaryPath, filepath.Join(store.directory, letter.Id+".json"))
+}
+
+// Process reads the letters in the order of their ids, that is the order in which they have been stored
+func (store *DirectoryStore) Process(max int, handle func(letter Letter) (bool, error)) (int, error) {
+	files, err := ioutil.ReadDir(store.directory)
+	if err != nil {
+		return 0, err
+	}
+	var names []string
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") && !strings.HasPrefix(file.Name(), ".") {
+			names = append(names, file.Name())
+		}
+	}
+	sort.Strings(names)
+	handled := 0
+	for _, name := range names {
+		if max > 0 && handled >= max {
+			break
+		}
+		path := filepath.Join(store.directory, name)
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return handled, err
+		}
+		var letter Letter
+		err = json.Unmarshal(data, &letter)
+		if err != nil {
+			return handled, err
+		}
+		remove, err := handle(letter)
+		if err != nil {
+			return handled, err
+		}
+		handled++
+		if remove {
+			err = os.Remove(path)
+			if err != nil {
+				return handled, err
+			}
+		}
+	}
+	return handled, nil
+}
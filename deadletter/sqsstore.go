@@ -0,0 +1,116 @@
+package deadletter
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/redefik/notificationmanagement/sqswrapper"
+	"log"
+	"time"
+)
+
+/*Dead letters stored in an SQS queue. The body of each letter is the body of the original message, while the other
+fields are stored as message attributes*/
+
+// Names of the message attributes of the letters
+const (
+	letterIdAttribute             = "LetterId"
+	letterGroupIdAttribute        = "OriginalGroupId"
+	letterNotificationIdAttribute = "OriginalNotificationId"
+	letterReasonAttribute         = "Reason"
+	letterFailedAtAttribute       = "FailedAt"
+)
+
+// SqsStore keeps the letters in a dead-letter queue
+type SqsStore struct {
+	client   *sqs.SQS
+	queueUrl string
+}
+
+// NewSqsStore returns a LetterStore that sends the letters to the queue with the given url
+func NewSqsStore(client *sqs.SQS, queueUrl string) *SqsStore {
+	return &SqsStore{client: client, queueUrl: queueUrl}
+}
+
+// Put sends the letter to the dead-letter queue. The id of the letter is used as deduplication id when the queue is
+// a FIFO queue, the group of the original message is kept
+func (store *SqsStore) Put(letter Letter) error {
+	return sqswrapper.SendMessageToQueue(context.Background(), store.client, store.queueUrl, letter.Body, letter.GroupId, letter.Id, map[string]string{
+		letterIdAttribute:             letter.Id,
+		letterGroupIdAttribute:        letter.GroupId,
+		letterNotificationIdAttribute: letter.NotificationId,
+		letterReasonAttribute:         letter.Reason,
+		letterFailedAtAttribute:       letter.FailedAt.Format(time.RFC3339Nano),
+	})
+}
+
+// toLetter rebuilds the letter stored in a message of the dead-letter queue. The messages moved to the queue by an
+// SQS redrive policy have no attributes, so their id is the id of the message, that SQS keeps when it moves them
+func toLetter(message *sqs.Message) Letter {
+	letter := Letter{
+		Id:             sqswrapper.MessageAttribute(message, letterIdAttribute),
+		Body:           aws.StringValue(message.Body),
+		GroupId:        sqswrapper.MessageAttribute(message, letterGroupIdAttribute),
+		Reason:         sqswrapper.MessageAttribute(message, letterReasonAttribute),
+		NotificationId: sqswrapper.MessageAttribute(message, letterNotificationIdAttribute),
+	}
+	if letter.Id == "" {
+		letter.Id = aws.StringValue(message.MessageId)
+		letter.GroupId = sqswrapper.GroupId(message)
+		letter.NotificationId = sqswrapper.NotificationId(message)
+		letter.Reason = "moved by the redrive policy of the queue"
+	}
+	letter.FailedAt, _ = time.Parse(time.RFC3339Nano, sqswrapper.MessageAttribute(message, letterFailedAtAttribute))
+	return letter
+}
+
+// Process reads the letters from the dead-letter queue until it is empty. The letters that are not removed are made
+// visible again at the end, so a letter is never handled twice by the same call
+func (store *SqsStore) Process(max int, handle func(letter Letter) (bool, error)) (int, error) {
+	var keptMessages []*string
+	defer func() {
+		for _, receiptHandle := range keptMessages {
+			err := sqswrapper.ReleaseMessage(context.Background(), store.client, store.queueUrl, receiptHandle)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}()
+	handled := 0
+	for max <= 0 || handled < max {
+		// a short polling may return no message even if the queue is not empty, so the call waits for a second
+		messages, err := sqswrapper.ReceiveMessagesFromQueue(context.Background(), store.client, store.queueUrl, 10, 1, 0)
+		if err != nil {
+			return handled, err
+		}
+		if len(messages) == 0 {
+			return handled, nil
+		}
+		for i, message := range messages {
+			if max > 0 && handled >= max {
+				// the messages received beyond the limit are left to the next call
+				for _, remainingMessage := range messages[i:] {
+					keptMessages = append(keptMessages, remainingMessage.ReceiptHandle)
+				}
+				break
+			}
+			remove, err := handle(toLetter(message))
+			if err != nil {
+				for _, remainingMessage := range messages[i:] {
+					keptMessages = append(keptMessages, remainingMessage.ReceiptHandle)
+				}
+				return handled, err
+			}
+			handled++
+			if !remove {
+				keptMessages = append(keptMessages, message.ReceiptHandle)
+				continue
+			}
+			err = sqswrapper.DeleteMessageFromQueue(context.Background(), store.client, store.queueUrl, message.ReceiptHandle)
+			if err != nil {
+				return handled, err
+			}
+		}
+	}
+	return handled, nil
+}
@@ -9,10 +9,25 @@ type Course struct {
 	Year       string `json:"year"`
 }
 
-// Encapsulates the fields of the notifications read by the sender thread from the SQS queue
+// Encapsulates the fields of the notifications read by the sender thread from the SQS queue. Message is written in
+// the default language of the course, while Messages contains the translations of the body indexed by language
+// (e.g. "en", "it"). Format tells how the bodies are written: "plain" (default) or "markdown"
 type Notification struct {
-	Name       string `json:"name"`
-	Department string `json:"department"`
-	Year       string `json:"year"`
-	Message    string `json:"message"`
+	Name        string            `json:"name"`
+	Department  string            `json:"department"`
+	Year        string            `json:"year"`
+	Message     string            `json:"message"`
+	Messages    map[string]string `json:"messages,omitempty"`
+	Format      string            `json:"format,omitempty"`
+	Attachments []Attachment      `json:"attachments,omitempty"`
+}
+
+// Encapsulates a file attached to a notification. The file is either an object of the attachment bucket, identified
+// by Key, or provided inline in Content encoded in base64. Data contains the bytes of the file once it has been read
+type Attachment struct {
+	FileName    string `json:"fileName"`
+	ContentType string `json:"contentType,omitempty"`
+	Key         string `json:"key,omitempty"`
+	Content     string `json:"content,omitempty"`
+	Data        []byte `json:"-"`
 }
@@ -0,0 +1,320 @@
+package mailtemplate
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+/*This package renders locally the mail templates uploaded to Amazon SES, so the mails can be built by the senders
+that do not use SES and previewed before being sent. It supports the subset of the Handlebars syntax used by the
+templates:
+- {{name}} inserts a parameter, HTML-escaped when the part is HTML, and {{{name}}} inserts it as it is
+- {{#if name}} ... {{else}} ... {{/if}} and {{#unless name}} ... {{/unless}} render a block conditionally. A parameter
+  is false when it is missing, false, zero, an empty string or an empty list
+- {{#each name}} ... {{else}} ... {{/each}} renders the block for each element of a list, that can be referenced as
+  {{this}}; {{@index}} is the position of the element
+- {{! comment }} and {{!-- comment --}} are ignored
+Nested parameters are referenced with dots (e.g. {{course.name}}). Inside a block, the names that are not found in the
+current element are looked up in the enclosing ones*/
+
+// node is an element of a parsed template
+type node interface {
+	render(builder *strings.Builder, scope *scope, escapeHtml bool)
+}
+
+// textNode is a piece of text copied as it is
+type textNode string
+
+// variableNode inserts the value of a parameter
+type variableNode struct {
+	path string
+	raw  bool // true for {{{name}}}, that is never escaped
+}
+
+// conditionalNode renders the first or the second list of nodes according to the value of a parameter
+type conditionalNode struct {
+	path      string
+	negate    bool // true for {{#unless}}
+	then      []node
+	otherwise []node
+}
+
+// eachNode renders its body for each element of a list, or the otherwise nodes when the list is empty
+type eachNode struct {
+	path      string
+	body      []node
+	otherwise []node
+}
+
+// Template is a parsed template part
+type Template struct {
+	nodes []node
+}
+
+// scope is the context in which the names are resolved: the current value and the enclosing scopes
+type scope struct {
+	value  interface{}
+	index  int // position of the value in the list iterated by {{#each}}
+	parent *scope
+}
+
+// Parse compiles the source of a template part. It returns an error describing the position of the first syntax error
+func Parse(source string) (*Template, error) {
+	parser := &parser{source: source}
+	nodes, closingTag, err := parser.parseNodes()
+	if err != nil {
+		return nil, err
+	}
+	if closingTag != "" {
+		return nil, parser.errorf("unexpected {{%s}}", closingTag)
+	}
+	return &Template{nodes: nodes}, nil
+}
+
+// MustParse is as Parse but it panics on error. It is meant for the templates defined in the code
+func MustParse(source string) *Template {
+	template, err := Parse(source)
+	if err != nil {
+		panic(err)
+	}
+	return template
+}
+
+// Render executes the template with the provided data, usually a map[string]interface{}. When escapeHtml is true
+// the values inserted by {{name}} are HTML-escaped
+func (template *Template) Render(data interface{}, escapeHtml bool) string {
+	var builder strings.Builder
+	renderNodes(template.nodes, &builder, &scope{value: data}, escapeHtml)
+	return builder.String()
+}
+
+func renderNodes(nodes []node, builder *strings.Builder, scope *scope, escapeHtml bool) {
+	for _, node := range nodes {
+		node.render(builder, scope, escapeHtml)
+	}
+}
+
+func (node textNode) render(builder *strings.Builder, _ *scope, _ bool) {
+	builder.WriteString(string(node))
+}
+
+func (node variableNode) render(builder *strings.Builder, scope *scope, escapeHtml bool) {
+	value := formatValue(scope.lookup(node.path))
+	if escapeHtml && !node.raw {
+		value = html.EscapeString(value)
+	}
+	builder.WriteString(value)
+}
+
+func (node conditionalNode) render(builder *strings.Builder, scope *scope, escapeHtml bool) {
+	if isTruthy(scope.lookup(node.path)) != node.negate {
+		renderNodes(node.then, builder, scope, escapeHtml)
+	} else {
+		renderNodes(node.otherwise, builder, scope, escapeHtml)
+	}
+}
+
+func (node eachNode) render(builder *strings.Builder, currentScope *scope, escapeHtml bool) {
+	list := reflect.ValueOf(currentScope.lookup(node.path))
+	if (list.Kind() != reflect.Slice && list.Kind() != reflect.Array) || list.Len() == 0 {
+		renderNodes(node.otherwise, builder, currentScope, escapeHtml)
+		return
+	}
+	for i := 0; i < list.Len(); i++ {
+		elementScope := &scope{value: list.Index(i).Interface(), index: i, parent: currentScope}
+		renderNodes(node.body, builder, elementScope, escapeHtml)
+	}
+}
+
+// lookup resolves a dotted path starting from the scope and then from the enclosing ones
+func (currentScope *scope) lookup(path string) interface{} {
+	switch path {
+	case "this", ".":
+		return currentScope.value
+	case "@index":
+		return currentScope.index
+	}
+	components := strings.Split(strings.TrimPrefix(path, "this."), ".")
+	for s := currentScope; s != nil; s = s.parent {
+		value, ok := field(s.value, components[0])
+		if !ok {
+			continue
+		}
+		for _, component := range components[1:] {
+			value, _ = field(value, component)
+		}
+		return value
+	}
+	return nil
+}
+
+// field returns the element of a map with the given key
+func field(value interface{}, name string) (interface{}, bool) {
+	reflectValue := reflect.ValueOf(value)
+	if reflectValue.Kind() != reflect.Map || reflectValue.Type().Key().Kind() != reflect.String {
+		return nil, false
+	}
+	element := reflectValue.MapIndex(reflect.ValueOf(name).Convert(reflectValue.Type().Key()))
+	if !element.IsValid() {
+		return nil, false
+	}
+	return element.Interface(), true
+}
+
+// isTruthy tells if a value satisfies {{#if}}
+func isTruthy(value interface{}) bool {
+	if value == nil {
+		return false
+	}
+	reflectValue := reflect.ValueOf(value)
+	switch reflectValue.Kind() {
+	case reflect.Bool:
+		return reflectValue.Bool()
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return reflectValue.Len() > 0
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return reflectValue.Int() != 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return reflectValue.Uint() != 0
+	case reflect.Float32, reflect.Float64:
+		return reflectValue.Float() != 0
+	}
+	return true
+}
+
+// formatValue converts a value to the text inserted in the mail
+func formatValue(value interface{}) string {
+	switch typedValue := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return typedValue
+	case float64:
+		// the numbers decoded from JSON are float64, written without a useless fractional part
+		return strconv.FormatFloat(typedValue, 'f', -1, 64)
+	default:
+		return fmt.Sprint(typedValue)
+	}
+}
+
+// parser builds the nodes of a template reading its source from left to right
+type parser struct {
+	source   string
+	position int
+}
+
+func (parser *parser) errorf(format string, arguments ...interface{}) error {
+	return errors.New("template: " + fmt.Sprintf(format, arguments...) + " at offset " + strconv.Itoa(parser.position))
+}
+
+// parseNodes reads nodes until the end of the source or until a {{else}} or closing tag, that is returned
+func (parser *parser) parseNodes() ([]node, string, error) {
+	var nodes []node
+	for parser.position < len(parser.source) {
+		start := strings.Index(parser.source[parser.position:], "{{")
+		if start < 0 {
+			nodes = append(nodes, textNode(parser.source[parser.position:]))
+			parser.position = len(parser.source)
+			break
+		}
+		if start > 0 {
+			nodes = append(nodes, textNode(parser.source[parser.position:parser.position+start]))
+			parser.position += start
+		}
+		node, tag, err := parser.parseTag()
+		if err != nil {
+			return nil, "", err
+		}
+		if tag != "" {
+			// {{else}} or a closing tag, that is checked by the caller
+			return nodes, tag, nil
+		}
+		if node != nil {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes, "", nil
+}
+
+// readUntil returns the text preceding the delimiter and moves after the delimiter
+func (parser *parser) readUntil(delimiter string) (string, error) {
+	end := strings.Index(parser.source[parser.position:], delimiter)
+	if end < 0 {
+		return "", parser.errorf("unclosed tag")
+	}
+	text := parser.source[parser.position : parser.position+end]
+	parser.position += end + len(delimiter)
+	return text, nil
+}
+
+// parseTag parses the tag starting at the current position. It returns the node of the tag, or the content of the
+// tag when it is {{else}} or a closing tag
+func (parser *parser) parseTag() (node, string, error) {
+	if strings.HasPrefix(parser.source[parser.position:], "{{{") {
+		parser.position += 3
+		path, err := parser.readUntil("}}}")
+		if err != nil {
+			return nil, "", err
+		}
+		return variableNode{path: strings.TrimSpace(path), raw: true}, "", nil
+	}
+	if strings.HasPrefix(parser.source[parser.position:], "{{!--") {
+		parser.position += 5
+		_, err := parser.readUntil("--}}")
+		return nil, "", err
+	}
+	parser.position += 2
+	content, err := parser.readUntil("}}")
+	if err != nil {
+		return nil, "", err
+	}
+	content = strings.TrimSpace(content)
+	switch {
+	case strings.HasPrefix(content, "!"):
+		return nil, "", nil
+	case content == "else" || strings.HasPrefix(content, "/"):
+		return nil, content, nil
+	case strings.HasPrefix(content, "#"):
+		return parser.parseBlock(content[1:])
+	case content == "":
+		return nil, "", parser.errorf("empty tag")
+	}
+	return variableNode{path: content}, "", nil
+}
+
+// parseBlock parses the body of a block helper, up to its closing tag
+func (parser *parser) parseBlock(opening string) (node, string, error) {
+	fields := strings.Fields(opening)
+	if len(fields) != 2 {
+		return nil, "", parser.errorf("malformed block {{#%s}}", opening)
+	}
+	helper, path := fields[0], fields[1]
+	if helper != "if" && helper != "unless" && helper != "each" {
+		return nil, "", parser.errorf("unknown block helper %s", helper)
+	}
+	body, tag, err := parser.parseNodes()
+	if err != nil {
+		return nil, "", err
+	}
+	var otherwise []node
+	if tag == "else" {
+		otherwise, tag, err = parser.parseNodes()
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	if tag != "/"+helper {
+		if tag == "" {
+			return nil, "", parser.errorf("unclosed block {{#%s}}", helper)
+		}
+		return nil, "", parser.errorf("unexpected {{%s}} closing {{#%s}}", tag, helper)
+	}
+	if helper == "each" {
+		return eachNode{path: path, body: body, otherwise: otherwise}, "", nil
+	}
+	return conditionalNode{path: path, negate: helper == "unless", then: body, otherwise: otherwise}, "", nil
+}
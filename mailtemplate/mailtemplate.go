@@ -0,0 +1,138 @@
+package mailtemplate
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// DefaultFile is the template read when no file is configured
+const DefaultFile = "config/templates/mailtemplate.json"
+
+// DefaultDirectory is the directory of the templates uploaded to SES when no directory is configured
+const DefaultDirectory = "config/templates"
+
+// MailTemplate contains the parts of a mail template, as defined in the files passed to "aws ses create-template"
+type MailTemplate struct {
+	TemplateName string
+	SubjectPart  string
+	HtmlPart     string
+	TextPart     string
+
+	subject *Template
+	html    *Template
+	text    *Template
+}
+
+// RenderedMail contains the parts of a mail built from a template
+type RenderedMail struct {
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	Html    string `json:"html"`
+}
+
+// Compile parses the parts of the template. It must be called before Render when the template is not read by Load
+func (mailTemplate *MailTemplate) Compile() error {
+	var err error
+	if mailTemplate.subject, err = Parse(mailTemplate.SubjectPart); err != nil {
+		return err
+	}
+	if mailTemplate.html, err = Parse(mailTemplate.HtmlPart); err != nil {
+		return err
+	}
+	mailTemplate.text, err = Parse(mailTemplate.TextPart)
+	return err
+}
+
+// Load reads and compiles the template from a JSON file like config/templates/mailtemplate.json. An empty path
+// selects DefaultFile
+func Load(path string) (*MailTemplate, error) {
+	if path == "" {
+		path = DefaultFile
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var templateFile struct {
+		Template *MailTemplate
+	}
+	err = json.Unmarshal(content, &templateFile)
+	if err != nil {
+		return nil, err
+	}
+	if templateFile.Template == nil {
+		templateFile.Template = &MailTemplate{}
+	}
+	err = templateFile.Template.Compile()
+	if err != nil {
+		return nil, err
+	}
+	return templateFile.Template, nil
+}
+
+// Render builds the mail with the provided data. Only the HtmlPart is HTML-escaped, since the subject and the
+// TextPart are plain text
+func (mailTemplate *MailTemplate) Render(data interface{}) RenderedMail {
+	return RenderedMail{
+		Subject: mailTemplate.subject.Render(data, false),
+		Text:    mailTemplate.text.Render(data, false),
+		Html:    mailTemplate.html.Render(data, true),
+	}
+}
+
+// LoadDirectory reads every template file (*.json) of the directory, indexing the templates by TemplateName. An empty
+// path selects DefaultDirectory. It returns an error if a template is not valid or if two files define the same name
+func LoadDirectory(directory string) (map[string]*MailTemplate, error) {
+	if directory == "" {
+		directory = DefaultDirectory
+	}
+	files, err := filepath.Glob(filepath.Join(directory, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	templates := make(map[string]*MailTemplate)
+	for _, file := range files {
+		mailTemplate, err := Load(file)
+		if err != nil {
+			return nil, errors.New(file + ": " + err.Error())
+		}
+		if mailTemplate.TemplateName == "" {
+			return nil, errors.New(file + ": missing TemplateName")
+		}
+		if _, ok := templates[mailTemplate.TemplateName]; ok {
+			return nil, errors.New(file + ": duplicated template " + mailTemplate.TemplateName)
+		}
+		templates[mailTemplate.TemplateName] = mailTemplate
+	}
+	return templates, nil
+}
+
+// Set contains the templates available to the senders that render the mails locally
+type Set struct {
+	Default *MailTemplate            // template used when no template is requested or the requested one is unknown
+	Named   map[string]*MailTemplate // templates indexed by TemplateName
+}
+
+// LoadSet reads the default template from file and the named templates from directory. Empty paths select
+// DefaultFile and DefaultDirectory
+func LoadSet(file string, directory string) (Set, error) {
+	defaultTemplate, err := Load(file)
+	if err != nil {
+		return Set{}, err
+	}
+	named, err := LoadDirectory(directory)
+	if err != nil {
+		return Set{}, err
+	}
+	return Set{Default: defaultTemplate, Named: named}, nil
+}
+
+// Get returns the template with the given name, or the default template if the set does not contain it
+func (set Set) Get(name string) *MailTemplate {
+	if mailTemplate, ok := set.Named[name]; ok {
+		return mailTemplate
+	}
+	return set.Default
+}
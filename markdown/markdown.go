@@ -0,0 +1,222 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*This package converts the bodies of the notifications written in Markdown to HTML and to plain text. Only the subset
+of the syntax that is useful in a notice is supported: paragraphs, headings, unordered and ordered lists, emphasis,
+code spans and links. Any other markup, including raw HTML, is shown as text: every character of the source is
+escaped, and the links are kept only when they point to http, https or mailto addresses, so the rendered HTML is safe
+to be sent to the students*/
+
+var headingPattern = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+var unorderedItemPattern = regexp.MustCompile(`^\s{0,3}[-*+]\s+(.*)$`)
+var orderedItemPattern = regexp.MustCompile(`^\s{0,3}(\d{1,9})[.)]\s+(.*)$`)
+
+// Kinds of block
+const (
+	paragraphBlock = iota
+	headingBlock
+	unorderedListBlock
+	orderedListBlock
+)
+
+// block is a top level element of the document. The lines of a paragraph and the items of a list are kept apart
+type block struct {
+	kind  int
+	level int      // level of a heading
+	start int      // number of the first item of an ordered list
+	lines []string // lines of a paragraph, text of a heading or items of a list
+}
+
+// Render returns the HTML and the plain text versions of the Markdown source
+func Render(source string) (string, string) {
+	blocks := parseBlocks(source)
+	var htmlParts, textParts []string
+	for _, b := range blocks {
+		htmlPart, textPart := b.render()
+		htmlParts = append(htmlParts, htmlPart)
+		textParts = append(textParts, textPart)
+	}
+	return strings.Join(htmlParts, "\n"), strings.Join(textParts, "\n\n")
+}
+
+// parseBlocks splits the source in blocks. Blank lines end paragraphs and lists; a line that does not start a new
+// item continues the last item of a list
+func parseBlocks(source string) []block {
+	var blocks []block
+	var current *block
+	for _, line := range strings.Split(strings.Replace(source, "\r\n", "\n", -1), "\n") {
+		if strings.TrimSpace(line) == "" {
+			current = nil
+			continue
+		}
+		if match := headingPattern.FindStringSubmatch(line); match != nil {
+			blocks = append(blocks, block{kind: headingBlock, level: len(match[1]), lines: []string{match[2]}})
+			current = nil
+			continue
+		}
+		if match := unorderedItemPattern.FindStringSubmatch(line); match != nil {
+			if current == nil || current.kind != unorderedListBlock {
+				blocks = append(blocks, block{kind: unorderedListBlock})
+				current = &blocks[len(blocks)-1]
+			}
+			current.lines = append(current.lines, match[1])
+			continue
+		}
+		if match := orderedItemPattern.FindStringSubmatch(line); match != nil {
+			if current == nil || current.kind != orderedListBlock {
+				start, _ := strconv.Atoi(match[1])
+				blocks = append(blocks, block{kind: orderedListBlock, start: start})
+				current = &blocks[len(blocks)-1]
+			}
+			current.lines = append(current.lines, match[2])
+			continue
+		}
+		if current == nil {
+			blocks = append(blocks, block{kind: paragraphBlock})
+			current = &blocks[len(blocks)-1]
+		}
+		if current.kind == paragraphBlock {
+			current.lines = append(current.lines, strings.TrimSpace(line))
+		} else {
+			// continuation of the last item
+			current.lines[len(current.lines)-1] += " " + strings.TrimSpace(line)
+		}
+	}
+	return blocks
+}
+
+// render converts the block to HTML and to plain text
+func (b block) render() (string, string) {
+	switch b.kind {
+	case headingBlock:
+		htmlText, text := renderInline(b.lines[0])
+		tag := "h" + strconv.Itoa(b.level)
+		return "<" + tag + ">" + htmlText + "</" + tag + ">", text
+	case unorderedListBlock, orderedListBlock:
+		tag := "ul"
+		if b.kind == orderedListBlock {
+			tag = "ol"
+		}
+		htmlList := "<" + tag
+		if b.kind == orderedListBlock && b.start != 1 {
+			htmlList += ` start="` + strconv.Itoa(b.start) + `"`
+		}
+		htmlList += ">\n"
+		var textItems []string
+		for i, item := range b.lines {
+			htmlItem, textItem := renderInline(item)
+			htmlList += "<li>" + htmlItem + "</li>\n"
+			marker := "-"
+			if b.kind == orderedListBlock {
+				marker = strconv.Itoa(b.start+i) + "."
+			}
+			textItems = append(textItems, marker+" "+textItem)
+		}
+		return htmlList + "</" + tag + ">", strings.Join(textItems, "\n")
+	default:
+		var htmlLines, textLines []string
+		for _, line := range b.lines {
+			htmlLine, textLine := renderInline(line)
+			htmlLines = append(htmlLines, htmlLine)
+			textLines = append(textLines, textLine)
+		}
+		// the line breaks written by the professor are kept, as in the plain text notifications
+		return "<p>" + strings.Join(htmlLines, "<br>\n") + "</p>", strings.Join(textLines, "\n")
+	}
+}
+
+// isSafeUrl tells if a link can be included in the mail
+func isSafeUrl(url string) bool {
+	lowerUrl := strings.ToLower(url)
+	return strings.HasPrefix(lowerUrl, "http://") || strings.HasPrefix(lowerUrl, "https://") ||
+		strings.HasPrefix(lowerUrl, "mailto:")
+}
+
+// renderInline converts the inline markup of a line to HTML and to plain text. The markers that are not closed are
+// shown as they are
+func renderInline(source string) (string, string) {
+	var htmlText, text strings.Builder
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case c == '\\' && i+1 < len(source) && strings.IndexByte("\\`*_[]()#+-.!", source[i+1]) >= 0:
+			// escaped punctuation is shown literally
+			htmlText.WriteString(html.EscapeString(source[i+1 : i+2]))
+			text.WriteByte(source[i+1])
+			i += 2
+			continue
+		case c == '`':
+			if end := strings.IndexByte(source[i+1:], '`'); end >= 0 {
+				code := source[i+1 : i+1+end]
+				htmlText.WriteString("<code>" + html.EscapeString(code) + "</code>")
+				text.WriteString(code)
+				i += end + 2
+				continue
+			}
+		case c == '[':
+			if label, url, length, ok := parseLink(source[i:]); ok && isSafeUrl(url) {
+				htmlLabel, textLabel := renderInline(label)
+				htmlText.WriteString(`<a href="` + html.EscapeString(url) + `">` + htmlLabel + "</a>")
+				if textLabel == url || "mailto:"+textLabel == url {
+					text.WriteString(textLabel)
+				} else {
+					text.WriteString(textLabel + " (" + strings.TrimPrefix(url, "mailto:") + ")")
+				}
+				i += length
+				continue
+			}
+		case (c == '*' || c == '_') && strings.HasPrefix(source[i:], strings.Repeat(string(c), 2)):
+			delimiter := strings.Repeat(string(c), 2)
+			if end := strings.Index(source[i+2:], delimiter); end > 0 && source[i+2] != ' ' {
+				htmlInner, textInner := renderInline(source[i+2 : i+2+end])
+				htmlText.WriteString("<strong>" + htmlInner + "</strong>")
+				text.WriteString(textInner)
+				i += end + 4
+				continue
+			}
+		case c == '*' || c == '_':
+			// an underscore inside a word, as in snake_case, is not a marker
+			if c == '_' && i > 0 && isWordCharacter(source[i-1]) {
+				break
+			}
+			if end := strings.IndexByte(source[i+1:], c); end > 0 && source[i+1] != ' ' {
+				htmlInner, textInner := renderInline(source[i+1 : i+1+end])
+				htmlText.WriteString("<em>" + htmlInner + "</em>")
+				text.WriteString(textInner)
+				i += end + 2
+				continue
+			}
+		}
+		htmlText.WriteString(html.EscapeString(source[i : i+1]))
+		text.WriteByte(c)
+		i++
+	}
+	return htmlText.String(), text.String()
+}
+
+// parseLink reads a link in the form [label](url) at the beginning of the source, returning its length
+func parseLink(source string) (string, string, int, bool) {
+	labelEnd := strings.Index(source, "](")
+	if labelEnd < 0 {
+		return "", "", 0, false
+	}
+	urlEnd := strings.IndexByte(source[labelEnd+2:], ')')
+	if urlEnd < 0 {
+		return "", "", 0, false
+	}
+	url := strings.TrimSpace(source[labelEnd+2 : labelEnd+2+urlEnd])
+	if url == "" || strings.ContainsAny(url, " \t") {
+		return "", "", 0, false
+	}
+	return source[1:labelEnd], url, labelEnd + 3 + urlEnd, true
+}
+
+func isWordCharacter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
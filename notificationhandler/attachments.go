@@ -0,0 +1,172 @@
+package notificationhandler
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/redefik/notificationmanagement/config"
+	"github.com/redefik/notificationmanagement/entity"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"path"
+	"strings"
+)
+
+/*Files attached to the notifications. The files are read from the attachment bucket on S3 or decoded from the
+notification itself before the sending, so that every sender receives their content. The notifications with
+attachments cannot be sent with the SES templates, so the SES sender builds their mails locally*/
+
+// Default limit of the total size of the attachments of a notification. SES does not accept raw mails larger than
+// 10 MB, and the base64 encoding of the attachments makes them a third larger
+const defaultMaxAttachmentSize = 7 * 1024 * 1024
+
+// S3Client reads the attachments from the attachment bucket. It is nil when no bucket is configured
+var S3Client *s3.S3
+
+// InvalidAttachmentError is returned when an attachment has no name, is not found or cannot be decoded
+var InvalidAttachmentError = errors.New("invalid attachment")
+
+// AttachmentTooLargeError is returned when the attachments of a notification exceed the configured size
+var AttachmentTooLargeError = errors.New("the attachments exceed the maximum size")
+
+// initializeS3Client instantiates the client of the attachment bucket, when one is configured
+func initializeS3Client() {
+	if config.Configuration.AttachmentBucket == "" {
+		return
+	}
+	region := config.Configuration.AwsS3Region
+	if region == "" {
+		region = config.Configuration.AwsSesRegion
+	}
+	newSession := session.Must(session.NewSession(&aws.Config{
+		Region: aws.String(region),
+	}))
+	S3Client = s3.New(newSession)
+}
+
+// maxAttachmentSize returns the configured limit of the total size of the attachments
+func maxAttachmentSize() int64 {
+	if config.Configuration.MaxAttachmentSize > 0 {
+		return config.Configuration.MaxAttachmentSize
+	}
+	return defaultMaxAttachmentSize
+}
+
+// sanitizeFileName keeps the last element of the name, without the characters that would break the MIME headers
+func sanitizeFileName(fileName string) string {
+	fileName = path.Base(strings.Replace(strings.TrimSpace(fileName), "\\", "/", -1))
+	fileName = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f || r == '"' {
+			return -1
+		}
+		return r
+	}, fileName)
+	if fileName == "." || fileName == "/" {
+		return ""
+	}
+	return fileName
+}
+
+// readAttachmentObject reads the object with the given key from the attachment bucket, refusing the objects larger
+// than limit. It returns the content and the content type of the object
+func readAttachmentObject(ctx context.Context, key string, limit int64) ([]byte, string, error) {
+	if S3Client == nil {
+		log.Println("attachment", key, "requested but no attachment bucket is configured")
+		return nil, "", InvalidAttachmentError
+	}
+	getObjectOutput, err := S3Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(config.Configuration.AttachmentBucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if awsError, ok := err.(awserr.Error); ok && awsError.Code() == s3.ErrCodeNoSuchKey {
+			log.Println("attachment", key, "not found")
+			return nil, "", InvalidAttachmentError
+		}
+		return nil, "", err
+	}
+	defer getObjectOutput.Body.Close()
+	if aws.Int64Value(getObjectOutput.ContentLength) > limit {
+		return nil, "", AttachmentTooLargeError
+	}
+	// the length is checked again while reading, in case it has not been returned
+	data, err := ioutil.ReadAll(io.LimitReader(getObjectOutput.Body, limit+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(len(data)) > limit {
+		return nil, "", AttachmentTooLargeError
+	}
+	return data, aws.StringValue(getObjectOutput.ContentType), nil
+}
+
+// LoadAttachments returns the notification with the content of every attachment read into Data. The attachments
+// already read are kept as they are. InvalidAttachmentError and AttachmentTooLargeError are returned for the
+// notifications that cannot be sent with their attachments, any other error is returned when the bucket cannot be read
+func LoadAttachments(ctx context.Context, message entity.Notification) (entity.Notification, error) {
+	if len(message.Attachments) == 0 {
+		return message, nil
+	}
+	limit := maxAttachmentSize()
+	var totalSize int64
+	attachments := make([]entity.Attachment, 0, len(message.Attachments))
+	for _, attachment := range message.Attachments {
+		attachment.FileName = sanitizeFileName(attachment.FileName)
+		if attachment.FileName == "" {
+			log.Println("attachment without name")
+			return message, InvalidAttachmentError
+		}
+		if attachment.Data == nil {
+			if (attachment.Key == "") == (attachment.Content == "") {
+				log.Println("attachment", attachment.FileName, "must have either a key or a content")
+				return message, InvalidAttachmentError
+			}
+			if attachment.Key != "" {
+				data, contentType, err := readAttachmentObject(ctx, attachment.Key, limit-totalSize)
+				if err != nil {
+					return message, err
+				}
+				attachment.Data = data
+				if attachment.ContentType == "" {
+					attachment.ContentType = contentType
+				}
+			} else {
+				// the size is checked before decoding, the padding makes the content up to two bytes shorter
+				if int64(base64.StdEncoding.DecodedLen(len(attachment.Content)))-2 > limit-totalSize {
+					return message, AttachmentTooLargeError
+				}
+				data, err := base64.StdEncoding.DecodeString(attachment.Content)
+				if err != nil {
+					log.Println("attachment", attachment.FileName, "is not valid base64:", err)
+					return message, InvalidAttachmentError
+				}
+				attachment.Data = data
+				attachment.Content = ""
+			}
+		}
+		totalSize += int64(len(attachment.Data))
+		if totalSize > limit {
+			return message, AttachmentTooLargeError
+		}
+		if attachment.ContentType == "" {
+			attachment.ContentType = mime.TypeByExtension(path.Ext(attachment.FileName))
+		}
+		// the content type is written in the headers of the mail, so it is formatted again after parsing it
+		mediaType, parameters, err := mime.ParseMediaType(attachment.ContentType)
+		if err == nil {
+			attachment.ContentType = mime.FormatMediaType(mediaType, parameters)
+		}
+		if err != nil || attachment.ContentType == "" {
+			attachment.ContentType = "application/octet-stream"
+		}
+		attachments = append(attachments, attachment)
+	}
+	message.Attachments = attachments
+	return message, nil
+}
@@ -0,0 +1,85 @@
+package notificationhandler
+
+import (
+	"context"
+	"github.com/redefik/notificationmanagement/entity"
+	"github.com/redefik/notificationmanagement/mailtemplate"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*Sender that does not deliver the mails but writes them, in the .eml format, to a directory or to the standard
+output. It is meant for development and for the end-to-end tests, that can read the sent mails from the directory*/
+
+// FileSender renders the template like the SMTP sender and writes a mail for each recipient
+type FileSender struct {
+	directory     string    // directory of the .eml files, the mails are written to output when it is empty
+	output        io.Writer // destination of the mails when no directory is set
+	mailTemplates mailtemplate.Set
+	mutex         sync.Mutex // serializes the writes to output
+}
+
+// NewFileSender returns a Sender that writes the mails as .eml files inside the directory, that is created if
+// needed. When the directory is empty the mails are written to the standard output
+func NewFileSender(directory string, mailTemplates mailtemplate.Set) (*FileSender, error) {
+	if directory != "" {
+		err := os.MkdirAll(directory, 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &FileSender{directory: directory, output: os.Stdout, mailTemplates: mailTemplates}, nil
+}
+
+// writeMail stores a mail. Each file is first written with a temporary name and then renamed, so the readers of the
+// directory never see a partial mail
+func (sender *FileSender) writeMail(messageId string, data []byte) error {
+	if sender.directory == "" {
+		sender.mutex.Lock()
+		defer sender.mutex.Unlock()
+		_, err := sender.output.Write(append(data, '\r', '\n'))
+		return err
+	}
+	// the files are named after the time of the sending, so they are listed in order
+	name := time.Now().UTC().Format("20060102T150405.000000000") + "-" + strings.Trim(strings.Split(messageId, "@")[0], "<")
+	temporaryPath := filepath.Join(sender.directory, "."+name+".tmp")
+	err := ioutil.WriteFile(temporaryPath, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(temporaryPath, filepath.Join(sender.directory, name+".eml"))
+}
+
+// SendNotification renders the template with the content of the notification and writes a mail, together with the
+// attachments, for each recipient
+func (sender *FileSender) SendNotification(ctx context.Context, message entity.Notification, templateName string, from string, to []string) (DeliveryResult, error) {
+	message, err := LoadAttachments(ctx, message)
+	if err != nil {
+		return DeliveryResult{}, err
+	}
+	mail := newMailMessage(renderNotification(sender.mailTemplates.Get(templateName), message), message, from)
+	result := DeliveryResult{Delivered: make(map[string]string), Failed: make(map[string]string)}
+	for _, recipient := range to {
+		if ctx.Err() != nil {
+			result.Failed[recipient] = ctx.Err().Error()
+			continue
+		}
+		messageId := newMessageId(from)
+		mail.To = recipient
+		err := sender.writeMail(messageId, mail.encode(messageId, time.Now()))
+		if err != nil {
+			result.Failed[recipient] = err.Error()
+			continue
+		}
+		result.Delivered[recipient] = messageId
+	}
+	if len(result.Failed) > 0 && len(result.Delivered) == 0 {
+		return result, NotDeliveredError
+	}
+	return result, nil
+}
@@ -0,0 +1,188 @@
+package notificationhandler
+
+import (
+	"context"
+	"github.com/redefik/notificationmanagement/config"
+	"github.com/redefik/notificationmanagement/coursehandler"
+	"github.com/redefik/notificationmanagement/entity"
+	"github.com/redefik/notificationmanagement/mailtemplate"
+	"log"
+	"sort"
+	"strings"
+)
+
+/*Localization of the notifications. A notification may carry its body in several languages: each student receives
+the body in their preferred locale, or in the default language of the course when the locale is not available. The
+recipients are grouped by the language of the body, and each group is sent with the localized version of the
+template, named after the template and the language (e.g. MailTemplate-en), when it exists*/
+
+// localizedTemplates contains the names of the templates defined in the template directory, that are the ones
+// uploaded to SES. It is filled by InitializeSender
+var localizedTemplates = make(map[string]bool)
+
+// LocaleGroup contains the recipients that receive a notification in the same language
+type LocaleGroup struct {
+	Language   string              // language of the body, empty when it is not known
+	Message    entity.Notification // notification whose Message is the body in Language
+	Recipients []string
+}
+
+// loadLocalizedTemplates reads the names of the templates of the template directory
+func loadLocalizedTemplates() error {
+	templates, err := mailtemplate.LoadDirectory(config.Configuration.TemplateDirectory)
+	if err != nil {
+		return err
+	}
+	localizedTemplates = make(map[string]bool)
+	for name := range templates {
+		localizedTemplates[name] = true
+	}
+	return nil
+}
+
+// primaryLanguage returns the language of a locale without the region, e.g. en for en-gb
+func primaryLanguage(locale string) string {
+	return strings.Split(locale, "-")[0]
+}
+
+// LocalizedTemplateName returns the name of the version of the template in the given language, falling back to the
+// primary language and then to the template itself
+func LocalizedTemplateName(templateName string, language string) string {
+	return localizedTemplateName(templateName, language, func(name string) bool {
+		return localizedTemplates[name]
+	})
+}
+
+// localizedTemplateName is as LocalizedTemplateName, but the function exists tells which templates are available
+func localizedTemplateName(templateName string, language string, exists func(name string) bool) string {
+	if templateName == "" || language == "" {
+		return templateName
+	}
+	for _, candidate := range []string{language, primaryLanguage(language)} {
+		if exists(templateName + "-" + candidate) {
+			return templateName + "-" + candidate
+		}
+	}
+	return templateName
+}
+
+// normalizeMessages returns the localized bodies indexed by the normalized language, as the locales of the students
+// and the languages of the courses are, so that e.g. a body sent as EN_gb is found for the locale en-gb. When two
+// languages normalize to the same one, the body whose language was already normalized is kept, otherwise the first in
+// alphabetical order
+func normalizeMessages(messages map[string]string) map[string]string {
+	if len(messages) == 0 {
+		return messages
+	}
+	languages := make([]string, 0, len(messages))
+	for language := range messages {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	normalizedMessages := make(map[string]string, len(messages))
+	for _, language := range languages {
+		normalizedLanguage := coursehandler.NormalizeLocale(language)
+		if normalizedLanguage == "" {
+			continue
+		}
+		if _, ok := normalizedMessages[normalizedLanguage]; !ok || language == normalizedLanguage {
+			normalizedMessages[normalizedLanguage] = messages[language]
+		}
+	}
+	return normalizedMessages
+}
+
+// LocalizeNotification returns the notification with the body that a student with the given locale receives, and the
+// language of that body. The body is chosen among the localized ones looking for the locale, its primary language
+// and the default language of the course; when none of them is available the body in Message, that is written in
+// the default language of the course, is used. A notification without Message falls back to the first of the
+// localized bodies, in alphabetical order of language. The languages of the localized bodies are normalized as the
+// locales, so the returned language is always normalized
+func LocalizeNotification(message entity.Notification, locale string, courseLanguage string) (string, entity.Notification) {
+	message.Messages = normalizeMessages(message.Messages)
+	return localizeNotification(message, locale, courseLanguage)
+}
+
+// localizeNotification is as LocalizeNotification, but the languages of the localized bodies are already normalized
+func localizeNotification(message entity.Notification, locale string, courseLanguage string) (string, entity.Notification) {
+	localizedMessage := message
+	localizedMessage.Messages = nil
+	for _, language := range []string{locale, primaryLanguage(locale), courseLanguage, primaryLanguage(courseLanguage)} {
+		if body, ok := message.Messages[language]; ok && language != "" {
+			localizedMessage.Message = body
+			return language, localizedMessage
+		}
+	}
+	if message.Message != "" || len(message.Messages) == 0 {
+		return courseLanguage, localizedMessage
+	}
+	languages := make([]string, 0, len(message.Messages))
+	for language := range message.Messages {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	localizedMessage.Message = message.Messages[languages[0]]
+	return languages[0], localizedMessage
+}
+
+// GroupByLocale splits the recipients by the language of the body they receive. The locales of the recipients are
+// indexed by mail, the recipients without a locale receive the body in the default language of the course.
+// The groups are sorted by language
+func GroupByLocale(message entity.Notification, courseLanguage string, locales map[string]string, to []string) []LocaleGroup {
+	groups := make(map[string]*LocaleGroup)
+	var languages []string
+	message.Messages = normalizeMessages(message.Messages)
+	for _, recipient := range to {
+		language, localizedMessage := localizeNotification(message, locales[recipient], courseLanguage)
+		group, ok := groups[language]
+		if !ok {
+			group = &LocaleGroup{Language: language, Message: localizedMessage}
+			groups[language] = group
+			languages = append(languages, language)
+		}
+		group.Recipients = append(group.Recipients, recipient)
+	}
+	sort.Strings(languages)
+	result := make([]LocaleGroup, 0, len(languages))
+	for _, language := range languages {
+		result = append(result, *groups[language])
+	}
+	return result
+}
+
+// SendLocalizedNotification sends the notification to each group of recipients in its language, using the localized
+// version of the template. The results of the groups are merged: NotDeliveredError is returned only when nobody
+// received the notification, RejectedError when every group has been rejected permanently
+func SendLocalizedNotification(ctx context.Context, sender Sender, message entity.Notification, templateName string, courseLanguage string,
+	locales map[string]string, from string, to []string) (DeliveryResult, error) {
+	result := DeliveryResult{Delivered: make(map[string]string), Failed: make(map[string]string)}
+	rejected := true // every group has been rejected permanently
+	for _, group := range GroupByLocale(message, courseLanguage, locales, to) {
+		groupResult, err := sender.SendNotification(ctx, group.Message, LocalizedTemplateName(templateName, group.Language),
+			from, group.Recipients)
+		if err != RejectedError {
+			rejected = false
+		}
+		if err != nil && err != NotDeliveredError && err != RejectedError {
+			// the group has not been sent at all
+			log.Println(err)
+			for _, recipient := range group.Recipients {
+				result.Failed[recipient] = err.Error()
+			}
+			continue
+		}
+		for recipient, messageId := range groupResult.Delivered {
+			result.Delivered[recipient] = messageId
+		}
+		for recipient, reason := range groupResult.Failed {
+			result.Failed[recipient] = reason
+		}
+	}
+	if len(result.Failed) > 0 && len(result.Delivered) == 0 {
+		if rejected {
+			return result, RejectedError
+		}
+		return result, NotDeliveredError
+	}
+	return result, nil
+}
@@ -0,0 +1,155 @@
+package notificationhandler
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/redefik/notificationmanagement/entity"
+	"github.com/redefik/notificationmanagement/mailtemplate"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+/*MIME encoding of the mails rendered locally, shared by the senders that do not use the SES templates*/
+
+// mailMessage contains the fields of a mail addressed to a single recipient
+type mailMessage struct {
+	From        string
+	To          string
+	Subject     string
+	Text        string
+	Html        string
+	Attachments []entity.Attachment // attachments whose Data has been read
+}
+
+// newMailMessage returns the mail built from the rendered template and from the attachments of the notification.
+// The senders set the recipient of each copy of the mail
+func newMailMessage(mail mailtemplate.RenderedMail, message entity.Notification, from string) mailMessage {
+	return mailMessage{From: from, Subject: mail.Subject, Text: mail.Text, Html: mail.Html, Attachments: message.Attachments}
+}
+
+// newMessageId generates a unique Message-Id in the domain of the sender address
+func newMessageId(from string) string {
+	randomBytes := make([]byte, 16)
+	rand.Read(randomBytes)
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = strings.Trim(from[at+1:], "<> ")
+	}
+	return "<" + hex.EncodeToString(randomBytes) + "@" + domain + ">"
+}
+
+// writeQuotedPrintable writes the content encoded as quoted-printable, which keeps the lines of the mail short
+func writeQuotedPrintable(buffer *bytes.Buffer, content string) {
+	writer := quotedprintable.NewWriter(buffer)
+	writer.Write([]byte(content))
+	writer.Close()
+}
+
+// writeBase64 writes the content encoded in base64, in lines of 76 characters as required by MIME
+func writeBase64(buffer *bytes.Buffer, content []byte) {
+	encoded := base64.StdEncoding.EncodeToString(content)
+	for len(encoded) > 76 {
+		buffer.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	buffer.WriteString(encoded)
+}
+
+// quoteFileName returns the file name as the value of the filename and name parameters. The names that are not
+// plain ASCII are encoded as RFC 2047 words, that are understood by the mail clients
+func quoteFileName(fileName string) string {
+	for _, r := range fileName {
+		if r < ' ' || r > '~' {
+			return `"` + mime.BEncoding.Encode("utf-8", fileName) + `"`
+		}
+	}
+	return `"` + strings.Replace(strings.Replace(fileName, `\`, `\\`, -1), `"`, `\"`, -1) + `"`
+}
+
+// body returns the headers and the content of the body of the mail. The text and the HTML versions are sent as the
+// parts of a multipart/alternative body, so every client shows the best version it supports
+func (message mailMessage) body() (textproto.MIMEHeader, []byte) {
+	var content bytes.Buffer
+	if message.Html == "" {
+		writeQuotedPrintable(&content, message.Text)
+		return textproto.MIMEHeader{
+			"Content-Type":              {"text/plain; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		}, content.Bytes()
+	}
+
+	writer := multipart.NewWriter(&content)
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", message.Text},
+		{"text/html; charset=utf-8", message.Html},
+	}
+	for _, part := range parts {
+		partWriter, _ := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		var partBody bytes.Buffer
+		writeQuotedPrintable(&partBody, part.content)
+		partWriter.Write(partBody.Bytes())
+	}
+	writer.Close()
+	return textproto.MIMEHeader{
+		"Content-Type": {"multipart/alternative; boundary=" + writer.Boundary()},
+	}, content.Bytes()
+}
+
+// withAttachments returns the headers and the content of a multipart/mixed body, whose first part is the provided
+// body and the other parts are the attachments
+func (message mailMessage) withAttachments(header textproto.MIMEHeader, body []byte) (textproto.MIMEHeader, []byte) {
+	var content bytes.Buffer
+	writer := multipart.NewWriter(&content)
+	partWriter, _ := writer.CreatePart(header)
+	partWriter.Write(body)
+	for _, attachment := range message.Attachments {
+		fileName := quoteFileName(attachment.FileName)
+		partWriter, _ := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {attachment.ContentType + "; name=" + fileName},
+			"Content-Disposition":       {"attachment; filename=" + fileName},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		var partBody bytes.Buffer
+		writeBase64(&partBody, attachment.Data)
+		partWriter.Write(partBody.Bytes())
+	}
+	writer.Close()
+	return textproto.MIMEHeader{
+		"Content-Type": {"multipart/mixed; boundary=" + writer.Boundary()},
+	}, content.Bytes()
+}
+
+// encode returns the mail in MIME format. The attachments, if any, follow the body in a multipart/mixed message
+func (message mailMessage) encode(messageId string, date time.Time) []byte {
+	var buffer bytes.Buffer
+	buffer.WriteString("From: " + message.From + "\r\n")
+	buffer.WriteString("To: " + message.To + "\r\n")
+	buffer.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", message.Subject) + "\r\n")
+	buffer.WriteString("Date: " + date.Format(time.RFC1123Z) + "\r\n")
+	buffer.WriteString("Message-Id: " + messageId + "\r\n")
+	buffer.WriteString("MIME-Version: 1.0\r\n")
+	header, body := message.body()
+	if len(message.Attachments) > 0 {
+		header, body = message.withAttachments(header, body)
+	}
+	for _, key := range []string{"Content-Type", "Content-Transfer-Encoding"} {
+		if value := header.Get(key); value != "" {
+			buffer.WriteString(key + ": " + value + "\r\n")
+		}
+	}
+	buffer.WriteString("\r\n")
+	buffer.Write(body)
+	return buffer.Bytes()
+}
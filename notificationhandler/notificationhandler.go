@@ -1,52 +1,154 @@
 package notificationhandler
 
 import (
+	"context"
 	"errors"
-	"fmt"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/ses"
 	"github.com/redefik/notificationmanagement/config"
 	"github.com/redefik/notificationmanagement/entity"
+	"github.com/redefik/notificationmanagement/mailtemplate"
+	"time"
 )
 
-/*This package contains a set of functions that wrap the API of Amazon Simple Email Service SDK for Go*/
+/*This package contains the senders used to deliver the notifications to the students by e-mail. The microservice
+uses Amazon Simple Email Service by default, while the deployments that cannot access it can use an SMTP server.
+During development the mails can be written to files instead of being delivered*/
 
-var Client *ses.SES
+// Sender delivers a notification to the mailing list of a course
+type Sender interface {
+	// SendNotification sends the message provided to the given recipients, building the mail with the template
+	// named templateName (the default template of the sender when empty). The returned DeliveryResult tells who
+	// received the mail; NotDeliveredError is returned when nobody received it, so the notification can be sent again,
+	// and RejectedError when every recipient has been refused for a reason that would be returned again.
+	// When ctx is cancelled the sending stops, and the recipients not reached yet are reported as failed
+	SendNotification(ctx context.Context, message entity.Notification, templateName string, from string, to []string) (DeliveryResult, error)
+}
+
+// MailSender is the Sender used by the microservice. It is set up once at startup and it is safe to be used
+// concurrently
+var MailSender Sender
+
+// Names of the senders that can be selected in the configuration file
+const (
+	SesSenderName  = "ses"
+	SmtpSenderName = "smtp"
+	FileSenderName = "file"
+)
+
+// Number of attempts made to deliver a notification to a recipient whose sending failed transiently, and delay
+// before the first retry, doubled after each attempt
+const maxSendAttempts = 3
+const retryDelay = time.Second
+
+// NotDeliveredError is returned when the notification has not been delivered to any recipient
+var NotDeliveredError = errors.New("the notification has not been delivered to any recipient")
+
+// RejectedError is returned when the notification has been rejected permanently for every recipient, e.g. because the
+// template does not exist or the server refused every mail with a 5xx reply, so sending it again would not help
+var RejectedError = errors.New("the notification has been rejected for every recipient")
+
+// DeliveryResult describes who received a notification sent to multiple recipients
+type DeliveryResult struct {
+	Delivered map[string]string // recipients that received the mail, mapped to the id of the message sent to them
+	Failed    map[string]string // recipients that have not been reached, mapped to the reason of the failure
+}
 
-// InitializeSesClient instantiate a Sess client that will be used to make API requests to SES. The initialization
-// is performed once because, as reported in the documentation, the client is safe to be used concurrently
-func InitializeSesClient(region string) error {
-	newSession := session.Must(session.NewSession(&aws.Config{
-		Region: aws.String(region),
-	}))
-	Client = ses.New(newSession)
-	return nil
+// InitializeSender sets up MailSender using the sender named by config.Configuration.MailSender.
+// When no sender is configured SES is used.
+func InitializeSender() error {
+	err := loadLocalizedTemplates()
+	if err != nil {
+		return err
+	}
+	initializeS3Client()
+	switch config.Configuration.MailSender {
+	case "", SesSenderName:
+		return InitializeSesClient(config.Configuration.AwsSesRegion)
+	case SmtpSenderName:
+		mailTemplates, err := mailtemplate.LoadSet(config.Configuration.MailTemplateFile, config.Configuration.TemplateDirectory)
+		if err != nil {
+			return err
+		}
+		MailSender = NewSmtpSender(SmtpSettings{
+			Address:  config.Configuration.SmtpAddress,
+			Username: config.Configuration.SmtpUsername,
+			Password: config.Configuration.SmtpPassword,
+			Insecure: config.Configuration.SmtpInsecure,
+		}, mailTemplates)
+		return nil
+	case FileSenderName:
+		mailTemplates, err := mailtemplate.LoadSet(config.Configuration.MailTemplateFile, config.Configuration.TemplateDirectory)
+		if err != nil {
+			return err
+		}
+		fileSender, err := NewFileSender(config.Configuration.MailSinkDirectory, mailTemplates)
+		if err != nil {
+			return err
+		}
+		MailSender = fileSender
+		return nil
+	default:
+		return errors.New("unknown mail sender: " + config.Configuration.MailSender)
+	}
 }
 
-// SendNotificationToMultipleRecipients sends the message provided to the given recipients.
-// The name  of the mail template is read from config.Configuration and the message is built replacing the template
-// parameters with the content of the provided notificationthread struct
-func SendNotificationToMultipleRecipients(message entity.Notification, from string, to []string) error {
-	var destinations []*ses.BulkEmailDestination
-	// The parameters of the mail template are set according to the message provided
-	defaultTemplateData := "{ \"courseName\":\"%s\", \"year\":\"%s\", \"body\":\"%s\"}"
-	defaultTemplateData = fmt.Sprintf(defaultTemplateData, message.Name, message.Year, message.Message)
-	for i := 0; i < len(to); i++ {
-		bulkEmailDestination := &ses.BulkEmailDestination{Destination: &ses.Destination{BccAddresses: []*string{aws.String(to[i])}},
-			ReplacementTemplateData: aws.String(defaultTemplateData)}
-		destinations = append(destinations, bulkEmailDestination)
+// ResolveTemplateName returns the name of the template used for the notifications of a course: the template of the
+// course if it has one, otherwise the template configured for its department and, as a last resort, the global
+// MailTemplate
+func ResolveTemplateName(courseTemplate string, department string) string {
+	if courseTemplate != "" {
+		return courseTemplate
 	}
-	sendBulkTemplatedEmailInput := &ses.SendBulkTemplatedEmailInput{
-		Source:              aws.String(from),
-		Destinations:        destinations,
-		Template:            aws.String(config.Configuration.MailTemplate),
-		DefaultTemplateData: aws.String(defaultTemplateData),
+	if departmentTemplate := config.Configuration.DepartmentTemplates[department]; departmentTemplate != "" {
+		return departmentTemplate
 	}
-	// TODO sono ammessi al più 50 destinatari per volta
-	_, err := Client.SendBulkTemplatedEmail(sendBulkTemplatedEmailInput)
-	if err != nil {
-		return errors.New("error in sending e-mails")
+	return config.Configuration.MailTemplate
+}
+
+// deliverWithRetries delivers a notification to the recipients in batches of at most batchSize recipients. The
+// function send delivers a batch, storing the outcome of each recipient in the result, and returns the recipients
+// whose sending failed transiently, that are retried up to maxSendAttempts times. RejectedError is returned instead of
+// NotDeliveredError when none of the recipients failed transiently.
+// When ctx is cancelled no other batch is sent, and the recipients that have not been reached are marked as failed
+func deliverWithRetries(ctx context.Context, to []string, batchSize int, send func(recipients []string, result DeliveryResult) []string) (DeliveryResult, error) {
+	result := DeliveryResult{Delivered: make(map[string]string), Failed: make(map[string]string)}
+	transient := false // some recipients have not been reached for a reason that may not be returned again
+	for start := 0; start < len(to); start += batchSize {
+		if ctx.Err() != nil {
+			for _, recipient := range to[start:] {
+				result.Failed[recipient] = ctx.Err().Error()
+			}
+			transient = true
+			break
+		}
+		end := start + batchSize
+		if end > len(to) {
+			end = len(to)
+		}
+		recipients := to[start:end]
+		delay := retryDelay
+		for attempt := 1; len(recipients) > 0 && attempt <= maxSendAttempts; attempt++ {
+			if attempt > 1 {
+				// the retry is given up when ctx is cancelled, the recipients keep the reason of their last failure
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+				}
+				if ctx.Err() != nil {
+					break
+				}
+				delay *= 2
+			}
+			recipients = send(recipients, result)
+		}
+		if len(recipients) > 0 {
+			transient = true
+		}
+	}
+	if len(result.Failed) > 0 && len(result.Delivered) == 0 {
+		if !transient {
+			return result, RejectedError
+		}
+		return result, NotDeliveredError
 	}
-	return nil
+	return result, nil
 }
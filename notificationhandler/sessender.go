@@ -0,0 +1,195 @@
+package notificationhandler
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/ses/sesiface"
+	"github.com/redefik/notificationmanagement/config"
+	"github.com/redefik/notificationmanagement/entity"
+	"github.com/redefik/notificationmanagement/mailtemplate"
+	"log"
+	"time"
+)
+
+/*Sender based on Amazon Simple Email Service. The mails are built by SES from the template stored in the service,
+except the ones with attachments, that are built locally from the same template and sent as raw mails*/
+
+var Client *ses.SES
+
+// Maximum number of destinations accepted by SES in a single SendBulkTemplatedEmail request
+const maxDestinationsPerRequest = 50
+
+// Number of raw mails sent before a failed batch is retried
+const rawBatchSize = 50
+
+// SesSender sends the notifications with SendBulkTemplatedEmail requests, or with a SendRawEmail request for each
+// recipient when the notification has attachments
+type SesSender struct {
+	client        sesiface.SESAPI
+	templateName  string           // template used when the notification does not request a specific one
+	mailTemplates mailtemplate.Set // local copy of the SES templates, used to build the mails with attachments
+}
+
+// NewSesSender returns a Sender that uses the provided client and, by default, the SES template with the given name.
+// The local templates must be the ones uploaded to SES
+func NewSesSender(client sesiface.SESAPI, templateName string, mailTemplates mailtemplate.Set) *SesSender {
+	return &SesSender{client: client, templateName: templateName, mailTemplates: mailTemplates}
+}
+
+// InitializeSesClient instantiate a Sess client that will be used to make API requests to SES. The initialization
+// is performed once because, as reported in the documentation, the client is safe to be used concurrently.
+// The client is then used to set up the SES MailSender and the manager of the SES templates
+func InitializeSesClient(region string) error {
+	newSession := session.Must(session.NewSession(&aws.Config{
+		Region: aws.String(region),
+	}))
+	Client = ses.New(newSession)
+	mailTemplates, err := mailtemplate.LoadSet(config.Configuration.MailTemplateFile, config.Configuration.TemplateDirectory)
+	if err != nil {
+		return err
+	}
+	MailSender = NewSesSender(Client, config.Configuration.MailTemplate, mailTemplates)
+	Templates = NewTemplateManager(Client, config.Configuration.TemplateDirectory)
+	return nil
+}
+
+// isTransientStatus tells if a destination whose sending ended with the given status can be retried
+func isTransientStatus(status string) bool {
+	return status == ses.BulkEmailStatusTransientFailure || status == ses.BulkEmailStatusAccountThrottled
+}
+
+// isTransientSesError tells if a request failed for a reason that may not be returned by a retry: the request has
+// been throttled, SES failed with a 5xx status or it has not been reached. The other errors, such as
+// TemplateDoesNotExist or MessageRejected, would be returned again
+func isTransientSesError(err error) bool {
+	awsError, ok := err.(awserr.Error)
+	if !ok {
+		return true
+	}
+	if requestFailure, ok := awsError.(awserr.RequestFailure); ok && requestFailure.StatusCode() >= 500 {
+		return true
+	}
+	return request.IsErrorThrottle(awsError) || request.IsErrorRetryable(awsError)
+}
+
+// sendRawMails makes a SendRawEmail request for each recipient. The outcome of each recipient is stored in result,
+// while the recipients that can be retried are returned
+func (sender *SesSender) sendRawMails(ctx context.Context, mail mailMessage, to []string, result DeliveryResult) []string {
+	var retryableRecipients []string
+	for i, recipient := range to {
+		if ctx.Err() != nil {
+			for _, remainingRecipient := range to[i:] {
+				result.Failed[remainingRecipient] = ctx.Err().Error()
+			}
+			return retryableRecipients
+		}
+		mail.To = recipient
+		sendRawEmailOutput, err := sender.client.SendRawEmailWithContext(ctx, &ses.SendRawEmailInput{
+			Source:       aws.String(mail.From),
+			Destinations: []*string{aws.String(recipient)},
+			// SES replaces the Message-Id with its own, that is returned in the response
+			RawMessage: &ses.RawMessage{Data: mail.encode(newMessageId(mail.From), time.Now())},
+		})
+		if err != nil {
+			log.Println(err)
+			result.Failed[recipient] = err.Error()
+			if isTransientSesError(err) {
+				retryableRecipients = append(retryableRecipients, recipient)
+			}
+			continue
+		}
+		result.Delivered[recipient] = aws.StringValue(sendRawEmailOutput.MessageId)
+		delete(result.Failed, recipient)
+	}
+	return retryableRecipients
+}
+
+// sendWithAttachments renders locally the template with the content of the notification and sends the mail, with the
+// attachments, to each recipient
+func (sender *SesSender) sendWithAttachments(ctx context.Context, message entity.Notification, templateName string, from string, to []string) (DeliveryResult, error) {
+	message, err := LoadAttachments(ctx, message)
+	if err != nil {
+		return DeliveryResult{}, err
+	}
+	mail := newMailMessage(renderNotification(sender.mailTemplates.Get(templateName), message), message, from)
+	return deliverWithRetries(ctx, to, rawBatchSize, func(recipients []string, result DeliveryResult) []string {
+		return sender.sendRawMails(ctx, mail, recipients, result)
+	})
+}
+
+// sendToDestinations makes a SendBulkTemplatedEmail request for the given recipients, at most
+// maxDestinationsPerRequest. The outcome of each recipient is stored in result, while the recipients that can be
+// retried are returned
+func (sender *SesSender) sendToDestinations(ctx context.Context, templateName string, templateData string, from string, to []string, result DeliveryResult) []string {
+	destinations := make([]*ses.BulkEmailDestination, 0, len(to))
+	for _, recipient := range to {
+		destinations = append(destinations, &ses.BulkEmailDestination{
+			Destination:             &ses.Destination{BccAddresses: []*string{aws.String(recipient)}},
+			ReplacementTemplateData: aws.String(templateData),
+		})
+	}
+	sendBulkTemplatedEmailInput := &ses.SendBulkTemplatedEmailInput{
+		Source:              aws.String(from),
+		Destinations:        destinations,
+		Template:            aws.String(templateName),
+		DefaultTemplateData: aws.String(templateData),
+	}
+	sendBulkTemplatedEmailOutput, err := sender.client.SendBulkTemplatedEmailWithContext(ctx, sendBulkTemplatedEmailInput)
+	if err != nil {
+		// the request has not been processed, so every recipient is retried unless the error is permanent
+		log.Println(err)
+		for _, recipient := range to {
+			result.Failed[recipient] = err.Error()
+		}
+		if !isTransientSesError(err) {
+			return nil
+		}
+		return to
+	}
+	// SES returns the status of each destination in the same order of the request
+	var retryableRecipients []string
+	for i, recipient := range to {
+		if i >= len(sendBulkTemplatedEmailOutput.Status) {
+			result.Failed[recipient] = "status not returned by SES"
+			retryableRecipients = append(retryableRecipients, recipient)
+			continue
+		}
+		status := sendBulkTemplatedEmailOutput.Status[i]
+		if aws.StringValue(status.Status) == ses.BulkEmailStatusSuccess {
+			result.Delivered[recipient] = aws.StringValue(status.MessageId)
+			delete(result.Failed, recipient)
+			continue
+		}
+		result.Failed[recipient] = aws.StringValue(status.Status) + ": " + aws.StringValue(status.Error)
+		if isTransientStatus(aws.StringValue(status.Status)) {
+			retryableRecipients = append(retryableRecipients, recipient)
+		}
+	}
+	return retryableRecipients
+}
+
+// SendNotification sends the message provided to the given recipients. The message is built by SES replacing the
+// parameters of the template with the content of the notification.
+// The recipients are split in requests of maxDestinationsPerRequest destinations, as required by SES, and the
+// recipients whose sending failed transiently are retried. SendBulkTemplatedEmail does not support attachments, so
+// the notifications with attachments are sent as raw mails
+func (sender *SesSender) SendNotification(ctx context.Context, message entity.Notification, templateName string, from string, to []string) (DeliveryResult, error) {
+	if templateName == "" {
+		templateName = sender.templateName
+	}
+	if len(message.Attachments) > 0 {
+		return sender.sendWithAttachments(ctx, message, templateName, from, to)
+	}
+	// The parameters of the mail template are set according to the message provided
+	templateData, err := BuildTemplateData(message)
+	if err != nil {
+		return DeliveryResult{}, err
+	}
+	return deliverWithRetries(ctx, to, maxDestinationsPerRequest, func(recipients []string, result DeliveryResult) []string {
+		return sender.sendToDestinations(ctx, templateName, templateData, from, recipients, result)
+	})
+}
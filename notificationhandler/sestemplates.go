@@ -0,0 +1,266 @@
+package notificationhandler
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/redefik/notificationmanagement/mailtemplate"
+	"sort"
+)
+
+/*Management of the templates stored in SES. The template files of the repository (config/templates) are the source of
+truth: the TemplateManager compares them with the templates stored in SES and uploads the ones that differ, so that a
+template change is shipped together with the deployment that uses it*/
+
+// Status of a template in the comparison between the template files and SES
+const (
+	TemplateUnchanged = "unchanged" // SES stores the same template of the file
+	TemplateChanged   = "changed"   // SES stores a different version of the template
+	TemplateMissing   = "missing"   // the template is defined by a file but it is not stored in SES
+	TemplateExtra     = "extra"     // the template is stored in SES but no file defines it
+)
+
+// Actions performed by Sync on a template
+const (
+	TemplateCreated = "created"
+	TemplateUpdated = "updated"
+	TemplateDeleted = "deleted"
+)
+
+// TemplateNotFoundError is returned when the requested template is not defined by any template file
+var TemplateNotFoundError = errors.New("the template is not defined in the template directory")
+
+// TemplateInUseError is returned when the deletion of a template defined by a template file is requested: the
+// template may be in use by a department, a course or a language, so it is removed by deleting its file and pruning
+var TemplateInUseError = errors.New("the template is defined in the template directory")
+
+// TemplateDiff describes the difference between the template file and the SES template with the same name
+type TemplateDiff struct {
+	Name         string   `json:"name"`
+	Status       string   `json:"status"`
+	ChangedParts []string `json:"changedParts,omitempty"` // parts that differ when the status is TemplateChanged
+	Action       string   `json:"action,omitempty"`       // action performed by Sync, empty if nothing has been done
+}
+
+// TemplateManager keeps the SES templates aligned with the template files of a directory
+type TemplateManager struct {
+	client    *ses.SES
+	directory string
+}
+
+// Templates is the TemplateManager used by the admin endpoints. It is nil when the notifications are not sent by SES
+var Templates *TemplateManager
+
+// NewTemplateManager returns a TemplateManager that uploads the templates of the directory with the provided client.
+// An empty directory selects mailtemplate.DefaultDirectory
+func NewTemplateManager(client *ses.SES, directory string) *TemplateManager {
+	return &TemplateManager{client: client, directory: directory}
+}
+
+// DiffTemplates compares the local templates, indexed by name, with the templates stored in SES. A nil remote
+// template is not stored in SES. The differences are sorted by template name
+func DiffTemplates(local map[string]*mailtemplate.MailTemplate, remote map[string]*ses.Template) []TemplateDiff {
+	diffs := make([]TemplateDiff, 0, len(local)+len(remote))
+	for name, localTemplate := range local {
+		remoteTemplate := remote[name]
+		if remoteTemplate == nil {
+			diffs = append(diffs, TemplateDiff{Name: name, Status: TemplateMissing})
+			continue
+		}
+		var changedParts []string
+		if localTemplate.SubjectPart != aws.StringValue(remoteTemplate.SubjectPart) {
+			changedParts = append(changedParts, "SubjectPart")
+		}
+		if localTemplate.HtmlPart != aws.StringValue(remoteTemplate.HtmlPart) {
+			changedParts = append(changedParts, "HtmlPart")
+		}
+		if localTemplate.TextPart != aws.StringValue(remoteTemplate.TextPart) {
+			changedParts = append(changedParts, "TextPart")
+		}
+		if len(changedParts) > 0 {
+			diffs = append(diffs, TemplateDiff{Name: name, Status: TemplateChanged, ChangedParts: changedParts})
+		} else {
+			diffs = append(diffs, TemplateDiff{Name: name, Status: TemplateUnchanged})
+		}
+	}
+	for name := range remote {
+		if _, ok := local[name]; !ok {
+			diffs = append(diffs, TemplateDiff{Name: name, Status: TemplateExtra})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Name < diffs[j].Name
+	})
+	return diffs
+}
+
+// Diff compares the template files with the templates stored in SES
+func (manager *TemplateManager) Diff() ([]TemplateDiff, error) {
+	local, err := mailtemplate.LoadDirectory(manager.directory)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := manager.remoteTemplates(local)
+	if err != nil {
+		return nil, err
+	}
+	return DiffTemplates(local, remote), nil
+}
+
+// Sync creates the missing templates and updates the changed ones. When prune is true the templates stored in SES
+// but not defined by any file are deleted, otherwise they are left untouched. The returned differences are the ones
+// found before the synchronization, with the action performed on each template
+func (manager *TemplateManager) Sync(prune bool) ([]TemplateDiff, error) {
+	local, err := mailtemplate.LoadDirectory(manager.directory)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := manager.remoteTemplates(local)
+	if err != nil {
+		return nil, err
+	}
+	diffs := DiffTemplates(local, remote)
+	for i := range diffs {
+		switch diffs[i].Status {
+		case TemplateMissing:
+			err = manager.createTemplate(local[diffs[i].Name])
+			diffs[i].Action = TemplateCreated
+		case TemplateChanged:
+			err = manager.updateTemplate(local[diffs[i].Name])
+			diffs[i].Action = TemplateUpdated
+		case TemplateExtra:
+			if prune {
+				err = manager.deleteTemplate(diffs[i].Name)
+				diffs[i].Action = TemplateDeleted
+			}
+		}
+		if err != nil {
+			// the templates following the failed one have not been touched
+			diffs[i].Action = ""
+			return diffs, err
+		}
+	}
+	return diffs, nil
+}
+
+// Upload creates or updates in SES the template with the given name. It returns TemplateNotFoundError if no file
+// defines the template
+func (manager *TemplateManager) Upload(name string) (TemplateDiff, error) {
+	local, err := mailtemplate.LoadDirectory(manager.directory)
+	if err != nil {
+		return TemplateDiff{}, err
+	}
+	localTemplate, ok := local[name]
+	if !ok {
+		return TemplateDiff{}, TemplateNotFoundError
+	}
+	remoteTemplate, err := manager.getTemplate(name)
+	if err != nil {
+		return TemplateDiff{}, err
+	}
+	diff := DiffTemplates(map[string]*mailtemplate.MailTemplate{name: localTemplate},
+		map[string]*ses.Template{name: remoteTemplate})[0]
+	switch diff.Status {
+	case TemplateMissing:
+		err = manager.createTemplate(localTemplate)
+		diff.Action = TemplateCreated
+	case TemplateChanged:
+		err = manager.updateTemplate(localTemplate)
+		diff.Action = TemplateUpdated
+	}
+	if err != nil {
+		return TemplateDiff{}, err
+	}
+	return diff, nil
+}
+
+// CheckDeletion tells if the template with the given name can be deleted: only the templates that are not defined by
+// any template file, the extra ones, can. It returns TemplateInUseError otherwise
+func (manager *TemplateManager) CheckDeletion(name string) error {
+	local, err := mailtemplate.LoadDirectory(manager.directory)
+	if err != nil {
+		return err
+	}
+	if _, ok := local[name]; ok {
+		return TemplateInUseError
+	}
+	return nil
+}
+
+// Delete removes the template with the given name from SES. It returns TemplateInUseError if a template file defines
+// the template. Deleting a template that is not stored is not an error
+func (manager *TemplateManager) Delete(name string) error {
+	err := manager.CheckDeletion(name)
+	if err != nil {
+		return err
+	}
+	return manager.deleteTemplate(name)
+}
+
+func (manager *TemplateManager) deleteTemplate(name string) error {
+	_, err := manager.client.DeleteTemplate(&ses.DeleteTemplateInput{TemplateName: aws.String(name)})
+	return err
+}
+
+// remoteTemplates returns the templates stored in SES. Only the content of the templates defined locally is
+// retrieved, since the other ones are just reported as extra
+func (manager *TemplateManager) remoteTemplates(local map[string]*mailtemplate.MailTemplate) (map[string]*ses.Template, error) {
+	remote := make(map[string]*ses.Template)
+	listTemplatesInput := &ses.ListTemplatesInput{MaxItems: aws.Int64(100)}
+	for {
+		listTemplatesOutput, err := manager.client.ListTemplates(listTemplatesInput)
+		if err != nil {
+			return nil, err
+		}
+		for _, metadata := range listTemplatesOutput.TemplatesMetadata {
+			name := aws.StringValue(metadata.Name)
+			if _, ok := local[name]; !ok {
+				remote[name] = &ses.Template{TemplateName: metadata.Name}
+				continue
+			}
+			remoteTemplate, err := manager.getTemplate(name)
+			if err != nil {
+				return nil, err
+			}
+			if remoteTemplate != nil {
+				remote[name] = remoteTemplate
+			}
+		}
+		if listTemplatesOutput.NextToken == nil {
+			return remote, nil
+		}
+		listTemplatesInput.NextToken = listTemplatesOutput.NextToken
+	}
+}
+
+// getTemplate returns the SES template with the given name, or nil if it is not stored
+func (manager *TemplateManager) getTemplate(name string) (*ses.Template, error) {
+	getTemplateOutput, err := manager.client.GetTemplate(&ses.GetTemplateInput{TemplateName: aws.String(name)})
+	if err != nil {
+		if awsError, ok := err.(awserr.Error); ok && awsError.Code() == ses.ErrCodeTemplateDoesNotExistException {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return getTemplateOutput.Template, nil
+}
+
+func (manager *TemplateManager) createTemplate(localTemplate *mailtemplate.MailTemplate) error {
+	_, err := manager.client.CreateTemplate(&ses.CreateTemplateInput{Template: toSesTemplate(localTemplate)})
+	return err
+}
+
+func (manager *TemplateManager) updateTemplate(localTemplate *mailtemplate.MailTemplate) error {
+	_, err := manager.client.UpdateTemplate(&ses.UpdateTemplateInput{Template: toSesTemplate(localTemplate)})
+	return err
+}
+
+func toSesTemplate(localTemplate *mailtemplate.MailTemplate) *ses.Template {
+	return &ses.Template{
+		TemplateName: aws.String(localTemplate.TemplateName),
+		SubjectPart:  aws.String(localTemplate.SubjectPart),
+		HtmlPart:     aws.String(localTemplate.HtmlPart),
+		TextPart:     aws.String(localTemplate.TextPart),
+	}
+}
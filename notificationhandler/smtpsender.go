@@ -0,0 +1,263 @@
+package notificationhandler
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/redefik/notificationmanagement/entity"
+	"github.com/redefik/notificationmanagement/mailtemplate"
+	"log"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"sync"
+	"time"
+)
+
+/*Sender based on an SMTP server. The mails are rendered locally from the same template uploaded to SES, and a mail
+is sent to each recipient over a connection that is kept open between the notifications*/
+
+// Number of mails sent before the connection is checked and a failed batch is retried
+const smtpBatchSize = 50
+
+// Timeout of the connection to the SMTP server
+const smtpDialTimeout = 10 * time.Second
+
+// Maximum time given to each SMTP command, including the transfer of the mail, so a stalled server does not block
+// the senders sharing the connection
+const smtpCommandTimeout = time.Minute
+
+// StartTlsNotSupportedError is returned when the SMTP server does not support STARTTLS and the connection is not
+// allowed to be in clear
+var StartTlsNotSupportedError = errors.New("the SMTP server does not support STARTTLS")
+
+// SmtpSettings contains the parameters of the connection to the SMTP server
+type SmtpSettings struct {
+	Address  string // host:port of the server, e.g. smtp.uni.it:587
+	Username string // when empty the client does not authenticate
+	Password string
+	Insecure bool // allows to send the mails in clear to the servers that do not support STARTTLS
+}
+
+// SmtpSender sends the notifications through an SMTP server. The connection is shared by the notifications, so
+// the sends are serialized by a mutex
+type SmtpSender struct {
+	settings      SmtpSettings
+	mailTemplates mailtemplate.Set
+	mutex         sync.Mutex
+	client        *smtp.Client // open connection, nil until the first send or after a failure
+	// network connection of client, whose deadline is also set by the goroutine interrupting a cancelled send, so it
+	// is protected by its own mutex
+	deadlineMutex sync.Mutex
+	connection    net.Conn
+}
+
+// NewSmtpSender returns a Sender that renders the provided templates and sends the mails through the SMTP server
+func NewSmtpSender(settings SmtpSettings, mailTemplates mailtemplate.Set) *SmtpSender {
+	return &SmtpSender{settings: settings, mailTemplates: mailTemplates}
+}
+
+// setConnection sets the network connection whose deadline is renewed before each command
+func (sender *SmtpSender) setConnection(connection net.Conn) {
+	sender.deadlineMutex.Lock()
+	defer sender.deadlineMutex.Unlock()
+	sender.connection = connection
+}
+
+// renewDeadline gives smtpCommandTimeout to the next command, or less when the deadline of ctx is nearer. When ctx
+// has been cancelled the deadline is already expired, so the command fails at once
+func (sender *SmtpSender) renewDeadline(ctx context.Context) {
+	sender.deadlineMutex.Lock()
+	defer sender.deadlineMutex.Unlock()
+	if sender.connection == nil {
+		return
+	}
+	deadline := time.Now().Add(smtpCommandTimeout)
+	if ctx.Err() != nil {
+		deadline = time.Now()
+	} else if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	sender.connection.SetDeadline(deadline)
+}
+
+// interruptOnCancel makes the command in progress fail as soon as ctx is cancelled, until stop is closed
+func (sender *SmtpSender) interruptOnCancel(ctx context.Context, stop <-chan struct{}) {
+	select {
+	case <-ctx.Done():
+		sender.renewDeadline(ctx)
+	case <-stop:
+	}
+}
+
+// connect opens a connection to the server, upgrading it with STARTTLS and authenticating when credentials are set
+func (sender *SmtpSender) connect(ctx context.Context) (*smtp.Client, error) {
+	host, _, err := net.SplitHostPort(sender.settings.Address)
+	if err != nil {
+		return nil, err
+	}
+	dialer := net.Dialer{Timeout: smtpDialTimeout}
+	connection, err := dialer.DialContext(ctx, "tcp", sender.settings.Address)
+	if err != nil {
+		return nil, err
+	}
+	sender.setConnection(connection)
+	sender.renewDeadline(ctx)
+	client, err := smtp.NewClient(connection, host)
+	if err != nil {
+		connection.Close()
+		sender.setConnection(nil)
+		return nil, err
+	}
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		err = client.StartTLS(&tls.Config{ServerName: host})
+	} else if !sender.settings.Insecure {
+		err = StartTlsNotSupportedError
+	}
+	if err == nil && sender.settings.Username != "" {
+		err = client.Auth(smtp.PlainAuth("", sender.settings.Username, sender.settings.Password, host))
+	}
+	if err != nil {
+		client.Close()
+		sender.setConnection(nil)
+		return nil, err
+	}
+	return client, nil
+}
+
+// openClient returns the open connection, checking that the server did not close it, or opens a new one.
+// The caller must hold the mutex
+func (sender *SmtpSender) openClient(ctx context.Context) (*smtp.Client, error) {
+	if sender.client != nil {
+		sender.renewDeadline(ctx)
+		if sender.client.Noop() == nil {
+			return sender.client, nil
+		}
+		sender.closeConnection()
+	}
+	client, err := sender.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sender.client = client
+	return client, nil
+}
+
+// closeConnection drops the connection after an error that left it in an unknown state. The caller must hold the mutex
+func (sender *SmtpSender) closeConnection() {
+	if sender.client != nil {
+		sender.client.Close()
+		sender.client = nil
+		sender.setConnection(nil)
+	}
+}
+
+// Close ends the session with the SMTP server
+func (sender *SmtpSender) Close() error {
+	sender.mutex.Lock()
+	defer sender.mutex.Unlock()
+	if sender.client == nil {
+		return nil
+	}
+	sender.renewDeadline(context.Background())
+	err := sender.client.Quit()
+	sender.client = nil
+	sender.setConnection(nil)
+	return err
+}
+
+// sendMail sends a single mail over the open connection
+func sendMail(client *smtp.Client, from string, to string, data []byte) error {
+	err := client.Mail(from)
+	if err != nil {
+		return err
+	}
+	err = client.Rcpt(to)
+	if err != nil {
+		return err
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(data)
+	if err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// isPermanentSmtpError tells if the server rejected the mail with a permanent negative reply (5xx), that would be
+// returned again by a retry
+func isPermanentSmtpError(err error) bool {
+	protocolError, ok := err.(*textproto.Error)
+	return ok && protocolError.Code >= 500
+}
+
+// sendToRecipients sends the mail to each recipient. The outcome of each recipient is stored in result, while the
+// recipients that can be retried are returned. The caller must hold the mutex
+func (sender *SmtpSender) sendToRecipients(ctx context.Context, mail mailMessage, to []string, result DeliveryResult) []string {
+	var retryableRecipients []string
+	for i, recipient := range to {
+		if ctx.Err() != nil {
+			for _, remainingRecipient := range to[i:] {
+				result.Failed[remainingRecipient] = ctx.Err().Error()
+			}
+			return retryableRecipients
+		}
+		client, err := sender.openClient(ctx)
+		if err != nil {
+			// the server cannot be reached, so the remaining recipients are retried later
+			log.Println(err)
+			for _, remainingRecipient := range to[i:] {
+				result.Failed[remainingRecipient] = err.Error()
+			}
+			return append(retryableRecipients, to[i:]...)
+		}
+		messageId := newMessageId(mail.From)
+		mail.To = recipient
+		sender.renewDeadline(ctx)
+		err = sendMail(client, mail.From, recipient, mail.encode(messageId, time.Now()))
+		if err == nil {
+			result.Delivered[recipient] = messageId
+			delete(result.Failed, recipient)
+			continue
+		}
+		log.Println(err)
+		result.Failed[recipient] = err.Error()
+		if _, ok := err.(*textproto.Error); ok {
+			// the server replied, so the connection can be reused after aborting the transaction
+			if client.Reset() != nil {
+				sender.closeConnection()
+			}
+		} else {
+			sender.closeConnection()
+		}
+		if !isPermanentSmtpError(err) {
+			retryableRecipients = append(retryableRecipients, recipient)
+		}
+	}
+	return retryableRecipients
+}
+
+// SendNotification renders the template with the content of the notification and sends a mail to each recipient.
+// The recipients whose mail has been refused with a temporary error, or has not been sent because of a connection
+// failure, are retried. The attachments are read before sending, so an attachment that cannot be read is reported
+// without sending the mail to anyone. Each command is given at most smtpCommandTimeout, and the command in progress
+// is interrupted when ctx is cancelled
+func (sender *SmtpSender) SendNotification(ctx context.Context, message entity.Notification, templateName string, from string, to []string) (DeliveryResult, error) {
+	message, err := LoadAttachments(ctx, message)
+	if err != nil {
+		return DeliveryResult{}, err
+	}
+	mail := newMailMessage(renderNotification(sender.mailTemplates.Get(templateName), message), message, from)
+	sender.mutex.Lock()
+	defer sender.mutex.Unlock()
+	stop := make(chan struct{})
+	defer close(stop)
+	go sender.interruptOnCancel(ctx, stop)
+	return deliverWithRetries(ctx, to, smtpBatchSize, func(recipients []string, result DeliveryResult) []string {
+		return sender.sendToRecipients(ctx, mail, recipients, result)
+	})
+}
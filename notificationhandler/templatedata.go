@@ -0,0 +1,103 @@
+package notificationhandler
+
+import (
+	"encoding/json"
+	"github.com/redefik/notificationmanagement/config"
+	"github.com/redefik/notificationmanagement/entity"
+	"github.com/redefik/notificationmanagement/mailtemplate"
+	"github.com/redefik/notificationmanagement/markdown"
+	"html"
+	"log"
+	"strings"
+)
+
+// Formats of the body of a notification
+const (
+	PlainFormat    = "plain"
+	MarkdownFormat = "markdown"
+)
+
+// IsValidFormat tells if the body of a notification can be written in the given format. An empty format is plain text
+func IsValidFormat(format string) bool {
+	return format == "" || format == PlainFormat || format == MarkdownFormat
+}
+
+// Encapsulates the parameters of the mail template. The body is provided twice: as it is for the TextPart and
+// HTML-escaped for the HtmlPart, so the text written by a professor is never interpreted as markup
+type templateData struct {
+	CourseName string `json:"courseName"`
+	Year       string `json:"year"`
+	Body       string `json:"body"`
+	HtmlBody   string `json:"htmlBody"`
+}
+
+// escapeHtmlBody escapes the special characters of HTML and converts the line breaks, that would be ignored by the
+// mail clients, into <br> elements
+func escapeHtmlBody(body string) string {
+	escapedBody := html.EscapeString(strings.Replace(body, "\r\n", "\n", -1))
+	return strings.Replace(escapedBody, "\n", "<br>\n", -1)
+}
+
+// newTemplateData returns the parameters of the mail template for the provided notification. A Markdown body is
+// rendered to safe HTML and to a readable text alternative, while the bodies in an unknown format are sent as plain
+// text rather than dropped
+func newTemplateData(message entity.Notification) templateData {
+	if message.Format == MarkdownFormat {
+		htmlBody, textBody := markdown.Render(message.Message)
+		return templateData{
+			CourseName: message.Name,
+			Year:       message.Year,
+			Body:       textBody,
+			HtmlBody:   htmlBody,
+		}
+	}
+	if !IsValidFormat(message.Format) {
+		log.Println("unknown notification format", message.Format, "sent as plain text")
+	}
+	return templateData{
+		CourseName: message.Name,
+		Year:       message.Year,
+		Body:       message.Message,
+		HtmlBody:   escapeHtmlBody(message.Message),
+	}
+}
+
+// parameters returns the parameters indexed by the names used in the template
+func (data templateData) parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"courseName": data.CourseName,
+		"year":       data.Year,
+		"body":       data.Body,
+		"htmlBody":   data.HtmlBody,
+	}
+}
+
+// BuildTemplateData returns the JSON document containing the parameters of the mail template for the provided
+// notification. The template must insert htmlBody without escaping it again, i.e. as {{{htmlBody}}}
+func BuildTemplateData(message entity.Notification) (string, error) {
+	data, err := json.Marshal(newTemplateData(message))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// renderNotification builds locally the mail for the provided notification
+func renderNotification(mailTemplate *mailtemplate.MailTemplate, message entity.Notification) mailtemplate.RenderedMail {
+	return mailTemplate.Render(newTemplateData(message).parameters())
+}
+
+// PreviewNotification renders the notification with the version in the given language of the template named
+// templateName, falling back to the template file set in the configuration. The templates are read again at every
+// call so the changes can be previewed without restarting the microservice
+func PreviewNotification(message entity.Notification, templateName string, language string) (mailtemplate.RenderedMail, error) {
+	mailTemplates, err := mailtemplate.LoadSet(config.Configuration.MailTemplateFile, config.Configuration.TemplateDirectory)
+	if err != nil {
+		return mailtemplate.RenderedMail{}, err
+	}
+	templateName = localizedTemplateName(templateName, language, func(name string) bool {
+		_, ok := mailTemplates.Named[name]
+		return ok
+	})
+	return renderNotification(mailTemplates.Get(templateName), message), nil
+}
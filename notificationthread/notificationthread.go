@@ -1,18 +1,58 @@
 package notificationthread
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/redefik/notificationmanagement/config"
 	"github.com/redefik/notificationmanagement/coursehandler"
+	"github.com/redefik/notificationmanagement/deadletter"
 	"github.com/redefik/notificationmanagement/entity"
 	"github.com/redefik/notificationmanagement/notificationhandler"
 	"github.com/redefik/notificationmanagement/sqswrapper"
 	"log"
 	"math/rand"
+	"strconv"
+	"sync"
 	"time"
 )
 
-// polls an Amazon SQS message queue searching for e-mail to send to the subscribers of a course
-func Run() {
+// Maximum number of messages returned by SQS for each poll
+const maxMessagesPerPoll = 10
+
+// Number of workers used when NotificationWorkers is not configured
+const defaultWorkers = 4
+
+// Number of recipients to which a notification is sent before its progress is recorded
+const deliveryChunkSize = 500
+
+// Visibility timeout of the messages used when VisibilityTimeout is not configured
+const defaultVisibilityTimeout = 60
+
+// Number of receives after which a message is dead-lettered when MaxReceiveCount is not configured
+const defaultMaxReceiveCount = 10
+
+// Seconds after which a message that failed transiently is retried the first time, and maximum delay reached by
+// doubling it at each receive
+const retryBackoff = 30
+const maxRetryBackoff = 15 * 60
+
+// InvalidNotificationError is returned for the messages that do not identify the course of the notification
+var InvalidNotificationError = errors.New("the notification does not identify a course")
+
+// consumer contains the state shared by the poller and by the workers
+type consumer struct {
+	sqsClient *sqs.SQS
+	queueUrl  string
+	sendCtx   context.Context       // cancelled when the notifications being sent must be interrupted
+	heartbeat *sqswrapper.Heartbeat // renews the visibility timeout of the messages read and not yet removed or released
+}
+
+// Run polls an Amazon SQS message queue searching for e-mail to send to the subscribers of a course, until ctx is
+// cancelled. Then it stops polling and waits for the notifications being sent, that are interrupted if they are not
+// completed within drainTimeout
+func Run(ctx context.Context, drainTimeout time.Duration) {
 	log.Println("Notification Thread launched...")
 	sqsClient := sqswrapper.GetSqsClient()
 	queueUrl, err := sqswrapper.GetMessageQueueUrl(sqsClient, config.Configuration.MessageQueueName)
@@ -21,50 +61,362 @@ func Run() {
 		return
 	}
 	log.Println("Queue url", queueUrl)
+	err = deadletter.InitializeStore(sqsClient)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	sendCtx, cancelSend := context.WithCancel(context.Background())
+	defer cancelSend()
+	notificationConsumer := &consumer{sqsClient: sqsClient, queueUrl: queueUrl, sendCtx: sendCtx,
+		heartbeat: sqswrapper.NewHeartbeat(sqsClient, queueUrl, visibilityTimeout())}
+	// the heartbeat runs until every worker has stopped
+	heartbeatCtx, stopHeartbeat := context.WithCancel(context.Background())
+	defer stopHeartbeat()
+	go notificationConsumer.heartbeat.Run(heartbeatCtx)
+	// The notifications are sent by a pool of workers. Each job contains the messages of a message group read by a
+	// poll, that are processed in order by the same worker. SQS does not return the messages of a group while a
+	// message of the same group is being processed, so the notifications of a course are sent in the order in which
+	// they have been queued
+	jobs := make(chan []*sqs.Message)
+	var workersDone sync.WaitGroup
+	for worker := 0; worker < workers(); worker++ {
+		workersDone.Add(1)
+		go func() {
+			defer workersDone.Done()
+			notificationConsumer.processJobs(jobs)
+		}()
+	}
 	// Queue polling starts
 	// When there aren't messages in the queue, the thread sleep for n*PollingWaitTime seconds before retrying, where
 	// n is a random number in the range [0,i] and i is the number of times the polling resulted in no message read.
 	// The maximum value of i is 6. (The algorithm is similar to the binary exponential backoff used in Ethernet to avoid
 	// collisions).
-	i := 0 // number of empty responses
-	for {
-		var message entity.Notification
+	i := 0 // number of consecutive empty or failed polls
+	for ctx.Err() == nil {
 		log.Println("Polling...")
-		readMessages, messageHandler, err := sqswrapper.ReadJsonMessageFromQueue(sqsClient, queueUrl, &message, config.Configuration.PollingWaitTime)
-		if err != nil {
-			log.Println(err)
+		receivedMessages, err := sqswrapper.ReceiveMessagesFromQueue(ctx, sqsClient, queueUrl, maxMessagesPerPoll,
+			config.Configuration.PollingWaitTime, visibilityTimeout())
+		if err != nil && ctx.Err() != nil {
 			continue
 		}
-		if readMessages == 0 {
+		// A failed poll is retried with the same back-off, so a persistent error (e.g. the queue has been deleted)
+		// does not make the thread spin. In that case the thread sleeps at least a second
+		if err != nil || len(receivedMessages) == 0 {
 			if i < 6 {
 				i++
 			}
 			n := rand.Intn(i)
-			sleepingTime := time.Duration(n * int(config.Configuration.PollingWaitTime))
+			sleepingTime := time.Duration(n*int(config.Configuration.PollingWaitTime)) * time.Second
+			if err != nil {
+				log.Println(err)
+				if sleepingTime < time.Second {
+					sleepingTime = time.Second
+				}
+			}
 			log.Println("Sleep before retrying...")
-			time.Sleep(sleepingTime * time.Second)
+			select {
+			case <-time.After(sleepingTime):
+			case <-ctx.Done():
+			}
 			continue
 		}
 		i = 0 // After a successful read , the back-off algorithm is reset
-		log.Println("Sending notification requests...")
-		// send a mail containing the notification to the mailing list of the course
-		course := entity.Course{Name: message.Name, Department: message.Department, Year: message.Year}
-		mailingList, err := coursehandler.GetCourseMailingList(course)
-		if err != nil {
-			log.Println("error in getting course mailing list", err)
-			continue
+		// the messages are kept hidden also while they wait for a free worker
+		notificationConsumer.heartbeat.Add(receivedMessages)
+		// the poll blocks until a worker is free, so no more than workers() jobs are processed at the same time
+		groups := sqswrapper.GroupMessages(receivedMessages)
+		for k, group := range groups {
+			select {
+			case jobs <- group:
+				continue
+			case <-ctx.Done():
+			}
+			// the messages that have not been dispatched are given back to the queue for the other consumers
+			for _, remainingGroup := range groups[k:] {
+				notificationConsumer.releaseMessages(remainingGroup)
+			}
+			break
+		}
+	}
+	close(jobs)
+	log.Println("Waiting for the notifications being sent...")
+	drainTimer := time.AfterFunc(drainTimeout, cancelSend)
+	defer drainTimer.Stop()
+	workersDone.Wait()
+	log.Println("Notification Thread stopped")
+}
+
+// visibilityTimeout returns the number of seconds for which the messages in progress are hidden
+func visibilityTimeout() int64 {
+	if config.Configuration.VisibilityTimeout > 0 {
+		return config.Configuration.VisibilityTimeout
+	}
+	return defaultVisibilityTimeout
+}
+
+// workers returns the number of workers sending the notifications
+func workers() int {
+	if config.Configuration.NotificationWorkers > 0 {
+		return config.Configuration.NotificationWorkers
+	}
+	return defaultWorkers
+}
+
+// processJobs processes the messages of each job in order. When a message is not removed from the queue, because it
+// failed transiently or because the sending has been interrupted, it is released together with the following ones.
+// A message that failed is retried after a delay growing with the number of its receives, so a notification that
+// keeps failing is not sent again and again without pause. The following messages are released at once: SQS returns
+// them only after the failed one, so their order is kept
+func (notificationConsumer *consumer) processJobs(jobs <-chan []*sqs.Message) {
+	for job := range jobs {
+		for k, receivedMessage := range job {
+			if notificationConsumer.sendCtx.Err() != nil {
+				notificationConsumer.releaseMessages(job[k:])
+				break
+			}
+			if !notificationConsumer.handleMessage(receivedMessage) {
+				// the messages interrupted by the shutdown are given back at once to the other consumers
+				var delay int64
+				if notificationConsumer.sendCtx.Err() == nil {
+					delay = sqswrapper.RetryVisibilityTimeout(receivedMessage, retryBackoff, maxRetryBackoff)
+				}
+				notificationConsumer.releaseMessage(receivedMessage, delay)
+				notificationConsumer.releaseMessages(job[k+1:])
+				break
+			}
+		}
+	}
+}
+
+// releaseMessage makes the message visible again after delay seconds
+func (notificationConsumer *consumer) releaseMessage(receivedMessage *sqs.Message, delay int64) {
+	err := notificationConsumer.heartbeat.Release(context.Background(), receivedMessage, delay)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// releaseMessages makes the messages visible again, so that they are read by another consumer without waiting for the
+// end of their visibility timeout
+func (notificationConsumer *consumer) releaseMessages(receivedMessages []*sqs.Message) {
+	for _, receivedMessage := range receivedMessages {
+		notificationConsumer.releaseMessage(receivedMessage, 0)
+	}
+}
+
+// handleMessage processes a message and removes it from the queue, unless it failed transiently. It tells if the
+// message has been removed, otherwise it must be released by the caller
+func (notificationConsumer *consumer) handleMessage(receivedMessage *sqs.Message) bool {
+	err := ProcessMessage(notificationConsumer.sendCtx, receivedMessage)
+	if err != nil {
+		log.Println("error in processing notification", err)
+		// a message interrupted by the shutdown is not broken, so it is released even if it has been received many
+		// times, and it is resumed by another consumer
+		if isInterrupted(notificationConsumer.sendCtx, err) {
+			return false
 		}
-		err = notificationhandler.SendNotificationToMultipleRecipients(message, config.Configuration.MailAddress, mailingList)
+		if !isPermanentFailure(err) && !isExhausted(receivedMessage) {
+			return false
+		}
+		err = deadLetter(receivedMessage, err)
 		if err != nil {
+			log.Println("couldn't move the message to the dead-letter store", err)
+			return false
+		}
+	}
+	// If the notification has been sent correctly, or it has been dead-lettered, the message is removed from the
+	// message queue. This is made to prevent other consumers from reading again the message. The message is removed
+	// also during the shutdown, so its context is not the one of the sending
+	notificationConsumer.heartbeat.Remove(receivedMessage)
+	err = sqswrapper.DeleteMessageFromQueue(context.Background(), notificationConsumer.sqsClient,
+		notificationConsumer.queueUrl, receivedMessage.ReceiptHandle)
+	if err != nil {
+		log.Println("couldn't delete the message from the queue")
+	}
+	return true
+}
+
+// isValidNotification tells if the notification identifies a course
+func isValidNotification(message entity.Notification) bool {
+	return message.Name != "" && message.Department != "" && message.Year != ""
+}
+
+// ProcessMessage sends the notification contained in the message to the mailing list of the course, skipping the
+// students who already received it when the message has been processed before. The sending is interrupted when ctx
+// is cancelled
+func ProcessMessage(ctx context.Context, receivedMessage *sqs.Message) error {
+	var message entity.Notification
+	err := sqswrapper.ParseJsonMessage(receivedMessage, &message)
+	if err != nil {
+		return err
+	}
+	if !isValidNotification(message) {
+		return InvalidNotificationError
+	}
+	// The delivery is recorded under the id of the message, or of the original message when the notification has been
+	// redriven from the dead-letter store. The deduplication id of the FIFO queues is not used: with content-based
+	// deduplication two identical notifications queued days apart would share it, while the copies queued within five
+	// minutes are already dropped by SQS
+	notificationId := sqswrapper.NotificationId(receivedMessage)
+	delivery, err := coursehandler.Repository.GetDelivery(notificationId)
+	if err != nil {
+		log.Println("error in getting the delivery of the notification", err)
+		return err
+	}
+	if delivery.Completed {
+		log.Println("Notification", notificationId, "already delivered")
+		return nil
+	}
+	log.Println("Sending notification requests...")
+	// send a mail containing the notification to the mailing list of the course
+	course := entity.Course{Name: message.Name, Department: message.Department, Year: message.Year}
+	mailingList, err := coursehandler.Repository.GetCourseMailingList(course)
+	if err != nil {
+		log.Println("error in getting course mailing list", err)
+		return err
+	}
+	courseTemplate, err := coursehandler.Repository.GetCourseTemplate(course)
+	if err != nil {
+		log.Println("error in getting course template", err)
+		return err
+	}
+	templateName := notificationhandler.ResolveTemplateName(courseTemplate, course.Department)
+	courseLanguage, err := coursehandler.Repository.GetCourseLanguage(course)
+	if err != nil {
+		log.Println("error in getting course language", err)
+		return err
+	}
+	recipients := delivery.PendingRecipients(mailingList)
+	if len(recipients) < len(mailingList) {
+		log.Println("Resuming notification", notificationId, "already delivered to",
+			len(mailingList)-len(recipients), "recipients")
+	}
+	locales, err := coursehandler.Repository.GetStudentLocales(recipients)
+	if err != nil {
+		log.Println("error in getting student locales", err)
+		return err
+	}
+	// the attachments are read once, before the notification is sent to the groups of recipients
+	message, err = notificationhandler.LoadAttachments(ctx, message)
+	if err != nil {
+		log.Println("error in reading the attachments", err)
+		return err
+	}
+	// The recipients are sent the notification in chunks, recording the progress after each of them, so a message
+	// read again after a failure or an interruption is sent only to the students that have not received it yet
+	delivered := 0
+	failed := make(map[string]string)
+	rejected := true // every chunk has been rejected permanently
+	for start := 0; start < len(recipients); start += deliveryChunkSize {
+		end := start + deliveryChunkSize
+		if end > len(recipients) {
+			end = len(recipients)
+		}
+		deliveryResult, err := notificationhandler.SendLocalizedNotification(ctx, notificationhandler.MailSender, message,
+			templateName, courseLanguage, locales, config.Configuration.MailAddress, recipients[start:end])
+		if err != notificationhandler.RejectedError {
+			rejected = false
+		}
+		if err != nil && err != notificationhandler.NotDeliveredError && err != notificationhandler.RejectedError {
 			log.Println("error in sending notification", err)
-			continue
+			return err
 		}
-		// If the notification has been sent correctly, the message is removed from the message queue.
-		// This is made to prevent other consumers from reading again the message
-		err = sqswrapper.DeleteMessageFromQueue(sqsClient, queueUrl, messageHandler)
-		if err != nil {
-			log.Println("couldn't delete the message from the queue")
+		recordDelivery(notificationId, deliveryResult.Delivered)
+		delivered += len(deliveryResult.Delivered)
+		for recipient, reason := range deliveryResult.Failed {
+			failed[recipient] = reason
+		}
+		if ctx.Err() != nil {
+			// the message is released and the remaining recipients are sent the notification when it is read again
+			return ctx.Err()
 		}
+	}
+	if delivered == 0 && len(failed) > 0 {
+		err = notificationhandler.NotDeliveredError
+		if rejected {
+			err = notificationhandler.RejectedError
+		}
+		log.Println("error in sending notification", err)
+		return err
+	}
+	err = coursehandler.Repository.CompleteDelivery(notificationId)
+	if err != nil {
+		log.Println("couldn't record the delivery of notification", notificationId, err)
+	}
+	log.Println("Notification delivered to", delivered, "of", len(recipients), "recipients")
+	// The recipients that have not been reached after the retries are only reported: sending the notification
+	// again would deliver it twice to the other recipients
+	for recipient, reason := range failed {
+		log.Println("notification not delivered to", recipient, "-", reason)
+	}
+	return nil
+}
+
+// recordDelivery adds the recipients that received the notification to its delivery. A failure is only logged: the
+// notification has been sent anyway, and at worst the recipients receive it again if the message is read again
+func recordDelivery(notificationId string, deliveredRecipients map[string]string) {
+	if len(deliveredRecipients) == 0 {
+		return
+	}
+	studentMails := make([]string, 0, len(deliveredRecipients))
+	for recipient := range deliveredRecipients {
+		studentMails = append(studentMails, recipient)
+	}
+	err := coursehandler.Repository.AddDeliveredRecipients(notificationId, studentMails)
+	if err != nil {
+		log.Println("couldn't record the recipients of notification", notificationId, err)
+	}
+}
 
+// isPermanentFailure tells if the processing of a message failed for a reason that would cause the same failure at
+// every attempt: the body is not a valid notification, the course does not exist, an attachment cannot be sent or
+// every recipient rejected the notification permanently. The other failures, such as the errors of the course store
+// or the throttling of the mail sender, are transient
+func isPermanentFailure(err error) bool {
+	switch err.(type) {
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+		return true
+	}
+	switch err {
+	case InvalidNotificationError, coursehandler.NotFoundError, notificationhandler.InvalidAttachmentError,
+		notificationhandler.AttachmentTooLargeError, notificationhandler.RejectedError:
+		return true
+	}
+	return false
+}
+
+// isInterrupted tells if the processing of a message failed because the sending has been cancelled
+func isInterrupted(ctx context.Context, err error) bool {
+	return ctx.Err() != nil || err == context.Canceled || err == context.DeadlineExceeded
+}
+
+// isExhausted tells if the message has been received config.Configuration.MaxReceiveCount times, defaultMaxReceiveCount
+// when it is not configured
+func isExhausted(receivedMessage *sqs.Message) bool {
+	maxReceiveCount := config.Configuration.MaxReceiveCount
+	if maxReceiveCount <= 0 {
+		maxReceiveCount = defaultMaxReceiveCount
+	}
+	return sqswrapper.ReceiveCount(receivedMessage) >= maxReceiveCount
+}
+
+// deadLetter moves the message to the dead-letter store together with the reason of the failure. When no store is
+// configured the message is only logged, so that it can be recovered from the logs
+func deadLetter(receivedMessage *sqs.Message, failure error) error {
+	reason := failure.Error()
+	if !isPermanentFailure(failure) {
+		reason = "not sent after " + strconv.FormatInt(sqswrapper.ReceiveCount(receivedMessage), 10) + " attempts: " + reason
+	}
+	letter := deadletter.NewLetter(receivedMessage, reason)
+	if deadletter.Store == nil {
+		log.Println("dropping notification", letter.Body, "-", letter.Reason)
+		return nil
+	}
+	err := deadletter.Store.Put(letter)
+	if err != nil {
+		return err
 	}
+	log.Println("notification moved to the dead-letter store as", letter.Id, "-", letter.Reason)
+	return nil
 }
@@ -0,0 +1,228 @@
+package resthandler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"github.com/redefik/notificationmanagement/coursehandler"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Limits of the import of a mailing list: the size of the request body and the number of mails
+const maxImportBodySize = 1 << 20
+const maxImportRows = 10000
+
+// Media types accepted by the import and produced by the export of a mailing list
+const csvMediaType = "text/csv"
+const jsonMediaType = "application/json"
+
+// Names recognized as the header of the column containing the mails in an imported CSV file
+var csvMailHeaders = []string{"mail", "email", "e-mail", "studentmail", "student_mail"}
+
+// Status of a row of an imported mailing list
+const (
+	importSubscribed        = "subscribed"
+	importAlreadySubscribed = "already subscribed"
+	importInvalidMail       = "invalid mail"
+	importFailed            = "failed"
+)
+
+// importedMail is a mail read from an imported mailing list, together with its position in the file. For CSV files
+// the position is the number of the record, header included
+type importedMail struct {
+	Row  int    `json:"row"`
+	Mail string `json:"mail"`
+}
+
+// importRowReport describes the outcome of the import of a mail
+type importRowReport struct {
+	importedMail
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"` // reason of the failure, set only when the status is failed
+}
+
+// importReport is the body of the response to the import of a mailing list
+type importReport struct {
+	Subscribed int               `json:"subscribed"`
+	Rejected   int               `json:"rejected"` // rows with any status other than subscribed
+	Rows       []importRowReport `json:"rows"`
+}
+
+// importedMailingList is the JSON document accepted by the import, the same produced by the JSON export
+type importedMailingList struct {
+	Students []string `json:"students"`
+}
+
+var tooManyRowsError = errors.New("too many rows")
+
+// readCsvMails reads the mails from a CSV file. If the first record contains one of csvMailHeaders it is taken as a
+// header naming the column of the mails, otherwise the mails are read from the first column of every record
+func readCsvMails(body io.Reader) ([]importedMail, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	mailColumn := 0
+	var mails []importedMail
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return mails, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if row == 1 {
+			if column := findMailHeader(record); column >= 0 {
+				mailColumn = column
+				continue
+			}
+		}
+		if len(mails) == maxImportRows {
+			return nil, tooManyRowsError
+		}
+		mail := ""
+		if mailColumn < len(record) {
+			mail = strings.TrimSpace(record[mailColumn])
+		}
+		mails = append(mails, importedMail{Row: row, Mail: mail})
+	}
+}
+
+// findMailHeader returns the index of the field of the record that names the column of the mails, or -1
+func findMailHeader(record []string) int {
+	for i, field := range record {
+		for _, header := range csvMailHeaders {
+			if strings.EqualFold(strings.TrimSpace(field), header) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// readJsonMails reads the mails from a JSON document like {"students": ["student@uni.it"]}
+func readJsonMails(body io.Reader) ([]importedMail, error) {
+	var mailingList importedMailingList
+	err := json.NewDecoder(body).Decode(&mailingList)
+	if err != nil {
+		return nil, err
+	}
+	if len(mailingList.Students) > maxImportRows {
+		return nil, tooManyRowsError
+	}
+	mails := make([]importedMail, 0, len(mailingList.Students))
+	for i, mail := range mailingList.Students {
+		mails = append(mails, importedMail{Row: i + 1, Mail: strings.TrimSpace(mail)})
+	}
+	return mails, nil
+}
+
+// ImportCourseSubscriptions subscribes to the course identified by the query parameters the mails contained in the
+// body of the request, a CSV file (Content-Type text/csv) or a JSON document. The response reports the outcome of
+// every row: the invalid mails are skipped, while the valid ones are subscribed in batches
+func ImportCourseSubscriptions(w http.ResponseWriter, r *http.Request) {
+	course := courseFromQuery(r)
+	if !isValidBody(course) {
+		MakeErrorResponse(w, http.StatusBadRequest, "Bad request")
+		log.Println("Bad Request")
+		return
+	}
+
+	// Parse the body of the request according to its media type
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	body := http.MaxBytesReader(w, r.Body, maxImportBodySize)
+	var mails []importedMail
+	var err error
+	switch mediaType {
+	case csvMediaType:
+		mails, err = readCsvMails(body)
+	case jsonMediaType, "":
+		mails, err = readJsonMails(body)
+	default:
+		MakeErrorResponse(w, http.StatusUnsupportedMediaType, "Unsupported Media Type")
+		log.Println("Unsupported Media Type", mediaType)
+		return
+	}
+	if err == tooManyRowsError {
+		MakeErrorResponse(w, http.StatusRequestEntityTooLarge, "Too many students")
+		log.Println(err)
+		return
+	}
+	if err != nil {
+		MakeErrorResponse(w, http.StatusBadRequest, "Bad request")
+		log.Println(err)
+		return
+	}
+
+	// Only the valid mails are sent to the data store
+	report := importReport{Rows: make([]importRowReport, len(mails))}
+	var validMails []string
+	var validRows []int
+	for i, mail := range mails {
+		report.Rows[i].importedMail = mail
+		if !isValidMail(mail.Mail) {
+			report.Rows[i].Status = importInvalidMail
+			continue
+		}
+		validMails = append(validMails, mail.Mail)
+		validRows = append(validRows, i)
+	}
+	results, err := coursehandler.Repository.AddStudents(course, validMails)
+	if err != nil {
+		// On error an appropriated status code is returned
+		if err == coursehandler.NotFoundError {
+			MakeErrorResponse(w, http.StatusNotFound, "Course Not Found")
+			log.Println(err)
+			return
+		}
+		MakeErrorResponse(w, http.StatusInternalServerError, "Internal Server Error")
+		log.Println(err)
+		return
+	}
+	for i, result := range results {
+		row := &report.Rows[validRows[i]]
+		switch result {
+		case nil:
+			row.Status = importSubscribed
+		case coursehandler.ConflictMailError:
+			row.Status = importAlreadySubscribed
+		case coursehandler.NotFoundError:
+			row.Status = importFailed
+			row.Error = "Course Not Found"
+		case coursehandler.ConcurrentUpdateError:
+			row.Status = importFailed
+			row.Error = "Concurrent update, retry"
+		default:
+			row.Status = importFailed
+			row.Error = "Internal Server Error"
+		}
+	}
+	for _, row := range report.Rows {
+		if row.Status == importSubscribed {
+			report.Subscribed++
+		} else {
+			report.Rejected++
+		}
+	}
+	// On success 200 OK is returned together with the report, even if some rows have been rejected
+	makeJsonResponse(w, http.StatusOK, report)
+}
+
+// makeCsvMailingListResponse writes the mailing list as a CSV file with a single column, named mail
+func makeCsvMailingListResponse(w http.ResponseWriter, mailingList []string) {
+	w.Header().Set("Content-Type", csvMediaType)
+	w.WriteHeader(http.StatusOK)
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"mail"})
+	for _, mail := range mailingList {
+		writer.Write([]string{mail})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Println(err)
+	}
+}
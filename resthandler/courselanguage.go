@@ -0,0 +1,91 @@
+package resthandler
+
+import (
+	"encoding/json"
+	"github.com/redefik/notificationmanagement/coursehandler"
+	"github.com/redefik/notificationmanagement/entity"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// courseLanguage is the body of the requests and of the responses about the default language of a course
+type courseLanguage struct {
+	entity.Course
+	Language string `json:"language"`
+}
+
+// GetCourseLanguage returns the default language of the course identified by the query parameters
+func GetCourseLanguage(w http.ResponseWriter, r *http.Request) {
+	course := courseFromQuery(r)
+	if !isValidBody(course) {
+		MakeErrorResponse(w, http.StatusBadRequest, "Bad request")
+		log.Println("Bad Request")
+		return
+	}
+
+	language, err := coursehandler.Repository.GetCourseLanguage(course)
+	if err != nil {
+		// On error an appropriated status code is returned
+		if err == coursehandler.NotFoundError {
+			MakeErrorResponse(w, http.StatusNotFound, "Course Not Found")
+			log.Println(err)
+			return
+		} else {
+			MakeErrorResponse(w, http.StatusInternalServerError, "Internal Server Error")
+			log.Println(err)
+			return
+		}
+	}
+	// On success 200 OK is returned
+	makeJsonResponse(w, http.StatusOK, courseLanguage{Course: course, Language: language})
+}
+
+// SetCourseLanguage sets the default language of the course provided in the body of the request, that is the
+// language of the message field of its notifications. An empty language removes it
+func SetCourseLanguage(w http.ResponseWriter, r *http.Request) {
+	var requestBody courseLanguage
+
+	//Parse the body of the request
+	jsonDecoder := json.NewDecoder(r.Body)
+	err := jsonDecoder.Decode(&requestBody)
+	if err != nil {
+		MakeErrorResponse(w, http.StatusBadRequest, "Bad request")
+		log.Println("Bad Request")
+		return
+	}
+
+	// Check if all the required fields have been provided in the body
+	course := entity.Course{
+		Name:       strings.TrimSpace(requestBody.Name),
+		Department: strings.TrimSpace(requestBody.Department),
+		Year:       strings.TrimSpace(requestBody.Year),
+	}
+	if !isValidBody(course) {
+		MakeErrorResponse(w, http.StatusBadRequest, "Bad request")
+		log.Println("Bad Request")
+		return
+	}
+	language := coursehandler.NormalizeLocale(requestBody.Language)
+	if language != "" && !isValidLocale(language) {
+		MakeErrorResponse(w, http.StatusBadRequest, "Invalid Locale")
+		log.Println("Invalid Locale")
+		return
+	}
+
+	err = coursehandler.Repository.SetCourseLanguage(course, language)
+	if err != nil {
+		// On error an appropriated status code is returned
+		if err == coursehandler.NotFoundError {
+			MakeErrorResponse(w, http.StatusNotFound, "Course Not Found")
+			log.Println(err)
+			return
+		} else {
+			MakeErrorResponse(w, http.StatusInternalServerError, "Internal Server Error")
+			log.Println(err)
+			return
+		}
+	}
+	// On success 200 OK is returned together with the stored language
+	makeJsonResponse(w, http.StatusOK, courseLanguage{Course: course, Language: language})
+}
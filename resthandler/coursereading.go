@@ -0,0 +1,120 @@
+package resthandler
+
+import (
+	"encoding/json"
+	"github.com/redefik/notificationmanagement/coursehandler"
+	"github.com/redefik/notificationmanagement/entity"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Default and maximum number of courses returned in a page by ListCourses
+const defaultPageSize = 20
+const maxPageSize = 100
+
+// coursePage is the body of the response to a request for the list of the courses
+type coursePage struct {
+	Courses []entity.Course `json:"courses"`
+	Next    string          `json:"next,omitempty"` // token to be provided to obtain the next page
+}
+
+// courseMailingList is the body of the response to a request for the mailing list of a course
+type courseMailingList struct {
+	entity.Course
+	Students []string `json:"students"`
+}
+
+// makeJsonResponse generates an http response with the code specified and the JSON encoding of body
+func makeJsonResponse(w http.ResponseWriter, code int, body interface{}) {
+	responseBody, err := json.Marshal(body)
+	if err != nil {
+		MakeErrorResponse(w, http.StatusInternalServerError, "Internal Server Error")
+		log.Println(err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(responseBody)
+}
+
+// courseFromQuery reads the course identified by the query parameters name, department and year
+func courseFromQuery(r *http.Request) entity.Course {
+	query := r.URL.Query()
+	return entity.Course{
+		Name:       strings.TrimSpace(query.Get("name")),
+		Department: strings.TrimSpace(query.Get("department")),
+		Year:       strings.TrimSpace(query.Get("year")),
+	}
+}
+
+// ListCourses returns a page of the courses stored in the data store. The courses can be filtered by department
+// and year, the page size is set by the limit parameter and the following pages are requested providing the
+// next parameter returned with the previous page
+func ListCourses(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := coursehandler.CourseFilter{
+		Department: strings.TrimSpace(query.Get("department")),
+		Year:       strings.TrimSpace(query.Get("year")),
+	}
+	limit := defaultPageSize
+	if query.Get("limit") != "" {
+		var err error
+		limit, err = strconv.Atoi(query.Get("limit"))
+		if err != nil || limit <= 0 || limit > maxPageSize {
+			MakeErrorResponse(w, http.StatusBadRequest, "Bad request")
+			log.Println("Bad Request")
+			return
+		}
+	}
+
+	courses, nextPageToken, err := coursehandler.Repository.ListCourses(filter, query.Get("next"), limit)
+	if err != nil {
+		// On error an appropriated status code is returned
+		if err == coursehandler.InvalidPageTokenError {
+			MakeErrorResponse(w, http.StatusBadRequest, "Invalid page token")
+			log.Println(err)
+			return
+		}
+		MakeErrorResponse(w, http.StatusInternalServerError, "Internal Server Error")
+		log.Println(err)
+		return
+	}
+	// On success 200 OK is returned together with the page
+	makeJsonResponse(w, http.StatusOK, coursePage{Courses: courses, Next: nextPageToken})
+}
+
+// GetCourseMailingList returns the mailing list of the course identified by the query parameters. The mailing list
+// is exported as JSON or, when the format parameter is csv, as a CSV file that can be imported again
+func GetCourseMailingList(w http.ResponseWriter, r *http.Request) {
+	course := courseFromQuery(r)
+	format := r.URL.Query().Get("format")
+	if !isValidBody(course) || (format != "" && format != "json" && format != "csv") {
+		MakeErrorResponse(w, http.StatusBadRequest, "Bad request")
+		log.Println("Bad Request")
+		return
+	}
+
+	mailingList, err := coursehandler.Repository.GetCourseMailingList(course)
+	if err != nil {
+		// On error an appropriated status code is returned
+		if err == coursehandler.NotFoundError {
+			MakeErrorResponse(w, http.StatusNotFound, "Course Not Found")
+			log.Println(err)
+			return
+		}
+		MakeErrorResponse(w, http.StatusInternalServerError, "Internal Server Error")
+		log.Println(err)
+		return
+	}
+	if mailingList == nil {
+		mailingList = []string{}
+	}
+	// On success 200 OK is returned together with the mailing list
+	if format == "csv" {
+		makeCsvMailingListResponse(w, mailingList)
+		return
+	}
+	makeJsonResponse(w, http.StatusOK, courseMailingList{Course: course, Students: mailingList})
+}
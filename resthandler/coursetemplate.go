@@ -0,0 +1,115 @@
+package resthandler
+
+import (
+	"encoding/json"
+	"github.com/redefik/notificationmanagement/config"
+	"github.com/redefik/notificationmanagement/coursehandler"
+	"github.com/redefik/notificationmanagement/entity"
+	"github.com/redefik/notificationmanagement/mailtemplate"
+	"github.com/redefik/notificationmanagement/notificationhandler"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// courseTemplate is the body of the requests and of the responses about the mail template of a course. Template is
+// the template of the course, empty when the course uses the one of its department, while ResolvedTemplate is the
+// template actually used for its notifications
+type courseTemplate struct {
+	entity.Course
+	Template         string `json:"template"`
+	ResolvedTemplate string `json:"resolvedTemplate,omitempty"`
+}
+
+// makeCourseTemplateResponse returns the template of the course together with the one used for its notifications
+func makeCourseTemplateResponse(w http.ResponseWriter, course entity.Course, templateName string) {
+	makeJsonResponse(w, http.StatusOK, courseTemplate{
+		Course:           course,
+		Template:         templateName,
+		ResolvedTemplate: notificationhandler.ResolveTemplateName(templateName, course.Department),
+	})
+}
+
+// GetCourseTemplate returns the mail template of the course identified by the query parameters
+func GetCourseTemplate(w http.ResponseWriter, r *http.Request) {
+	course := courseFromQuery(r)
+	if !isValidBody(course) {
+		MakeErrorResponse(w, http.StatusBadRequest, "Bad request")
+		log.Println("Bad Request")
+		return
+	}
+
+	templateName, err := coursehandler.Repository.GetCourseTemplate(course)
+	if err != nil {
+		// On error an appropriated status code is returned
+		if err == coursehandler.NotFoundError {
+			MakeErrorResponse(w, http.StatusNotFound, "Course Not Found")
+			log.Println(err)
+			return
+		} else {
+			MakeErrorResponse(w, http.StatusInternalServerError, "Internal Server Error")
+			log.Println(err)
+			return
+		}
+	}
+	// On success 200 OK is returned
+	makeCourseTemplateResponse(w, course, templateName)
+}
+
+// SetCourseTemplate sets the mail template of the course provided in the body of the request. The template must be
+// defined in the template directory, so that it is uploaded to SES together with the others; an empty template
+// restores the template of the department
+func SetCourseTemplate(w http.ResponseWriter, r *http.Request) {
+	var requestBody courseTemplate
+
+	//Parse the body of the request
+	jsonDecoder := json.NewDecoder(r.Body)
+	err := jsonDecoder.Decode(&requestBody)
+	if err != nil {
+		MakeErrorResponse(w, http.StatusBadRequest, "Bad request")
+		log.Println("Bad Request")
+		return
+	}
+
+	// Check if all the required fields have been provided in the body
+	course := entity.Course{
+		Name:       strings.TrimSpace(requestBody.Name),
+		Department: strings.TrimSpace(requestBody.Department),
+		Year:       strings.TrimSpace(requestBody.Year),
+	}
+	if !isValidBody(course) {
+		MakeErrorResponse(w, http.StatusBadRequest, "Bad request")
+		log.Println("Bad Request")
+		return
+	}
+	templateName := strings.TrimSpace(requestBody.Template)
+	if templateName != "" {
+		templates, err := mailtemplate.LoadDirectory(config.Configuration.TemplateDirectory)
+		if err != nil {
+			MakeErrorResponse(w, http.StatusInternalServerError, "Internal Server Error - Invalid mail template")
+			log.Println(err)
+			return
+		}
+		if _, ok := templates[templateName]; !ok {
+			MakeErrorResponse(w, http.StatusBadRequest, "Unknown Template")
+			log.Println("Unknown template", templateName)
+			return
+		}
+	}
+
+	err = coursehandler.Repository.SetCourseTemplate(course, templateName)
+	if err != nil {
+		// On error an appropriated status code is returned
+		if err == coursehandler.NotFoundError {
+			MakeErrorResponse(w, http.StatusNotFound, "Course Not Found")
+			log.Println(err)
+			return
+		} else {
+			MakeErrorResponse(w, http.StatusInternalServerError, "Internal Server Error")
+			log.Println(err)
+			return
+		}
+	}
+	// On success 200 OK is returned together with the template used for the notifications of the course
+	makeCourseTemplateResponse(w, course, templateName)
+}
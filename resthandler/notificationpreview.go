@@ -0,0 +1,64 @@
+package resthandler
+
+import (
+	"encoding/json"
+	"github.com/redefik/notificationmanagement/coursehandler"
+	"github.com/redefik/notificationmanagement/entity"
+	"github.com/redefik/notificationmanagement/notificationhandler"
+	"log"
+	"net/http"
+)
+
+// PreviewNotification renders the notification provided in the body of the request with the local mail template of
+// the course, returning the subject and the text and HTML versions of the mail that would be sent to the students.
+// The courses that do not exist are previewed with the template of their department. The optional query parameter
+// locale selects the language of the previewed mail
+func PreviewNotification(w http.ResponseWriter, r *http.Request) {
+	var requestBody entity.Notification
+
+	//Parse the body of the request
+	jsonDecoder := json.NewDecoder(r.Body)
+	err := jsonDecoder.Decode(&requestBody)
+	if err != nil {
+		MakeErrorResponse(w, http.StatusBadRequest, "Bad request")
+		log.Println("Bad Request")
+		return
+	}
+	if !notificationhandler.IsValidFormat(requestBody.Format) {
+		MakeErrorResponse(w, http.StatusBadRequest, "Invalid Format")
+		log.Println("Invalid Format")
+		return
+	}
+
+	locale := coursehandler.NormalizeLocale(r.URL.Query().Get("locale"))
+	if locale != "" && !isValidLocale(locale) {
+		MakeErrorResponse(w, http.StatusBadRequest, "Invalid Locale")
+		log.Println("Invalid Locale")
+		return
+	}
+
+	course := entity.Course{Name: requestBody.Name, Department: requestBody.Department, Year: requestBody.Year}
+	courseTemplate, err := coursehandler.Repository.GetCourseTemplate(course)
+	if err != nil && err != coursehandler.NotFoundError {
+		MakeErrorResponse(w, http.StatusInternalServerError, "Internal Server Error")
+		log.Println(err)
+		return
+	}
+	courseLanguage, err := coursehandler.Repository.GetCourseLanguage(course)
+	if err != nil && err != coursehandler.NotFoundError {
+		MakeErrorResponse(w, http.StatusInternalServerError, "Internal Server Error")
+		log.Println(err)
+		return
+	}
+	templateName := notificationhandler.ResolveTemplateName(courseTemplate, course.Department)
+	language, localizedMessage := notificationhandler.LocalizeNotification(requestBody, locale, courseLanguage)
+	renderedMail, err := notificationhandler.PreviewNotification(localizedMessage, templateName, language)
+	if err != nil {
+		// The template file is missing or contains a syntax error
+		MakeErrorResponse(w, http.StatusInternalServerError, "Internal Server Error - Invalid mail template")
+		log.Println(err)
+		return
+	}
+	// On success 200 OK is returned together with the rendered mail
+	makeJsonResponse(w, http.StatusOK, renderedMail)
+}
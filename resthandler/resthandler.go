@@ -69,7 +69,7 @@ func NewCourse(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Try to create the course
-	err = coursehandler.CreateCourse(requestBody)
+	err = coursehandler.Repository.CreateCourse(requestBody)
 	if err != nil {
 		// On error an appropriated status code is returned
 		if err == coursehandler.ConflictError {
@@ -119,7 +119,7 @@ func DeleteCourse(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Try to create the course
-	err = coursehandler.DeleteCourse(requestBody)
+	err = coursehandler.Repository.DeleteCourse(requestBody)
 	if err != nil {
 		// On error an appropriated status code is returned
 		if err == coursehandler.NotFoundError {
@@ -186,7 +186,7 @@ func AddCourseSubscription(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Try to add the subscription
-	err = coursehandler.AddStudent(requestBody, studentMail)
+	err = coursehandler.Repository.AddStudent(requestBody, studentMail)
 	if err != nil {
 		// On error an appropriated status code is returned
 		if err == coursehandler.NotFoundError {
@@ -201,6 +201,12 @@ func AddCourseSubscription(w http.ResponseWriter, r *http.Request) {
 			MakeErrorResponse(w, http.StatusConflict, "Conflict - The mail has been already registered")
 			log.Println(err)
 			return
+		} else if err == coursehandler.ConcurrentUpdateError {
+			// The mailing list has not been modified, so the client can safely repeat the request
+			w.Header().Set("Retry-After", "1")
+			MakeErrorResponse(w, http.StatusServiceUnavailable, "Service Unavailable - Concurrent update, retry")
+			log.Println(err)
+			return
 		}
 	}
 	// On success 200 OK is returned
@@ -236,7 +242,7 @@ func RemoveCourseSubscription(w http.ResponseWriter, r *http.Request) {
 	studentMail := urlParameters["studentMail"]
 
 	// Try to remove the subscription
-	err = coursehandler.RemoveStudent(requestBody, studentMail)
+	err = coursehandler.Repository.RemoveStudent(requestBody, studentMail)
 	if err != nil {
 		// On error an appropriated status code is returned
 		if err == coursehandler.NotFoundError {
@@ -247,6 +253,12 @@ func RemoveCourseSubscription(w http.ResponseWriter, r *http.Request) {
 			MakeErrorResponse(w, http.StatusInternalServerError, "Internal Server Error")
 			log.Println(err)
 			return
+		} else if err == coursehandler.ConcurrentUpdateError {
+			// The mailing list has not been modified, so the client can safely repeat the request
+			w.Header().Set("Retry-After", "1")
+			MakeErrorResponse(w, http.StatusServiceUnavailable, "Service Unavailable - Concurrent update, retry")
+			log.Println(err)
+			return
 		}
 	}
 	// On success 200 OK is returned
@@ -0,0 +1,136 @@
+package resthandler
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/redefik/notificationmanagement/coursehandler"
+	"github.com/redefik/notificationmanagement/entity"
+	"log"
+	"net/http"
+	"regexp"
+)
+
+// studentCourses is the body of the response to the requests about the subscriptions of a student
+type studentCourses struct {
+	Student string          `json:"student"`
+	Courses []entity.Course `json:"courses"`
+}
+
+// studentLocale is the body of the requests and of the responses about the preferred locale of a student
+type studentLocale struct {
+	Student string `json:"student"`
+	Locale  string `json:"locale"`
+}
+
+// localePattern matches the normalized locales, made of a language code optionally followed by subtags (e.g. en-gb)
+var localePattern = regexp.MustCompile("^[a-z]{2,3}(-[a-z0-9]{2,8})*$")
+
+// isValidLocale tells if a locale normalized by coursehandler.NormalizeLocale is well formed
+func isValidLocale(locale string) bool {
+	return localePattern.MatchString(locale)
+}
+
+// studentMailFromPath reads the mail of the student from the URL. It returns false, after writing the error
+// response, if the mail is not valid
+func studentMailFromPath(w http.ResponseWriter, r *http.Request) (string, bool) {
+	studentMail := mux.Vars(r)["studentMail"]
+	if !isValidMail(studentMail) {
+		MakeErrorResponse(w, http.StatusBadRequest, "Invalid Mail")
+		log.Println("Invalid Mail")
+		return "", false
+	}
+	return coursehandler.NormalizeMail(studentMail), true
+}
+
+// GetStudentCourses returns the courses the student is subscribed to
+func GetStudentCourses(w http.ResponseWriter, r *http.Request) {
+	studentMail, ok := studentMailFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	courses, err := coursehandler.Repository.GetStudentCourses(studentMail)
+	if err != nil {
+		MakeErrorResponse(w, http.StatusInternalServerError, "Internal Server Error")
+		log.Println(err)
+		return
+	}
+	// On success 200 OK is returned together with the courses, possibly none
+	makeJsonResponse(w, http.StatusOK, studentCourses{Student: studentMail, Courses: courses})
+}
+
+// RemoveStudentSubscriptions unsubscribes the student from every course, e.g. to fulfil a request of erasure
+func RemoveStudentSubscriptions(w http.ResponseWriter, r *http.Request) {
+	studentMail, ok := studentMailFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	courses, err := coursehandler.Repository.RemoveStudentFromAllCourses(studentMail)
+	if err != nil {
+		// On error an appropriated status code is returned. The subscriptions already removed are not restored,
+		// so the client can simply repeat the request
+		if err == coursehandler.ConcurrentUpdateError {
+			w.Header().Set("Retry-After", "1")
+			MakeErrorResponse(w, http.StatusServiceUnavailable, "Service Unavailable - Concurrent update, retry")
+			log.Println(err)
+			return
+		}
+		MakeErrorResponse(w, http.StatusInternalServerError, "Internal Server Error")
+		log.Println(err)
+		return
+	}
+	// On success 200 OK is returned together with the courses the student has been unsubscribed from
+	makeJsonResponse(w, http.StatusOK, studentCourses{Student: studentMail, Courses: courses})
+}
+
+// GetStudentLocale returns the preferred locale of the student, empty when it has not been set
+func GetStudentLocale(w http.ResponseWriter, r *http.Request) {
+	studentMail, ok := studentMailFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	locales, err := coursehandler.Repository.GetStudentLocales([]string{studentMail})
+	if err != nil {
+		MakeErrorResponse(w, http.StatusInternalServerError, "Internal Server Error")
+		log.Println(err)
+		return
+	}
+	// On success 200 OK is returned together with the locale
+	makeJsonResponse(w, http.StatusOK, studentLocale{Student: studentMail, Locale: locales[studentMail]})
+}
+
+// SetStudentLocale stores the preferred locale of the student provided in the body of the request. An empty locale
+// removes the preference, so the student receives the notifications in the default language of each course
+func SetStudentLocale(w http.ResponseWriter, r *http.Request) {
+	studentMail, ok := studentMailFromPath(w, r)
+	if !ok {
+		return
+	}
+	var requestBody studentLocale
+
+	//Parse the body of the request
+	jsonDecoder := json.NewDecoder(r.Body)
+	err := jsonDecoder.Decode(&requestBody)
+	if err != nil {
+		MakeErrorResponse(w, http.StatusBadRequest, "Bad request")
+		log.Println("Bad Request")
+		return
+	}
+	locale := coursehandler.NormalizeLocale(requestBody.Locale)
+	if locale != "" && !isValidLocale(locale) {
+		MakeErrorResponse(w, http.StatusBadRequest, "Invalid Locale")
+		log.Println("Invalid Locale")
+		return
+	}
+
+	err = coursehandler.Repository.SetStudentLocale(studentMail, locale)
+	if err != nil {
+		MakeErrorResponse(w, http.StatusInternalServerError, "Internal Server Error")
+		log.Println(err)
+		return
+	}
+	// On success 200 OK is returned together with the stored locale
+	makeJsonResponse(w, http.StatusOK, studentLocale{Student: studentMail, Locale: locale})
+}
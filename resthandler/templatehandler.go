@@ -0,0 +1,132 @@
+package resthandler
+
+import (
+	"crypto/subtle"
+	"github.com/gorilla/mux"
+	"github.com/redefik/notificationmanagement/config"
+	"github.com/redefik/notificationmanagement/notificationhandler"
+	"log"
+	"net/http"
+	"strings"
+)
+
+/*Admin endpoints used to keep the SES templates aligned with the template files shipped with the microservice.
+They are enabled only when an AdminToken is configured, and the requests must carry it as a bearer token*/
+
+// templateList is the body of the responses describing many templates
+type templateList struct {
+	Templates []notificationhandler.TemplateDiff `json:"templates"`
+}
+
+// authorizeAdmin checks the bearer token of an admin request, writing the error response when the request cannot
+// go on
+func authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if config.Configuration.AdminToken == "" {
+		MakeErrorResponse(w, http.StatusForbidden, "Forbidden - Admin API disabled")
+		log.Println("Admin API disabled")
+		return false
+	}
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer ") ||
+		subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(authorization, "Bearer ")),
+			[]byte(config.Configuration.AdminToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		MakeErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+		log.Println("Unauthorized admin request")
+		return false
+	}
+	if notificationhandler.Templates == nil {
+		MakeErrorResponse(w, http.StatusNotImplemented, "Not Implemented - The notifications are not sent by SES")
+		log.Println("SES templates not available")
+		return false
+	}
+	return true
+}
+
+// ListTemplates compares the template files with the templates stored in SES
+func ListTemplates(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	diffs, err := notificationhandler.Templates.Diff()
+	if err != nil {
+		MakeErrorResponse(w, http.StatusInternalServerError, "Internal Server Error")
+		log.Println(err)
+		return
+	}
+	// On success 200 OK is returned together with the status of each template
+	makeJsonResponse(w, http.StatusOK, templateList{Templates: diffs})
+}
+
+// SyncTemplates uploads to SES the templates that are missing or changed. The templates that are not defined by any
+// file are deleted only when the query parameter prune is true
+func SyncTemplates(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	prune := r.URL.Query().Get("prune")
+	if prune != "" && prune != "true" && prune != "false" {
+		MakeErrorResponse(w, http.StatusBadRequest, "Bad request")
+		log.Println("Bad Request")
+		return
+	}
+	diffs, err := notificationhandler.Templates.Sync(prune == "true")
+	if err != nil {
+		MakeErrorResponse(w, http.StatusInternalServerError, "Internal Server Error - Synchronization interrupted")
+		log.Println(err)
+		return
+	}
+	// On success 200 OK is returned together with the action performed on each template
+	makeJsonResponse(w, http.StatusOK, templateList{Templates: diffs})
+}
+
+// UploadTemplate creates or updates in SES the template with the name provided in the URL
+func UploadTemplate(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	templateName := mux.Vars(r)["templateName"]
+	diff, err := notificationhandler.Templates.Upload(templateName)
+	if err != nil {
+		// On error an appropriated status code is returned
+		if err == notificationhandler.TemplateNotFoundError {
+			MakeErrorResponse(w, http.StatusNotFound, "Template Not Found")
+			log.Println(err)
+			return
+		} else {
+			MakeErrorResponse(w, http.StatusInternalServerError, "Internal Server Error")
+			log.Println(err)
+			return
+		}
+	}
+	// On success 200 OK is returned together with the action performed
+	makeJsonResponse(w, http.StatusOK, diff)
+}
+
+// DeleteTemplate removes from SES the template with the name provided in the URL. Only the templates that are not
+// defined in the template directory can be deleted, since the others may be used by a department, a course or a language
+func DeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	templateName := mux.Vars(r)["templateName"]
+	if templateName == config.Configuration.MailTemplate {
+		// The template is used to send the notifications
+		MakeErrorResponse(w, http.StatusConflict, "Conflict - The template is in use")
+		log.Println("Deletion of the template in use refused")
+		return
+	}
+	err := notificationhandler.Templates.Delete(templateName)
+	if err == notificationhandler.TemplateInUseError {
+		MakeErrorResponse(w, http.StatusConflict, "Conflict - The template is in use")
+		log.Println("Deletion of the template", templateName, "refused:", err)
+		return
+	}
+	if err != nil {
+		MakeErrorResponse(w, http.StatusInternalServerError, "Internal Server Error")
+		log.Println(err)
+		return
+	}
+	// On success 200 OK is returned
+	w.WriteHeader(http.StatusOK)
+}
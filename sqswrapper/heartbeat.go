@@ -0,0 +1,98 @@
+package sqswrapper
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"log"
+	"sync"
+	"time"
+)
+
+/*Heartbeat of the messages being processed. A notification sent to a large mailing list may take longer than the
+visibility timeout of its message, after which the message would be read and sent again by another replica. While
+a message is in progress its visibility timeout is renewed periodically, until it is removed from the queue or
+released*/
+
+// Heartbeat keeps hidden the messages read from a queue and not yet removed or released
+type Heartbeat struct {
+	sqsClient         sqsiface.SQSAPI
+	queueUrl          string
+	visibilityTimeout int64 // seconds for which the messages are hidden by each renewal
+	mutex             sync.Mutex
+	messages          map[*sqs.Message]bool
+}
+
+// NewHeartbeat returns a Heartbeat that hides the messages of the queue with the given url for visibilityTimeout
+// seconds at each renewal
+func NewHeartbeat(sqsClient sqsiface.SQSAPI, queueUrl string, visibilityTimeout int64) *Heartbeat {
+	return &Heartbeat{sqsClient: sqsClient, queueUrl: queueUrl, visibilityTimeout: visibilityTimeout,
+		messages: make(map[*sqs.Message]bool)}
+}
+
+// Add starts renewing the visibility timeout of the messages
+func (beat *Heartbeat) Add(receivedMessages []*sqs.Message) {
+	beat.mutex.Lock()
+	defer beat.mutex.Unlock()
+	for _, receivedMessage := range receivedMessages {
+		beat.messages[receivedMessage] = true
+	}
+}
+
+// Remove stops renewing the visibility timeout of the messages, that have been removed from the queue or released
+func (beat *Heartbeat) Remove(receivedMessages ...*sqs.Message) {
+	beat.mutex.Lock()
+	defer beat.mutex.Unlock()
+	for _, receivedMessage := range receivedMessages {
+		delete(beat.messages, receivedMessage)
+	}
+}
+
+// Release stops renewing the visibility timeout of the message and makes it visible again to the consumers of the
+// queue after visibilityTimeout seconds, immediately when it is 0
+func (beat *Heartbeat) Release(ctx context.Context, receivedMessage *sqs.Message, visibilityTimeout int64) error {
+	beat.Remove(receivedMessage)
+	return ExtendMessageVisibility(ctx, beat.sqsClient, beat.queueUrl, receivedMessage.ReceiptHandle, visibilityTimeout)
+}
+
+// Run renews the visibility timeout of the messages in progress three times per timeout, so that a slow or failed
+// renewal does not let them expire, until ctx is cancelled
+func (beat *Heartbeat) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(beat.visibilityTimeout) * time.Second / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		beat.Renew(ctx)
+	}
+}
+
+// Renew extends the visibility timeout of every message in progress
+func (beat *Heartbeat) Renew(ctx context.Context) {
+	beat.mutex.Lock()
+	receivedMessages := make([]*sqs.Message, 0, len(beat.messages))
+	for receivedMessage := range beat.messages {
+		receivedMessages = append(receivedMessages, receivedMessage)
+	}
+	beat.mutex.Unlock()
+	for _, receivedMessage := range receivedMessages {
+		beat.extend(ctx, receivedMessage)
+	}
+}
+
+// extend renews the visibility timeout of the message if it is still in progress. The lock is held during the
+// request, so a message is never hidden again after Remove returns, e.g. once it has been released
+func (beat *Heartbeat) extend(ctx context.Context, receivedMessage *sqs.Message) {
+	beat.mutex.Lock()
+	defer beat.mutex.Unlock()
+	if !beat.messages[receivedMessage] {
+		return
+	}
+	err := ExtendMessageVisibility(ctx, beat.sqsClient, beat.queueUrl, receivedMessage.ReceiptHandle, beat.visibilityTimeout)
+	if err != nil && ctx.Err() == nil {
+		log.Println(err)
+	}
+}
@@ -3,12 +3,16 @@ package sqswrapper
 /*This package contains a set of functions that wrap the API of Amazon Simple Queue Service SDK for Go*/
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 	"github.com/redefik/notificationmanagement/config"
+	"strconv"
+	"strings"
 )
 
 // GetSqsClient builds a *sqs.SQS object that can be used to make requests to AWS SQS
@@ -24,7 +28,7 @@ func GetSqsClient() *sqs.SQS {
 }
 
 // GetMessageQueueUrl returns the url of the SQS queue with the provided name
-func GetMessageQueueUrl(sqsClient *sqs.SQS, queueName string) (string, error) {
+func GetMessageQueueUrl(sqsClient sqsiface.SQSAPI, queueName string) (string, error) {
 	getQueueUrlOutput, err := sqsClient.GetQueueUrl(&sqs.GetQueueUrlInput{
 		QueueName: aws.String(queueName),
 	})
@@ -35,42 +39,162 @@ func GetMessageQueueUrl(sqsClient *sqs.SQS, queueName string) (string, error) {
 	return queueUrl, nil
 }
 
-// ReadMessageFromQueue try to read a message from the queue with the given url
-// using the provided client. The result, in JSON format, is stored in the message
-// structure provided by the caller.
-// waitTime is the duration (in seconds) for which the call waits for a message to arrive in the queue before returning
-// The function returns the number of read messages (0 or 1), the receipt handler of the message and an error
-func ReadJsonMessageFromQueue(sqsClient *sqs.SQS, queueUrl string, message interface{}, waitTime int64) (int, *string, error) {
+// ReceiveMessagesFromQueue try to read up to maxMessages messages (at most 10) from the queue with the given url using
+// the provided client. waitTime is the duration (in seconds) for which the call waits for a message to arrive in the
+// queue before returning, while visibilityTimeout is the duration for which the messages are hidden to the other
+// consumers (the default visibility timeout of the queue when 0).
+// The messages are returned together with their attributes, such as MessageGroupId and ApproximateReceiveCount.
+// The bodies are not parsed, so that the caller can remove from the queue also the messages that are not valid.
+// The cancellation of ctx interrupts the polling
+func ReceiveMessagesFromQueue(ctx context.Context, sqsClient sqsiface.SQSAPI, queueUrl string, maxMessages int64, waitTime int64,
+	visibilityTimeout int64) ([]*sqs.Message, error) {
 	receiveMessageInput := &sqs.ReceiveMessageInput{
-		QueueUrl:            &queueUrl,
-		MaxNumberOfMessages: aws.Int64(1),
-		WaitTimeSeconds:     aws.Int64(waitTime),
+		QueueUrl:              &queueUrl,
+		MaxNumberOfMessages:   aws.Int64(maxMessages),
+		WaitTimeSeconds:       aws.Int64(waitTime),
+		AttributeNames:        []*string{aws.String(sqs.QueueAttributeNameAll)},
+		MessageAttributeNames: []*string{aws.String(sqs.QueueAttributeNameAll)},
+	}
+	if visibilityTimeout > 0 {
+		receiveMessageInput.VisibilityTimeout = aws.Int64(visibilityTimeout)
 	}
 	// polling
-	receiveMessageOutput, err := sqsClient.ReceiveMessage(receiveMessageInput)
+	receiveMessageOutput, err := sqsClient.ReceiveMessageWithContext(ctx, receiveMessageInput)
 	if err != nil {
-		return 0, nil, errors.New("error in retrieving message from queue:" + err.Error())
+		return nil, errors.New("error in retrieving message from queue:" + err.Error())
+	}
+	return receiveMessageOutput.Messages, nil
+}
+
+// MessageAttribute returns the value of a string attribute of the message, empty when it is not set
+func MessageAttribute(receivedMessage *sqs.Message, name string) string {
+	if attribute, ok := receivedMessage.MessageAttributes[name]; ok {
+		return aws.StringValue(attribute.StringValue)
+	}
+	return ""
+}
+
+// Name of the message attribute containing the id under which the delivery of a notification is recorded, set when a
+// notification is sent again to the queue so that it keeps the id of the original message
+const NotificationIdAttribute = "NotificationId"
+
+// NotificationId returns the id under which the delivery of the notification contained in the message is recorded:
+// the NotificationId attribute when it is set, the id of the message otherwise. The id of a message does not change
+// when it is received again
+func NotificationId(receivedMessage *sqs.Message) string {
+	if notificationId := MessageAttribute(receivedMessage, NotificationIdAttribute); notificationId != "" {
+		return notificationId
+	}
+	return aws.StringValue(receivedMessage.MessageId)
+}
+
+// ParseJsonMessage stores the body of the message, in JSON format, in the structure provided by the caller
+func ParseJsonMessage(receivedMessage *sqs.Message, message interface{}) error {
+	return json.Unmarshal([]byte(aws.StringValue(receivedMessage.Body)), message)
+}
+
+// ReceiveCount returns the number of times the message has been received from the queue, 0 when it is not known
+func ReceiveCount(receivedMessage *sqs.Message) int64 {
+	count, _ := strconv.ParseInt(aws.StringValue(receivedMessage.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]), 10, 64)
+	return count
+}
+
+// GroupId returns the MessageGroupId of a message read from a FIFO queue, empty for the other queues
+func GroupId(receivedMessage *sqs.Message) string {
+	return aws.StringValue(receivedMessage.Attributes[sqs.MessageSystemAttributeNameMessageGroupId])
+}
+
+// GroupMessages splits the messages by MessageGroupId, keeping the order in which they have been received. The groups
+// are returned in the order of their first message, and each message without a group forms a group of its own
+func GroupMessages(receivedMessages []*sqs.Message) [][]*sqs.Message {
+	var groups [][]*sqs.Message
+	groupIndexes := make(map[string]int)
+	for _, receivedMessage := range receivedMessages {
+		groupId := GroupId(receivedMessage)
+		if index, ok := groupIndexes[groupId]; ok && groupId != "" {
+			groups[index] = append(groups[index], receivedMessage)
+			continue
+		}
+		groupIndexes[groupId] = len(groups)
+		groups = append(groups, []*sqs.Message{receivedMessage})
 	}
-	receivedMessages := receiveMessageOutput.Messages
-	if len(receivedMessages) == 0 {
-		return 0, nil, nil
+	return groups
+}
+
+// SendMessageToQueue sends a message with the given body and string attributes, the empty ones are omitted, to the
+// queue with the given url.
+// The FIFO queues also require the id of the group of the message, "notifications" when empty, and a
+// deduplication id, since two messages with the same deduplication id are delivered once
+func SendMessageToQueue(ctx context.Context, sqsClient sqsiface.SQSAPI, queueUrl string, body string, groupId string, deduplicationId string, attributes map[string]string) error {
+	sendMessageInput := &sqs.SendMessageInput{
+		QueueUrl:          &queueUrl,
+		MessageBody:       aws.String(body),
+		MessageAttributes: make(map[string]*sqs.MessageAttributeValue),
+	}
+	for name, value := range attributes {
+		if value == "" {
+			// SQS does not accept empty attributes
+			continue
+		}
+		sendMessageInput.MessageAttributes[name] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(value),
+		}
 	}
-	// parsing
-	messageBody := *receivedMessages[0].Body
-	err = json.Unmarshal([]byte(messageBody), message)
+	if strings.HasSuffix(queueUrl, ".fifo") {
+		if groupId == "" {
+			groupId = "notifications"
+		}
+		sendMessageInput.MessageGroupId = aws.String(groupId)
+		sendMessageInput.MessageDeduplicationId = aws.String(deduplicationId)
+	}
+	_, err := sqsClient.SendMessageWithContext(ctx, sendMessageInput)
 	if err != nil {
-		return 1, nil, errors.New("error in parsing the received message:" + err.Error())
+		return errors.New("error in sending the message to the queue:" + err.Error())
 	}
-	return 1, receivedMessages[0].ReceiptHandle, nil
+	return nil
+}
+
+// ExtendMessageVisibility hides the message with the provided handler to the other consumers of the queue with the
+// given url for visibilityTimeout seconds from now
+func ExtendMessageVisibility(ctx context.Context, sqsClient sqsiface.SQSAPI, queueUrl string, messageHandler *string, visibilityTimeout int64) error {
+	_, err := sqsClient.ChangeMessageVisibilityWithContext(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          &queueUrl,
+		ReceiptHandle:     messageHandler,
+		VisibilityTimeout: aws.Int64(visibilityTimeout),
+	})
+	if err != nil {
+		return errors.New("error in changing the visibility of the received message:" + err.Error())
+	}
+	return nil
+}
+
+// RetryVisibilityTimeout returns the visibility timeout with which a message that failed is released, so that it is
+// retried after base seconds the first time it has been received, doubled at each following receive up to max seconds
+func RetryVisibilityTimeout(receivedMessage *sqs.Message, base int64, max int64) int64 {
+	visibilityTimeout := base
+	for count := ReceiveCount(receivedMessage); count > 1 && visibilityTimeout < max; count-- {
+		visibilityTimeout *= 2
+	}
+	if visibilityTimeout > max {
+		return max
+	}
+	return visibilityTimeout
+}
+
+// ReleaseMessage makes the message visible again to the consumers of the queue with the given url, without waiting
+// for the end of its visibility timeout
+func ReleaseMessage(ctx context.Context, sqsClient sqsiface.SQSAPI, queueUrl string, messageHandler *string) error {
+	return ExtendMessageVisibility(ctx, sqsClient, queueUrl, messageHandler, 0)
 }
 
 // DeleteMessageFromQueue delete the message with the provided handler from the queue with the given url.
-func DeleteMessageFromQueue(sqsClient *sqs.SQS, queueUrl string, messageHandler *string) error {
+func DeleteMessageFromQueue(ctx context.Context, sqsClient sqsiface.SQSAPI, queueUrl string, messageHandler *string) error {
 	deleteMessageInput := &sqs.DeleteMessageInput{
 		QueueUrl:      &queueUrl,
 		ReceiptHandle: messageHandler,
 	}
-	_, err := sqsClient.DeleteMessage(deleteMessageInput)
+	_, err := sqsClient.DeleteMessageWithContext(ctx, deleteMessageInput)
 	if err != nil {
 		return errors.New("error in deleting the received message from the queue:" + err.Error())
 	}